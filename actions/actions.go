@@ -1,8 +1,11 @@
 package actions
 
 import (
-	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
 
 	"github.com/CenturyLinkLabs/prettycli"
 	"github.com/CenturyLinkLabs/zodiac/composer"
@@ -11,26 +14,44 @@ import (
 )
 
 const (
-	ProxyAddress  = "localhost:61908"
-	BasicDateTime = "2006-01-02 15:04:05"
+	ProxyAddress         = "localhost:61908"
+	BasicDateTime        = "2006-01-02 15:04:05"
+	containerNumberLabel = "com.docker.compose.container-number"
 )
 
 var (
-	DefaultComposer composer.Composer
-	endpointFactory endpoint.EndpointFactory
-	proxyFactory    proxy.ProxyFactory
+	DefaultComposer     composer.Composer
+	endpointFactory     endpoint.EndpointFactory
+	proxyFactory        proxy.ProxyFactory
+	authConfigsLoader   func(string) (endpoint.AuthConfigs, error)
+	historyStoreFactory HistoryStoreFactory
+	projectLocker       func(Options, endpoint.Endpoint) (func(), error)
+
+	// Progress receives the messages printed while an action runs.
+	Progress io.Writer
 )
 
 func init() {
-	DefaultComposer = composer.NewExecComposer(ProxyAddress)
+	DefaultComposer = composer.NewNativeComposer()
 	endpointFactory = endpoint.NewEndpoint
 	proxyFactory = proxy.NewHTTPProxy
+	authConfigsLoader = endpoint.LoadAuthConfigs
+	historyStoreFactory = NewHistoryStore
+	projectLocker = acquireLock
+	Progress = os.Stdout
 }
 
 type Options struct {
 	Args            []string
 	Flags           map[string]string
 	EndpointOptions endpoint.EndpointOptions
+	DockerConfig    string
+	History         string
+	// Endpoints lists every endpoint deploy rolls out to, EndpointOptions
+	// is set up for the first of them.
+	Endpoints []string
+	// Target is the name of the target the settings came from, if any.
+	Target string
 }
 
 type Zodiaction func(Options) (prettycli.Output, error)
@@ -38,80 +59,254 @@ type Zodiaction func(Options) (prettycli.Output, error)
 type DeploymentManifests []DeploymentManifest
 
 type DeploymentManifest struct {
+	// ID numbers the deployment. Histories saved before IDs were stored
+	// leave it out, their deployments are numbered by their place.
+	ID         int `json:",omitempty"`
 	Services   []Service
 	DeployedAt string
 	Message    string
+	Health     []HealthResult `json:",omitempty"`
+	// Pruned deployments are dropped when the history is saved, until
+	// then they keep nothing but their ID.
+	Pruned    bool `json:",omitempty"`
+	Protected bool `json:",omitempty"`
+}
+
+// id is the ID of the i-th deployment in the history.
+func (ms DeploymentManifests) id(i int) int {
+	for j := i; j >= 0; j-- {
+		if ms[j].ID > 0 {
+			return ms[j].ID + i - j
+		}
+	}
+	return i + 1
+}
+
+// nextID is the ID the next deployment gets.
+func (ms DeploymentManifests) nextID() int {
+	if len(ms) == 0 {
+		return 1
+	}
+	return ms.id(len(ms)-1) + 1
+}
+
+// index is the place of the deployment with the ID in the history, -1 if it
+// isn't there.
+func (ms DeploymentManifests) index(id int) int {
+	for i := range ms {
+		if ms.id(i) == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// retained is the history as it is saved: the pruned deployments are
+// dropped and the others carry their ID.
+func (ms DeploymentManifests) retained() DeploymentManifests {
+	var kept DeploymentManifests
+	for i, m := range ms {
+		if m.Pruned {
+			continue
+		}
+		m.ID = ms.id(i)
+		kept = append(kept, m)
+	}
+	return kept
+}
+
+// services are the names of the services deployed anywhere in the history.
+func (ms DeploymentManifests) services() map[string]bool {
+	names := map[string]bool{}
+	for _, m := range ms {
+		for _, svc := range m.Services {
+			if _, name, ok := splitContainerName(svc.Name); ok {
+				names[name] = true
+			}
+		}
+	}
+	return names
 }
 
 type Service struct {
-	OriginalImage   string
+	OriginalImage string
+	// RepoDigests are the registry digests of the image as repo@sha256:...
+	// references. Images built on the endpoint have none.
+	RepoDigests     []string `json:",omitempty"`
 	Name            string
 	ContainerConfig endpoint.ContainerConfig
+	VolumesFrom     []string `json:",omitempty"`
+	Scale           int      `json:",omitempty"`
+	// Build records what the image was built from, for built images.
+	Build *proxy.BuildInfo `json:",omitempty"`
+}
+
+// Digest is the repo@sha256:... reference of the service's image, preferring
+// the repository it was deployed from, or empty if it has no digest.
+func (s Service) Digest() string {
+	for _, d := range s.RepoDigests {
+		if imageRepository(d) == imageRepository(s.OriginalImage) {
+			return d
+		}
+	}
+	if len(s.RepoDigests) > 0 {
+		return s.RepoDigests[0]
+	}
+	return ""
+}
+
+// ImageRef is what the service's containers are created from. The digest
+// pins the image wherever the container runs, where the image ID is only
+// known on the node it was resolved on, as on a Swarm cluster.
+func (s Service) ImageRef() string {
+	if d := s.Digest(); d != "" {
+		return d
+	}
+	return s.ContainerConfig.Image
+}
+
+// imageRepository strips the tag or digest from an image name, as well as
+// the Docker Hub defaults.
+func imageRepository(name string) string {
+	if i := strings.Index(name, "@"); i >= 0 {
+		name = name[:i]
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name = name[:i]
+	}
+	name = strings.TrimPrefix(name, "docker.io/")
+	return strings.TrimPrefix(name, "library/")
+}
+
+// Instances is the number of containers the service runs. Manifests written
+// before scaling was supported have no count and ran a single container.
+func (s Service) Instances() int {
+	if s.Scale < 1 {
+		return 1
+	}
+	return s.Scale
+}
+
+// ContainerNames lists the name of every container of the service. The
+// service's Name is the first of them, the rest are numbered after it the
+// way compose numbers scaled containers.
+func (s Service) ContainerNames() []string {
+	project, name, ok := splitContainerName(s.Name)
+	if !ok || s.Instances() == 1 {
+		return []string{s.Name}
+	}
+
+	names := make([]string, s.Instances())
+	for i := range names {
+		names[i] = fmt.Sprintf("%s_%s_%d", project, name, i+1)
+	}
+	return names
+}
+
+// splitContainerName breaks a compose container name of the form
+// <project>_<service>_<number> into its project and service parts.
+func splitContainerName(name string) (string, string, bool) {
+	parts := strings.Split(name, "_")
+	if len(parts) < 3 {
+		return "", "", false
+	}
+
+	return parts[0], strings.Join(parts[1:len(parts)-1], "_"), true
+}
+
+func containerNames(services []Service) []string {
+	var names []string
+	for _, svc := range services {
+		names = append(names, svc.ContainerNames()...)
+	}
+	return names
+}
+
+func countContainers(services []Service) int {
+	count := 0
+	for _, svc := range services {
+		count += svc.Instances()
+	}
+	return count
 }
 
 func collectRequests(options Options, noBuild bool) ([]proxy.ContainerRequest, error) {
-	endpoint, err := endpointFactory(options.EndpointOptions)
+	ep, err := endpointFactory(options.EndpointOptions)
 	if err != nil {
 		return nil, err
 	}
-	ep := endpoint
 
-	p := proxyFactory(ProxyAddress, ep, noBuild)
+	if rc, ok := DefaultComposer.(composer.RequestComposer); ok {
+		return rc.Requests(options.Flags, ep, noBuild)
+	}
+
+	p := proxyFactory(ProxyAddress, ep, noBuild, composer.BuildOptions(options.Flags))
 
 	go p.Serve()
 	defer p.Stop()
 
 	if err := DefaultComposer.Run(options.Flags); err != nil {
+		// A failed build fails compose too, the proxy has the reason.
+		if _, perr := p.GetRequests(); perr != nil {
+			if _, ok := perr.(endpoint.BuildError); ok {
+				return nil, perr
+			}
+		}
 		return nil, err
 	}
 
 	return p.GetRequests()
 }
 
-func startServices(services []Service, manifests DeploymentManifests, endpoint endpoint.Endpoint) error {
-	manifestsBlob, err := json.Marshal(manifests)
-	if err != nil {
-		return err
-	}
+type containerSpec struct {
+	Name   string
+	Config endpoint.ContainerConfig
+}
 
+// containerSpecs lists the containers the given services run, each with the
+// labels zodiac adds to them.
+func containerSpecs(services []Service, historyLabels map[string]string) []containerSpec {
+	var specs []containerSpec
 	for _, svc := range services {
-		if svc.ContainerConfig.Labels == nil {
-			svc.ContainerConfig.Labels = make(map[string]string)
-		}
-		svc.ContainerConfig.Labels["zodiacManifest"] = string(manifestsBlob)
-		svc.ContainerConfig.Labels["com.centurylinklabs.zodiac.original-image"] = svc.OriginalImage
-
-		fmt.Printf("Creating %s\n", svc.Name)
+		for i, name := range svc.ContainerNames() {
+			cc := svc.ContainerConfig
+			cc.Image = svc.ImageRef()
+			cc.Labels = map[string]string{}
+			for k, v := range svc.ContainerConfig.Labels {
+				cc.Labels[k] = v
+			}
+			for k, v := range historyLabels {
+				cc.Labels[k] = v
+			}
+			cc.Labels["com.centurylinklabs.zodiac.original-image"] = svc.OriginalImage
+			if _, ok := cc.Labels[containerNumberLabel]; ok {
+				cc.Labels[containerNumberLabel] = strconv.Itoa(i + 1)
+			}
 
-		if err := endpoint.StartContainer(svc.Name, svc.ContainerConfig); err != nil {
-			return err
+			specs = append(specs, containerSpec{Name: name, Config: cc})
 		}
 	}
-
-	return nil
+	return specs
 }
 
-func getDeploymentManifests(reqs []proxy.ContainerRequest, endpoint endpoint.Endpoint) (DeploymentManifests, error) {
-	var manifests DeploymentManifests
-	var inspectError error
-
-	for _, req := range reqs {
-		ci, err := endpoint.InspectContainer(req.Name)
-		inspectError = err
-		if err != nil {
-			continue
-		}
+// removeServices removes every existing container of the given services.
+func removeServices(services []Service, endpoint endpoint.Endpoint) error {
+	removed := map[string]bool{}
+	for _, svc := range services {
+		for _, name := range svc.ContainerNames() {
+			if removed[name] {
+				continue
+			}
+			removed[name] = true
 
-		if err := json.Unmarshal([]byte(ci.Config.Labels["zodiacManifest"]), &manifests); err != nil {
-			return nil, err
+			if _, err := endpoint.InspectContainer(name); err != nil {
+				continue
+			}
+			if err := endpoint.RemoveContainer(name); err != nil {
+				return err
+			}
 		}
-
-		break
-	}
-
-	if inspectError != nil {
-		return nil, inspectError
 	}
 
-	return manifests, nil
+	return nil
 }
@@ -0,0 +1,140 @@
+package actions
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+
+	"github.com/CenturyLinkLabs/zodiac/composer"
+	"gopkg.in/yaml.v2"
+)
+
+const ProjectConfigFile = ".zodiac.yml"
+
+// UserConfigFile holds the user's own targets, relative to their home
+// directory.
+var UserConfigFile = filepath.Join(".zodiac", "config")
+
+// projectConfig holds the settings read from the .zodiac.yml file kept
+// beside the compose file.
+type projectConfig struct {
+	Health map[string]HealthCheck `yaml:"health"`
+	// Endpoints names groups of endpoints that can be deployed to together.
+	Endpoints map[string][]string `yaml:"endpoints"`
+	Targets   map[string]Target   `yaml:"targets"`
+	// Retention is applied to the history on every deploy.
+	Retention Retention `yaml:"retention"`
+}
+
+// A Target is a named set of settings, like staging or prod, selected with
+// --target. Flags given on the command line override them.
+type Target struct {
+	Endpoint  string `yaml:"endpoint"`
+	TLS       *bool  `yaml:"tls"`
+	TLSVerify *bool  `yaml:"tlsverify"`
+	TLSCaCert string `yaml:"tlscacert"`
+	TLSCert   string `yaml:"tlscert"`
+	TLSKey    string `yaml:"tlskey"`
+	History   string `yaml:"history"`
+	Name      string `yaml:"name"`
+	File      string `yaml:"file"`
+	// Defaults holds values for any other command flags, e.g.
+	// strategy: blue-green.
+	Defaults map[string]string `yaml:"defaults"`
+	// Source is the file the target was defined in.
+	Source string `yaml:"-"`
+}
+
+// Flags lists the command flags the target sets.
+func (t Target) Flags() map[string]string {
+	flags := map[string]string{}
+	for k, v := range t.Defaults {
+		flags[k] = v
+	}
+	if t.Name != "" {
+		flags["name"] = t.Name
+	}
+	if t.File != "" {
+		flags["file"] = t.File
+	}
+	return flags
+}
+
+// loadProjectConfig reads the .zodiac.yml beside the compose file named in
+// flags. A project without one has an empty config.
+func loadProjectConfig(flags map[string]string) (projectConfig, error) {
+	file := flags["file"]
+	if file == "" {
+		file = composer.DefaultComposeFile
+	}
+
+	return readConfig(filepath.Join(filepath.Dir(file), ProjectConfigFile))
+}
+
+// LoadTarget finds the named target in the project's .zodiac.yml or, failing
+// that, the user's ~/.zodiac/config.
+func LoadTarget(flags map[string]string, name string) (Target, error) {
+	config, err := loadProjectConfig(flags)
+	if err != nil {
+		return Target{}, err
+	}
+	if t, ok := config.Targets[name]; ok {
+		t.Source = ProjectConfigFile
+		return t, nil
+	}
+
+	usr, err := user.Current()
+	if err != nil {
+		return Target{}, err
+	}
+	path := filepath.Join(usr.HomeDir, UserConfigFile)
+	if config, err = readConfig(path); err != nil {
+		return Target{}, err
+	}
+	if t, ok := config.Targets[name]; ok {
+		t.Source = path
+		return t, nil
+	}
+
+	return Target{}, fmt.Errorf("Unknown target '%s', define it under targets in %s or ~/%s", name, ProjectConfigFile, UserConfigFile)
+}
+
+func readConfig(path string) (projectConfig, error) {
+	var config projectConfig
+
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return config, err
+	}
+
+	if err := yaml.Unmarshal(contents, &config); err != nil {
+		return config, fmt.Errorf("%s: %s", path, err)
+	}
+
+	return config, nil
+}
+
+// EndpointHosts expands the names of the endpoint groups defined in the
+// project's .zodiac.yml into their endpoints. Anything else is taken to be an
+// endpoint already.
+func EndpointHosts(flags map[string]string, hosts []string) ([]string, error) {
+	config, err := loadProjectConfig(flags)
+	if err != nil {
+		return nil, err
+	}
+
+	var expanded []string
+	for _, host := range hosts {
+		if group, ok := config.Endpoints[host]; ok {
+			expanded = append(expanded, group...)
+			continue
+		}
+		expanded = append(expanded, host)
+	}
+	return expanded, nil
+}
@@ -0,0 +1,53 @@
+package actions
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeProjectConfig(t *testing.T, config string) string {
+	dir, err := ioutil.TempDir("", "zodiac-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, ProjectConfigFile), []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestLoadTarget(t *testing.T) {
+	dir := writeProjectConfig(t, `targets:
+  staging:
+    endpoint: tcp://staging:2376
+    tlsverify: false
+    name: shop
+    defaults:
+      strategy: blue-green
+`)
+	defer os.RemoveAll(dir)
+
+	target, err := LoadTarget(map[string]string{"file": filepath.Join(dir, "docker-compose.yml")}, "staging")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "tcp://staging:2376", target.Endpoint)
+	assert.Nil(t, target.TLS)
+	if assert.NotNil(t, target.TLSVerify) {
+		assert.False(t, *target.TLSVerify)
+	}
+	assert.Equal(t, ProjectConfigFile, target.Source)
+	assert.Equal(t, map[string]string{"name": "shop", "strategy": "blue-green"}, target.Flags())
+}
+
+func TestLoadTarget_Unknown(t *testing.T) {
+	dir := writeProjectConfig(t, "targets:\n  staging:\n    endpoint: tcp://staging:2376\n")
+	defer os.RemoveAll(dir)
+
+	_, err := LoadTarget(map[string]string{"file": filepath.Join(dir, "docker-compose.yml")}, "prod")
+
+	assert.EqualError(t, err, "Unknown target 'prod', define it under targets in .zodiac.yml or ~/.zodiac/config")
+}
@@ -3,27 +3,101 @@ package actions
 import (
 	"encoding/json"
 	"fmt"
+	"reflect"
 	"time"
 
 	"github.com/CenturyLinkLabs/prettycli"
+	"github.com/CenturyLinkLabs/zodiac/composer"
 	"github.com/CenturyLinkLabs/zodiac/endpoint"
 	"github.com/CenturyLinkLabs/zodiac/proxy"
 )
 
 func Deploy(options Options) (prettycli.Output, error) {
-	fmt.Println("Deploying your application...")
+	strategy, err := strategyFor(options.Flags)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := loadProjectConfig(options.Flags)
+	if err != nil {
+		return nil, err
+	}
+
+	if isDryRun(options) {
+		return planDeploy(options)
+	}
+
+	if len(options.Endpoints) > 1 {
+		return deployEndpoints(options, strategy, config)
+	}
+
+	start := time.Now()
+	fmt.Fprintln(Progress, "Deploying your application...")
 
 	endpoint, err := endpointFactory(options.EndpointOptions)
 	if err != nil {
 		return nil, err
 	}
 
-	reqs, err := collectRequests(options, false)
+	auths, err := authConfigsLoader(options.DockerConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	// A composer that can build separately builds in deployTo, where the
+	// previous builds are known.
+	_, canBuild := DefaultComposer.(composer.Builder)
+	reqs, err := collectRequests(options, canBuild)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := deployTo(options, endpoint, reqs, auths, strategy, config)
 	if err != nil {
 		return nil, err
 	}
+	result.Duration = time.Since(start).String()
+
+	return ResultOutput{
+		Message: fmt.Sprintf("Successfully deployed %d container(s)", len(result.Containers)),
+		Result:  result,
+	}, nil
+}
+
+// deployTo deploys the services of the requests to one endpoint and records
+// the deployment in that endpoint's history.
+func deployTo(options Options, endpoint endpoint.Endpoint, reqs []proxy.ContainerRequest, auths endpoint.AuthConfigs, strategy deployStrategy, config projectConfig) (DeployResult, error) {
+	release, err := projectLocker(options, endpoint)
+	if err != nil {
+		return DeployResult{}, err
+	}
+	defer release()
+
+	store, err := historyStore(options, endpoint, reqs)
+	if err != nil {
+		return DeployResult{}, err
+	}
+
+	manifests, err := store.Load()
+	if err != nil {
+		return DeployResult{}, err
+	}
+
+	var previousServices []Service
+	if len(manifests) > 0 {
+		previousServices = manifests[len(manifests)-1].Services
+	}
+
+	if builder, ok := DefaultComposer.(composer.Builder); ok {
+		built, err := builder.Build(options.Flags, endpoint, reuseBuild(options, previousServices, endpoint))
+		if err != nil {
+			return DeployResult{}, err
+		}
+		reqs = withBuiltImages(reqs, built)
+	}
 
 	dm := DeploymentManifest{
+		ID:         manifests.nextID(),
 		Services:   []Service{},
 		DeployedAt: time.Now().Format(BasicDateTime),
 		Message:    options.Flags["message"],
@@ -32,49 +106,118 @@ func Deploy(options Options) (prettycli.Output, error) {
 	for _, req := range reqs {
 		s, err := serviceForRequest(req)
 		if err != nil {
-			return nil, err
+			return DeployResult{}, err
 		}
 
-		imageId, err := endpoint.ResolveImage(s.ContainerConfig.Image)
-		if err != nil {
-			return nil, err
+		// A built image is recorded by the ID its build reported rather
+		// than looked up by its tag.
+		imageId := req.ImageID
+		if imageId == "" {
+			if imageId, err = endpoint.ResolveImage(s.ContainerConfig.Image, auths.ForImage(s.ContainerConfig.Image)); err != nil {
+				return DeployResult{}, err
+			}
 		}
 
 		s.OriginalImage = s.ContainerConfig.Image
 
 		s.ContainerConfig.Image = imageId
+		s.Build = req.Build
+
+		if s.RepoDigests, err = endpoint.ImageDigests(imageId); err != nil {
+			return DeployResult{}, err
+		}
 
 		dm.Services = append(dm.Services, s)
 	}
 
-	oldManifestBlob := "[]"
-	for _, svc := range dm.Services {
-		ci, err := endpoint.InspectContainer(svc.Name)
+	if dm.Services, err = resolveVolumesFrom(dm.Services); err != nil {
+		return DeployResult{}, err
+	}
 
-		if err == nil {
-			err := endpoint.RemoveContainer(svc.Name)
-			if err != nil {
-				return nil, err
-			}
+	if err := applyScale(dm.Services, previousServices, options.Flags["scale"]); err != nil {
+		return DeployResult{}, err
+	}
+
+	// The probes are recorded as pending first, so the label history
+	// store, which can't relabel the containers once they are created,
+	// shows them as not recorded rather than leaving them out.
+	dm.Health = pendingHealth(dm.Services, config)
+
+	manifests = append(manifests, dm)
+
+	_, labelStore := store.(*LabelHistoryStore)
+	if labelStore && len(dm.Health) > 0 {
+		fmt.Fprintln(Progress, "Warning: the label history store can't record health check results, the checks will run but the history will show them as not recorded. Move the history to the file or container store with `zodiac history migrate` to keep them.")
+	}
+
+	// The history is pruned once the deploy has succeeded, so a failed
+	// deploy can still restore the deployment it replaced. The label
+	// history store writes the history on the new containers, so there it
+	// is pruned before they are created, sparing the deployment being
+	// replaced.
+	var pruned []int
+	if labelStore {
+		if pruned, err = pruneManifests(manifests[:len(manifests)-1], config.Retention, time.Now()); err != nil {
+			return DeployResult{}, err
+		}
+	} else if config.Retention.OlderThan != "" {
+		if _, err := parseAge(config.Retention.OlderThan); err != nil {
+			return DeployResult{}, err
 		}
+	}
+
+	if err := strategy(previousServices, dm.Services, manifests, store, endpoint); err != nil {
+		return DeployResult{}, err
+	}
 
-		if (ci != nil) && (ci.Config != nil) && (ci.Config.Labels != nil) && (ci.Config.Labels["zodiacManifest"] != "") {
-			oldManifestBlob = ci.Config.Labels["zodiacManifest"]
+	results, healthErr := checkHealth(dm.Services, config, endpoint)
+	save := false
+	if len(results) > 0 && !labelStore {
+		manifests[len(manifests)-1].Health = results
+		save = true
+	}
+	if healthErr == nil && !labelStore {
+		if pruned, err = pruneManifests(manifests, config.Retention, time.Now()); err != nil {
+			return DeployResult{}, err
 		}
+		save = save || len(pruned) > 0
+	}
+	if save {
+		if err := store.Save(manifests); err != nil {
+			return DeployResult{}, err
+		}
+	}
+	if len(pruned) > 0 {
+		fmt.Fprintf(Progress, "Pruned deployment(s)%s from the history\n", idList(pruned))
+	}
+	if healthErr != nil {
+		return DeployResult{}, healthErr
 	}
 
-	var manifests DeploymentManifests
-	if err := json.Unmarshal([]byte(oldManifestBlob), &manifests); err != nil {
-		return nil, err
+	// Only a deployment that made it into the history is tagged, a failed
+	// one would leave tags behind that no deployment accounts for.
+	tagImages(dm.Services, projectName(options), dm.ID, endpoint)
+
+	if config.Retention.GCImages || options.Flags["gc-images"] == "true" {
+		gc, err := collectImages(manifests, projectName(options), endpoint, false)
+		if err != nil {
+			fmt.Fprintf(Progress, "Couldn't remove unused images: %s\n", err)
+		} else if len(gc.Removed) > 0 {
+			fmt.Fprintf(Progress, "Removed %d unused image(s), reclaiming %s\n", len(gc.Removed), formatSize(gc.Reclaimed))
+		}
 	}
-	manifests = append(manifests, dm)
 
-	if err = startServices(dm.Services, manifests, endpoint); err != nil {
-		return nil, err
+	strategyName := options.Flags["strategy"]
+	if strategyName == "" {
+		strategyName = RecreateStrategy
 	}
 
-	output := fmt.Sprintf("Successfully deployed %d container(s)", len(reqs))
-	return prettycli.PlainOutput{output}, nil
+	return DeployResult{
+		DeploymentID: dm.ID,
+		Strategy:     strategyName,
+		Containers:   containerNames(dm.Services),
+		Health:       results,
+	}, nil
 }
 
 func serviceForRequest(req proxy.ContainerRequest) (Service, error) {
@@ -89,3 +232,37 @@ func serviceForRequest(req proxy.ContainerRequest) (Service, error) {
 		ContainerConfig: cc,
 	}, nil
 }
+
+// reuseBuild skips the build of a service whose context and parameters are
+// those the deployed image was built from, as long as that image is still
+// there. --no-cache and --pull always build.
+func reuseBuild(options Options, previous []Service, e endpoint.Endpoint) composer.ReuseFunc {
+	return func(name string, build proxy.BuildInfo) string {
+		if options.Flags["no-cache"] == "true" || options.Flags["pull"] == "true" {
+			return ""
+		}
+		for _, svc := range previous {
+			if svc.Name != name || svc.Build == nil || !reflect.DeepEqual(*svc.Build, build) {
+				continue
+			}
+			if _, err := e.LookupImage(svc.ContainerConfig.Image); err == nil {
+				return svc.ContainerConfig.Image
+			}
+		}
+		return ""
+	}
+}
+
+// withBuiltImages copies the requests, recording the images built for them
+// on one endpoint.
+func withBuiltImages(reqs []proxy.ContainerRequest, built map[string]composer.BuiltImage) []proxy.ContainerRequest {
+	copied := make([]proxy.ContainerRequest, len(reqs))
+	for i, req := range reqs {
+		if b, ok := built[req.Name]; ok {
+			req.ImageID = b.ID
+			req.Build = &b.Build
+		}
+		copied[i] = req
+	}
+	return copied
+}
@@ -5,23 +5,30 @@ import (
 	_ "fmt"
 	"testing"
 
+	"github.com/CenturyLinkLabs/zodiac/composer"
 	"github.com/CenturyLinkLabs/zodiac/endpoint"
 	"github.com/CenturyLinkLabs/zodiac/proxy"
+	"github.com/samalba/dockerclient"
 	"github.com/stretchr/testify/assert"
 )
 
 type mockDeployEndpoint struct {
 	mockEndpoint
 	startCallback        func(string, endpoint.ContainerConfig) error
-	resolveImageCallback func(string) (string, error)
+	resolveImageCallback func(string, *dockerclient.AuthConfig) (string, error)
+	digests              []string
+}
+
+func (e mockDeployEndpoint) ImageDigests(string) ([]string, error) {
+	return e.digests, nil
 }
 
 func (e mockDeployEndpoint) StartContainer(nm string, cfg endpoint.ContainerConfig) error {
 	return e.startCallback(nm, cfg)
 }
 
-func (e mockDeployEndpoint) ResolveImage(imgNm string) (string, error) {
-	return e.resolveImageCallback(imgNm)
+func (e mockDeployEndpoint) ResolveImage(imgNm string, auth *dockerclient.AuthConfig) (string, error) {
+	return e.resolveImageCallback(imgNm, auth)
 }
 
 func TestDeploy_Success(t *testing.T) {
@@ -29,7 +36,7 @@ func TestDeploy_Success(t *testing.T) {
 	var startCalls []capturedStartParams
 	var resolveArgs []string
 
-	proxyFactory = func(string, endpoint.Endpoint, bool) proxy.Proxy {
+	proxyFactory = func(string, endpoint.Endpoint, bool, endpoint.BuildOptions) proxy.Proxy {
 		return &mockProxy{
 			requests: []proxy.ContainerRequest{
 				{
@@ -49,7 +56,7 @@ func TestDeploy_Success(t *testing.T) {
 			})
 			return nil
 		},
-		resolveImageCallback: func(imgNm string) (string, error) {
+		resolveImageCallback: func(imgNm string, auth *dockerclient.AuthConfig) (string, error) {
 			resolveArgs = append(resolveArgs, imgNm)
 			return "xyz321", nil
 		},
@@ -79,3 +86,378 @@ func TestDeploy_Success(t *testing.T) {
 	assert.NotEqual(t, "", dm.DeployedAt)
 	assert.Equal(t, "xyz321", dm.Services[0].ContainerConfig.Image)
 }
+
+func TestDeploy_PinsDigest(t *testing.T) {
+	var startCalls []capturedStartParams
+
+	proxyFactory = func(string, endpoint.Endpoint, bool, endpoint.BuildOptions) proxy.Proxy {
+		return &mockProxy{
+			requests: []proxy.ContainerRequest{
+				{
+					Name:          "zodiac_foo_1",
+					CreateOptions: []byte(`{"Image": "registry.example.com:5000/foo:1.0"}`),
+				},
+			},
+		}
+	}
+	DefaultComposer = &mockComposer{}
+
+	e := mockDeployEndpoint{
+		startCallback: func(nm string, cfg endpoint.ContainerConfig) error {
+			startCalls = append(startCalls, capturedStartParams{Name: nm, Config: cfg})
+			return nil
+		},
+		resolveImageCallback: func(string, *dockerclient.AuthConfig) (string, error) {
+			return "xyz321", nil
+		},
+		digests: []string{
+			"mirror.example.com/foo@sha256:1111",
+			"registry.example.com:5000/foo@sha256:2222",
+		},
+	}
+	endpointFactory = func(endpoint.EndpointOptions) (endpoint.Endpoint, error) {
+		return e, nil
+	}
+
+	_, err := Deploy(Options{})
+
+	assert.NoError(t, err)
+	if assert.Len(t, startCalls, 1) {
+		assert.Equal(t, "registry.example.com:5000/foo@sha256:2222", startCalls[0].Config.Image)
+	}
+
+	dms := DeploymentManifests{}
+	assert.NoError(t, json.Unmarshal([]byte(startCalls[0].Config.Labels["zodiacManifest"]), &dms))
+	svc := dms[0].Services[0]
+	assert.Equal(t, "xyz321", svc.ContainerConfig.Image)
+	assert.Equal(t, e.digests, svc.RepoDigests)
+}
+
+func TestDeploy_BuiltImage(t *testing.T) {
+	var startCalls []capturedStartParams
+
+	proxyFactory = func(string, endpoint.Endpoint, bool, endpoint.BuildOptions) proxy.Proxy {
+		return &mockProxy{
+			requests: []proxy.ContainerRequest{
+				{
+					Name:          "zodiac_web_1",
+					CreateOptions: []byte(`{"Image": "zodiac_web"}`),
+					ImageID:       "def456",
+				},
+			},
+		}
+	}
+	DefaultComposer = &mockComposer{}
+
+	e := mockDeployEndpoint{
+		startCallback: func(nm string, cfg endpoint.ContainerConfig) error {
+			startCalls = append(startCalls, capturedStartParams{Name: nm, Config: cfg})
+			return nil
+		},
+		resolveImageCallback: func(string, *dockerclient.AuthConfig) (string, error) {
+			t.Fatal("a built image should not be resolved by its tag")
+			return "", nil
+		},
+	}
+	endpointFactory = func(endpoint.EndpointOptions) (endpoint.Endpoint, error) {
+		return e, nil
+	}
+
+	_, err := Deploy(Options{})
+
+	assert.NoError(t, err)
+	if assert.Len(t, startCalls, 1) {
+		assert.Equal(t, "def456", startCalls[0].Config.Image)
+		assert.Equal(t, "zodiac_web", startCalls[0].Config.Labels["com.centurylinklabs.zodiac.original-image"])
+	}
+}
+
+type mockBuilderComposer struct {
+	mockRequestComposer
+	reused []string
+}
+
+func (c *mockBuilderComposer) Build(flags map[string]string, e endpoint.Endpoint, reuse composer.ReuseFunc) (map[string]composer.BuiltImage, error) {
+	build := proxy.BuildInfo{ContextHash: "sha256:1234"}
+	id := reuse("zodiac_web_1", build)
+	if id != "" {
+		c.reused = append(c.reused, id)
+	} else {
+		id = "def456"
+	}
+	return map[string]composer.BuiltImage{"zodiac_web_1": {ID: id, Build: build}}, nil
+}
+
+func (c *mockBuilderComposer) BuildInfos(flags map[string]string) (map[string]proxy.BuildInfo, error) {
+	return map[string]proxy.BuildInfo{"zodiac_web_1": {ContextHash: "sha256:1234"}}, nil
+}
+
+func TestDeploy_SkipsUnchangedBuild(t *testing.T) {
+	var previous Service
+	previous.Name = "zodiac_web_1"
+	previous.ContainerConfig.Image = "abc123"
+	previous.Build = &proxy.BuildInfo{ContextHash: "sha256:1234"}
+	store := &memoryHistoryStore{manifests: DeploymentManifests{{Services: []Service{previous}}}}
+
+	c := &mockBuilderComposer{mockRequestComposer: mockRequestComposer{
+		requests: []proxy.ContainerRequest{
+			{Name: "zodiac_web_1", CreateOptions: []byte(`{"Image": "zodiac_web"}`)},
+		},
+	}}
+	defer useFixture(store, mockEndpoint{}, c)()
+
+	_, err := Deploy(Options{})
+
+	assert.NoError(t, err)
+	assert.True(t, c.noBuild)
+	assert.Equal(t, []string{"abc123"}, c.reused)
+	deployed := store.manifests[1].Services[0]
+	assert.Equal(t, "abc123", deployed.ContainerConfig.Image)
+	assert.Equal(t, previous.Build, deployed.Build)
+
+	_, err = Deploy(Options{Flags: map[string]string{"no-cache": "true"}})
+
+	assert.NoError(t, err)
+	assert.Len(t, c.reused, 1)
+	assert.Equal(t, "def456", store.manifests[2].Services[0].ContainerConfig.Image)
+}
+
+func TestServiceImageRef(t *testing.T) {
+	svc := Service{OriginalImage: "redis:3"}
+	svc.ContainerConfig.Image = "abc123"
+	assert.Equal(t, "abc123", svc.ImageRef())
+
+	svc.RepoDigests = []string{"docker.io/library/redis@sha256:3333"}
+	assert.Equal(t, "docker.io/library/redis@sha256:3333", svc.ImageRef())
+}
+
+func TestDeploy_WithRequestComposer(t *testing.T) {
+	var startCalls []capturedStartParams
+
+	proxyFactory = func(string, endpoint.Endpoint, bool, endpoint.BuildOptions) proxy.Proxy {
+		t.Fatal("the proxy should not be used with a RequestComposer")
+		return nil
+	}
+	c := &mockRequestComposer{
+		requests: []proxy.ContainerRequest{
+			{
+				Name:          "zodiac_foo_1",
+				CreateOptions: []byte(`{"Image": "foo_image"}`),
+			},
+		},
+	}
+	DefaultComposer = c
+
+	e := mockDeployEndpoint{
+		startCallback: func(nm string, cfg endpoint.ContainerConfig) error {
+			startCalls = append(startCalls, capturedStartParams{
+				Name:   nm,
+				Config: cfg,
+			})
+			return nil
+		},
+		resolveImageCallback: func(imgNm string, auth *dockerclient.AuthConfig) (string, error) {
+			return "xyz321", nil
+		},
+	}
+
+	endpointFactory = func(endpoint.EndpointOptions) (endpoint.Endpoint, error) {
+		return e, nil
+	}
+
+	o, err := Deploy(Options{})
+
+	assert.NoError(t, err)
+	assert.False(t, c.noBuild)
+	assert.Len(t, startCalls, 1)
+	assert.Equal(t, "zodiac_foo_1", startCalls[0].Name)
+	assert.Equal(t, "Successfully deployed 1 container(s)", o.ToPrettyOutput())
+}
+
+func TestDeploy_VolumesFrom(t *testing.T) {
+	var startCalls []capturedStartParams
+
+	proxyFactory = func(string, endpoint.Endpoint, bool, endpoint.BuildOptions) proxy.Proxy {
+		return &mockProxy{
+			requests: []proxy.ContainerRequest{
+				{
+					Name:          "zodiac_web_1",
+					CreateOptions: []byte(`{"Image": "web", "HostConfig": {"VolumesFrom": ["zodiac_data_1:ro", "external"]}}`),
+				},
+				{
+					Name:          "zodiac_data_1",
+					CreateOptions: []byte(`{"Image": "busybox"}`),
+				},
+			},
+		}
+	}
+	DefaultComposer = &mockComposer{}
+
+	e := mockDeployEndpoint{
+		startCallback: func(nm string, cfg endpoint.ContainerConfig) error {
+			startCalls = append(startCalls, capturedStartParams{
+				Name:   nm,
+				Config: cfg,
+			})
+			return nil
+		},
+		resolveImageCallback: func(imgNm string, auth *dockerclient.AuthConfig) (string, error) {
+			return imgNm + "_id", nil
+		},
+	}
+
+	endpointFactory = func(endpoint.EndpointOptions) (endpoint.Endpoint, error) {
+		return e, nil
+	}
+
+	_, err := Deploy(Options{})
+
+	assert.NoError(t, err)
+	assert.Len(t, startCalls, 2)
+	assert.Equal(t, "zodiac_data_1", startCalls[0].Name)
+	assert.Equal(t, "zodiac_web_1", startCalls[1].Name)
+	assert.Equal(t, []string{"zodiac_data_1:ro", "external"}, startCalls[1].Config.HostConfig.VolumesFrom)
+
+	dms := DeploymentManifests{}
+	err = json.Unmarshal([]byte(startCalls[1].Config.Labels["zodiacManifest"]), &dms)
+	assert.NoError(t, err)
+	assert.Equal(t, "zodiac_data_1", dms[0].Services[0].Name)
+	assert.Equal(t, []string{"zodiac_data_1"}, dms[0].Services[1].VolumesFrom)
+}
+
+func TestDeploy_PassesRegistryAuth(t *testing.T) {
+	var resolveAuths []*dockerclient.AuthConfig
+	var loadedFrom string
+
+	proxyFactory = func(string, endpoint.Endpoint, bool, endpoint.BuildOptions) proxy.Proxy {
+		return &mockProxy{
+			requests: []proxy.ContainerRequest{
+				{
+					Name:          "zodiac_private_1",
+					CreateOptions: []byte(`{"Image": "registry.example.com/private"}`),
+				},
+				{
+					Name:          "zodiac_public_1",
+					CreateOptions: []byte(`{"Image": "public"}`),
+				},
+			},
+		}
+	}
+	DefaultComposer = &mockComposer{}
+	authConfigsLoader = func(path string) (endpoint.AuthConfigs, error) {
+		loadedFrom = path
+		return endpoint.AuthConfigs{
+			"registry.example.com": dockerclient.AuthConfig{Username: "user", Password: "pass"},
+		}, nil
+	}
+	defer func() {
+		authConfigsLoader = func(string) (endpoint.AuthConfigs, error) {
+			return endpoint.AuthConfigs{}, nil
+		}
+	}()
+
+	e := mockDeployEndpoint{
+		startCallback: func(string, endpoint.ContainerConfig) error {
+			return nil
+		},
+		resolveImageCallback: func(imgNm string, auth *dockerclient.AuthConfig) (string, error) {
+			resolveAuths = append(resolveAuths, auth)
+			return "xyz321", nil
+		},
+	}
+
+	endpointFactory = func(endpoint.EndpointOptions) (endpoint.Endpoint, error) {
+		return e, nil
+	}
+
+	_, err := Deploy(Options{DockerConfig: "/tmp/config.json"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "/tmp/config.json", loadedFrom)
+	assert.Len(t, resolveAuths, 2)
+	assert.Equal(t, "user", resolveAuths[0].Username)
+	assert.Nil(t, resolveAuths[1])
+}
+
+func TestDeploy_Scale(t *testing.T) {
+	var startCalls []capturedStartParams
+
+	proxyFactory = func(string, endpoint.Endpoint, bool, endpoint.BuildOptions) proxy.Proxy {
+		return &mockProxy{
+			requests: []proxy.ContainerRequest{
+				{
+					Name:          "zodiac_web_1",
+					CreateOptions: []byte(`{"Image": "web", "Labels": {"com.docker.compose.container-number": "1"}}`),
+				},
+				{
+					Name:          "zodiac_db_1",
+					CreateOptions: []byte(`{"Image": "db"}`),
+				},
+			},
+		}
+	}
+	DefaultComposer = &mockComposer{}
+
+	e := mockDeployEndpoint{
+		startCallback: func(nm string, cfg endpoint.ContainerConfig) error {
+			startCalls = append(startCalls, capturedStartParams{
+				Name:   nm,
+				Config: cfg,
+			})
+			return nil
+		},
+		resolveImageCallback: func(imgNm string, auth *dockerclient.AuthConfig) (string, error) {
+			return imgNm, nil
+		},
+	}
+
+	endpointFactory = func(endpoint.EndpointOptions) (endpoint.Endpoint, error) {
+		return e, nil
+	}
+
+	o, err := Deploy(Options{Flags: map[string]string{"scale": "web=3"}})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Successfully deployed 4 container(s)", o.ToPrettyOutput())
+	assert.Len(t, startCalls, 4)
+	assert.Equal(t, "zodiac_web_2", startCalls[1].Name)
+	assert.Equal(t, "2", startCalls[1].Config.Labels["com.docker.compose.container-number"])
+	assert.Equal(t, "zodiac_db_1", startCalls[3].Name)
+
+	dms := DeploymentManifests{}
+	err = json.Unmarshal([]byte(startCalls[0].Config.Labels["zodiacManifest"]), &dms)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, dms[0].Services[0].Scale)
+	assert.Equal(t, 0, dms[0].Services[1].Scale)
+}
+
+func TestDeploy_ScaleUnknownService(t *testing.T) {
+	proxyFactory = func(string, endpoint.Endpoint, bool, endpoint.BuildOptions) proxy.Proxy {
+		return &mockProxy{
+			requests: []proxy.ContainerRequest{
+				{
+					Name:          "zodiac_web_1",
+					CreateOptions: []byte(`{"Image": "web"}`),
+				},
+			},
+		}
+	}
+	DefaultComposer = &mockComposer{}
+
+	e := mockDeployEndpoint{
+		startCallback: func(nm string, cfg endpoint.ContainerConfig) error {
+			return nil
+		},
+		resolveImageCallback: func(imgNm string, auth *dockerclient.AuthConfig) (string, error) {
+			return imgNm, nil
+		},
+	}
+
+	endpointFactory = func(endpoint.EndpointOptions) (endpoint.Endpoint, error) {
+		return e, nil
+	}
+
+	_, err := Deploy(Options{Flags: map[string]string{"scale": "db=2"}})
+
+	assert.EqualError(t, err, "No such service: db")
+}
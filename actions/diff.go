@@ -0,0 +1,343 @@
+package actions
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/CenturyLinkLabs/prettycli"
+	"github.com/CenturyLinkLabs/zodiac/endpoint"
+)
+
+const (
+	ServiceAdded   = "added"
+	ServiceRemoved = "removed"
+	ServiceChanged = "changed"
+)
+
+// A ServiceDiff is how one service differs between two deployments.
+type ServiceDiff struct {
+	Service string
+	Change  string
+	Fields  []FieldChange `json:",omitempty"`
+}
+
+// A FieldChange is a setting of a service that differs between two
+// deployments. Nested container settings are named by their path, e.g.
+// HostConfig.PortBindings.80/tcp or Env.DATABASE_URL.
+type FieldChange struct {
+	Field string
+	From  string
+	To    string
+}
+
+type DiffResult struct {
+	From     int
+	To       int
+	Services []ServiceDiff
+}
+
+func Diff(options Options) (prettycli.Output, error) {
+
+	endpoint, err := endpointFactory(options.EndpointOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	reqs, err := collectRequests(options, true)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := historyStore(options, endpoint, reqs)
+	if err != nil {
+		return nil, err
+	}
+
+	manifests, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	from, to, err := diffTargets(manifests, options.Args)
+	if err != nil {
+		return nil, err
+	}
+
+	reveal := options.Flags["show-secrets"] == "true"
+	diffs, err := diffServices(manifests[manifests.index(from)].Services, manifests[manifests.index(to)].Services, reveal)
+	if err != nil {
+		return nil, err
+	}
+
+	return ResultOutput{
+		Message: formatDiff(from, to, diffs),
+		Result:  DiffResult{From: from, To: to, Services: diffs},
+	}, nil
+}
+
+// diffTargets works out the IDs of the two deployments to compare. Without
+// arguments the previous deployment is compared with the current one, with
+// one argument that deployment is compared with the current one.
+func diffTargets(manifests DeploymentManifests, args []string) (int, int, error) {
+	if len(args) > 2 {
+		return 0, 0, errors.New("Specify at most two deployment IDs to compare")
+	}
+
+	ids := []int{0, manifests.id(len(manifests) - 1)}
+	if prev := previousDeployment(manifests, len(manifests)-1); prev >= 0 {
+		ids[0] = manifests.id(prev)
+	}
+	for i, arg := range args {
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			return 0, 0, fmt.Errorf("Invalid deployment ID '%s'", arg)
+		}
+		ids[i] = id
+	}
+
+	for _, id := range ids {
+		if id == 0 && len(args) == 0 {
+			return 0, 0, errors.New("There are no previous deployments to compare with")
+		}
+		if _, err := checkDeployment(manifests, id); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return ids[0], ids[1], nil
+}
+
+// diffServices compares the services of two deployments by name. Services are
+// listed in the order of the newer deployment, followed by the removed ones.
+// Unless reveal is set, the values of env vars that look like credentials are
+// masked as show masks them.
+func diffServices(from, to []Service, reveal bool) ([]ServiceDiff, error) {
+	old := map[string]Service{}
+	for _, svc := range from {
+		old[svc.Name] = svc
+	}
+
+	var diffs []ServiceDiff
+	seen := map[string]bool{}
+	for _, svc := range to {
+		seen[svc.Name] = true
+		prev, ok := old[svc.Name]
+		if !ok {
+			diffs = append(diffs, ServiceDiff{Service: svc.Name, Change: ServiceAdded})
+			continue
+		}
+
+		fields, err := diffService(prev, svc, reveal)
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) > 0 {
+			diffs = append(diffs, ServiceDiff{Service: svc.Name, Change: ServiceChanged, Fields: fields})
+		}
+	}
+
+	for _, svc := range from {
+		if !seen[svc.Name] {
+			diffs = append(diffs, ServiceDiff{Service: svc.Name, Change: ServiceRemoved})
+		}
+	}
+
+	return diffs, nil
+}
+
+func diffService(from, to Service, reveal bool) ([]FieldChange, error) {
+	var changes []FieldChange
+	add := func(field string, a, b interface{}) {
+		if reflect.DeepEqual(a, b) {
+			return
+		}
+		change := FieldChange{Field: field, From: formatValue(a), To: formatValue(b)}
+		if !reveal && strings.HasPrefix(field, "Env.") && secretEnvPattern.MatchString(strings.TrimPrefix(field, "Env.")) {
+			change.From, change.To = maskValue(change.From), maskValue(change.To)
+		}
+		changes = append(changes, change)
+	}
+
+	add("Image", from.OriginalImage, to.OriginalImage)
+	add("ImageID", from.ContainerConfig.Image, to.ContainerConfig.Image)
+	add("Scale", from.Instances(), to.Instances())
+	add("VolumesFrom", nonEmpty(from.VolumesFrom), nonEmpty(to.VolumesFrom))
+
+	a, err := flattenConfig(from.ContainerConfig)
+	if err != nil {
+		return nil, err
+	}
+	b, err := flattenConfig(to.ContainerConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields []string
+	for field := range a {
+		fields = append(fields, field)
+	}
+	for field := range b {
+		if _, ok := a[field]; !ok {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		add(field, a[field], b[field])
+	}
+
+	return changes, nil
+}
+
+// flattenConfig turns a container config into a map of the settings it
+// sets, keyed by their path. Unset settings are left out so that a default
+// and a missing value compare equal.
+func flattenConfig(cc endpoint.ContainerConfig) (map[string]interface{}, error) {
+	j, err := json.Marshal(cc)
+	if err != nil {
+		return nil, err
+	}
+
+	var generic map[string]interface{}
+	if err := json.Unmarshal(j, &generic); err != nil {
+		return nil, err
+	}
+	// The image ID is compared with the original image.
+	delete(generic, "Image")
+
+	fields := map[string]interface{}{}
+	flatten("", generic, fields)
+	return fields, nil
+}
+
+func flatten(path string, value interface{}, fields map[string]interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if path != "" {
+				key = path + "." + key
+			}
+			flatten(key, child, fields)
+		}
+	case []interface{}:
+		if path == "Env" {
+			for _, e := range v {
+				parts := strings.SplitN(fmt.Sprint(e), "=", 2)
+				if len(parts) == 1 {
+					parts = append(parts, "")
+				}
+				fields["Env."+parts[0]] = parts[1]
+			}
+			return
+		}
+		if len(v) > 0 {
+			fields[path] = v
+		}
+	default:
+		if nonEmpty(v) != nil {
+			fields[path] = v
+		}
+	}
+}
+
+// nonEmpty is nil for the zero value of a setting, so that unset and empty
+// settings compare equal.
+func nonEmpty(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Map:
+		if rv.Len() == 0 {
+			return nil
+		}
+	default:
+		if reflect.DeepEqual(v, reflect.Zero(rv.Type()).Interface()) {
+			return nil
+		}
+	}
+	return v
+}
+
+// maskValue hides a set value, leaving an unset one empty so that adding or
+// removing the variable still shows.
+func maskValue(v string) string {
+	if v == "" {
+		return ""
+	}
+	return maskedValue
+}
+
+func formatValue(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	j, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(j)
+}
+
+func formatDiff(from, to int, diffs []ServiceDiff) string {
+	if len(diffs) == 0 {
+		return fmt.Sprintf("No differences between deployment %d and %d", from, to)
+	}
+
+	lines := []string{fmt.Sprintf("Changes from deployment %d to %d:", from, to)}
+	for _, d := range diffs {
+		switch d.Change {
+		case ServiceAdded:
+			lines = append(lines, fmt.Sprintf("+ %s", d.Service))
+		case ServiceRemoved:
+			lines = append(lines, fmt.Sprintf("- %s", d.Service))
+		default:
+			lines = append(lines, fmt.Sprintf("~ %s", d.Service))
+			for _, f := range d.Fields {
+				lines = append(lines, fmt.Sprintf("    %s: %s -> %s", f.Field, displayValue(f.From), displayValue(f.To)))
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func displayValue(s string) string {
+	if s == "" {
+		return "(none)"
+	}
+	return s
+}
+
+// summarizeChanges describes a diff in a few words for list --changes, e.g.
+// "web (Image, Env.DEBUG), +worker, -cache".
+func summarizeChanges(diffs []ServiceDiff) string {
+	var parts []string
+	for _, d := range diffs {
+		switch d.Change {
+		case ServiceAdded:
+			parts = append(parts, "+"+d.Service)
+		case ServiceRemoved:
+			parts = append(parts, "-"+d.Service)
+		default:
+			var fields []string
+			for _, f := range d.Fields {
+				fields = append(fields, f.Field)
+			}
+			parts = append(parts, fmt.Sprintf("%s (%s)", d.Service, strings.Join(fields, ", ")))
+		}
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ", ")
+}
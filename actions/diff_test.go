@@ -0,0 +1,114 @@
+package actions
+
+import (
+	"testing"
+
+	"github.com/samalba/dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func diffFixture() DeploymentManifests {
+	web := Service{Name: "app_web_1", OriginalImage: "nginx:1.9"}
+	web.ContainerConfig.Image = "sha-1"
+	web.ContainerConfig.Env = []string{"DEBUG=false", "PORT=80"}
+	web.ContainerConfig.Cmd = []string{"nginx"}
+	web.ContainerConfig.HostConfig.PortBindings = map[string][]dockerclient.PortBinding{
+		"80/tcp": {{HostPort: "8080"}},
+	}
+
+	newWeb := Service{Name: "app_web_1", OriginalImage: "nginx:1.10", Scale: 2}
+	newWeb.ContainerConfig.Image = "sha-2"
+	newWeb.ContainerConfig.Env = []string{"PORT=80", "DEBUG=true"}
+	newWeb.ContainerConfig.Cmd = []string{"nginx"}
+	newWeb.ContainerConfig.HostConfig.PortBindings = map[string][]dockerclient.PortBinding{
+		"80/tcp": {{HostPort: "9090"}},
+	}
+
+	return DeploymentManifests{
+		{Services: []Service{web, {Name: "app_cache_1"}, {Name: "app_db_1"}}},
+		{Services: []Service{newWeb, {Name: "app_db_1"}, {Name: "app_worker_1"}}},
+	}
+}
+
+func TestDiffServices(t *testing.T) {
+	manifests := diffFixture()
+
+	diffs, err := diffServices(manifests[0].Services, manifests[1].Services, false)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []ServiceDiff{
+		{Service: "app_web_1", Change: ServiceChanged, Fields: []FieldChange{
+			{Field: "Image", From: "nginx:1.9", To: "nginx:1.10"},
+			{Field: "ImageID", From: "sha-1", To: "sha-2"},
+			{Field: "Scale", From: "1", To: "2"},
+			{Field: "Env.DEBUG", From: "false", To: "true"},
+			{Field: "HostConfig.PortBindings.80/tcp", From: `[{"HostIp":"","HostPort":"8080"}]`, To: `[{"HostIp":"","HostPort":"9090"}]`},
+		}},
+		{Service: "app_worker_1", Change: ServiceAdded},
+		{Service: "app_cache_1", Change: ServiceRemoved},
+	}, diffs)
+	assert.Equal(t, "app_web_1 (Image, ImageID, Scale, Env.DEBUG, HostConfig.PortBindings.80/tcp), +app_worker_1, -app_cache_1", summarizeChanges(diffs))
+}
+
+func TestDiffServices_MasksSecrets(t *testing.T) {
+	from := Service{Name: "app_web_1"}
+	from.ContainerConfig.Env = []string{"DB_PASSWORD=hunter2"}
+	to := Service{Name: "app_web_1"}
+	to.ContainerConfig.Env = []string{"DB_PASSWORD=hunter3", "API_KEY=xyz"}
+
+	diffs, err := diffServices([]Service{from}, []Service{to}, false)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []FieldChange{
+		{Field: "Env.API_KEY", From: "", To: "********"},
+		{Field: "Env.DB_PASSWORD", From: "********", To: "********"},
+	}, diffs[0].Fields)
+
+	diffs, err = diffServices([]Service{from}, []Service{to}, true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, FieldChange{Field: "Env.DB_PASSWORD", From: "hunter2", To: "hunter3"}, diffs[0].Fields[1])
+}
+
+func TestDiffServices_Unchanged(t *testing.T) {
+	svc := Service{Name: "app_web_1", VolumesFrom: []string{}}
+	svc.ContainerConfig.Env = []string{}
+
+	diffs, err := diffServices([]Service{{Name: "app_web_1"}}, []Service{svc}, false)
+
+	assert.NoError(t, err)
+	assert.Empty(t, diffs)
+	assert.Equal(t, "No differences between deployment 1 and 2", formatDiff(1, 2, diffs))
+}
+
+func TestDiff(t *testing.T) {
+	store := &memoryHistoryStore{manifests: diffFixture()}
+	defer useFixture(store, &mockEndpoint{}, &mockComposer{})()
+
+	o, err := Diff(Options{Args: []string{"2", "1"}})
+
+	assert.NoError(t, err)
+	result := o.(ResultOutput).Result.(DiffResult)
+	assert.Equal(t, 2, result.From)
+	assert.Equal(t, 1, result.To)
+	assert.Len(t, result.Services, 3)
+	assert.Contains(t, o.ToPrettyOutput(), "Changes from deployment 2 to 1:\n~ app_web_1\n    Image: nginx:1.10 -> nginx:1.9\n")
+	assert.Contains(t, o.ToPrettyOutput(), "\n+ app_cache_1\n- app_worker_1")
+}
+
+func TestDiffTargets(t *testing.T) {
+	manifests := diffFixture()
+
+	from, to, err := diffTargets(manifests, []string{})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, []int{from, to})
+
+	_, _, err = diffTargets(manifests, []string{"3"})
+	assert.EqualError(t, err, "Deployment 3 does not exist")
+
+	_, _, err = diffTargets(manifests, []string{"web"})
+	assert.EqualError(t, err, "Invalid deployment ID 'web'")
+
+	_, _, err = diffTargets(manifests[:1], []string{})
+	assert.EqualError(t, err, "There are no previous deployments to compare with")
+}
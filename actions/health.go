@@ -0,0 +1,298 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/CenturyLinkLabs/zodiac/endpoint"
+)
+
+const (
+	healthLabelPrefix    = "com.centurylinklabs.zodiac.health."
+	defaultHealthTimeout = 30 * time.Second
+)
+
+var (
+	healthDial = net.DialTimeout
+	healthGet  = func(url string) (*http.Response, error) {
+		c := http.Client{Timeout: 5 * time.Second}
+		return c.Get(url)
+	}
+)
+
+// A HealthCheck declares the probes run against each container of a service
+// once it has been deployed. Checks come from the service's
+// com.centurylinklabs.zodiac.health.* labels, or from the health section of
+// the project's .zodiac.yml.
+type HealthCheck struct {
+	// Running is how long the container has to stay up, e.g. 5s.
+	Running string `yaml:"running"`
+	// TCP is a container port that has to accept connections.
+	TCP string `yaml:"tcp"`
+	// HTTP is a container port and path that has to answer a GET with a 2xx
+	// status, e.g. 8080/health.
+	HTTP string `yaml:"http"`
+	// Exec is a shell command run in the container that has to exit 0.
+	Exec string `yaml:"exec"`
+	// Timeout is how long the TCP, HTTP and exec probes are retried before
+	// they fail, 30s by default.
+	Timeout string `yaml:"timeout"`
+}
+
+// A HealthResult is the outcome of one probe of one container. Pending
+// results are recorded before the probes run and stay pending when the
+// history store can't be updated afterwards.
+type HealthResult struct {
+	Container string
+	Probe     string
+	Healthy   bool
+	Pending   bool   `json:",omitempty"`
+	Message   string `json:",omitempty"`
+	CheckedAt string `json:",omitempty"`
+}
+
+func (r HealthResult) status() string {
+	switch {
+	case r.Pending:
+		return "not recorded"
+	case r.Healthy:
+		return "ok"
+	default:
+		return "failed: " + r.Message
+	}
+}
+
+func (c HealthCheck) empty() bool {
+	return c.Running == "" && c.TCP == "" && c.HTTP == "" && c.Exec == ""
+}
+
+// probes names the probes of the check, as the results of probe name them.
+func (c HealthCheck) probes() []string {
+	var probes []string
+	if c.Running != "" {
+		probes = append(probes, "running "+c.Running)
+	}
+	if c.TCP != "" {
+		probes = append(probes, "tcp "+c.TCP)
+	}
+	if c.HTTP != "" {
+		probes = append(probes, "http "+c.HTTP)
+	}
+	if c.Exec != "" {
+		probes = append(probes, "exec "+c.Exec)
+	}
+	return probes
+}
+
+// healthCheckFor works out the checks for a service. Labels on the service
+// take precedence over the config file.
+func healthCheckFor(svc Service, config projectConfig) HealthCheck {
+	_, name, ok := splitContainerName(svc.Name)
+	if !ok {
+		name = svc.Name
+	}
+	check := config.Health[name]
+
+	labels := svc.ContainerConfig.Labels
+	for field, value := range map[string]*string{
+		"running": &check.Running,
+		"tcp":     &check.TCP,
+		"http":    &check.HTTP,
+		"exec":    &check.Exec,
+		"timeout": &check.Timeout,
+	} {
+		if label, ok := labels[healthLabelPrefix+field]; ok {
+			*value = label
+		}
+	}
+
+	return check
+}
+
+// pendingHealth lists the probes of every container of the services as
+// pending, before any of them has run.
+func pendingHealth(services []Service, config projectConfig) []HealthResult {
+	var results []HealthResult
+	for _, svc := range services {
+		probes := healthCheckFor(svc, config).probes()
+		for _, name := range svc.ContainerNames() {
+			for _, p := range probes {
+				results = append(results, HealthResult{Container: name, Probe: p, Pending: true})
+			}
+		}
+	}
+	return results
+}
+
+// checkHealth runs the health checks of every container of the services and
+// returns the results. The error reports the checks that failed.
+func checkHealth(services []Service, config projectConfig, e endpoint.Endpoint) ([]HealthResult, error) {
+	var results []HealthResult
+	var failures []string
+
+	for _, svc := range services {
+		check := healthCheckFor(svc, config)
+		if check.empty() {
+			continue
+		}
+
+		for _, name := range svc.ContainerNames() {
+			for _, result := range probe(name, check, e) {
+				if !result.Healthy {
+					failures = append(failures, fmt.Sprintf("%s %s (%s)", name, result.Probe, result.Message))
+				}
+				fmt.Fprintf(Progress, "Checking %s %s... %s\n", name, result.Probe, result.status())
+				results = append(results, result)
+			}
+		}
+	}
+
+	if len(failures) > 0 {
+		return results, fmt.Errorf("Health checks failed: %s", strings.Join(failures, ", "))
+	}
+	return results, nil
+}
+
+func probe(name string, check HealthCheck, e endpoint.Endpoint) []HealthResult {
+	var results []HealthResult
+	record := func(probe string, err error) {
+		result := HealthResult{
+			Container: name,
+			Probe:     probe,
+			Healthy:   err == nil,
+			CheckedAt: time.Now().Format(BasicDateTime),
+		}
+		if err != nil {
+			result.Message = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	timeout := defaultHealthTimeout
+	if check.Timeout != "" {
+		d, err := time.ParseDuration(check.Timeout)
+		if err != nil {
+			record("timeout "+check.Timeout, fmt.Errorf("invalid duration"))
+			return results
+		}
+		timeout = d
+	}
+
+	if check.Running != "" {
+		record("running "+check.Running, probeRunning(name, check.Running, e))
+	}
+	if check.TCP != "" {
+		record("tcp "+check.TCP, retry(timeout, func() error {
+			addr, err := publishedAddress(name, check.TCP, e)
+			if err != nil {
+				return err
+			}
+			conn, err := healthDial("tcp", addr, 5*time.Second)
+			if err != nil {
+				return err
+			}
+			return conn.Close()
+		}))
+	}
+	if check.HTTP != "" {
+		record("http "+check.HTTP, retry(timeout, func() error {
+			parts := strings.SplitN(check.HTTP, "/", 2)
+			addr, err := publishedAddress(name, parts[0], e)
+			if err != nil {
+				return err
+			}
+			path := ""
+			if len(parts) == 2 {
+				path = parts[1]
+			}
+
+			resp, err := healthGet(fmt.Sprintf("http://%s/%s", addr, path))
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode < 200 || resp.StatusCode > 299 {
+				return fmt.Errorf("returned %s", resp.Status)
+			}
+			return nil
+		}))
+	}
+	if check.Exec != "" {
+		record("exec "+check.Exec, retry(timeout, func() error {
+			code, err := e.Exec(name, []string{"/bin/sh", "-c", check.Exec})
+			if err != nil {
+				return err
+			}
+			if code != 0 {
+				return fmt.Errorf("exited with code %d", code)
+			}
+			return nil
+		}))
+	}
+
+	return results
+}
+
+func probeRunning(name, after string, e endpoint.Endpoint) error {
+	d, err := time.ParseDuration(after)
+	if err != nil {
+		return errors.New("invalid duration")
+	}
+	time.Sleep(d)
+
+	ci, err := e.InspectContainer(name)
+	if err != nil {
+		return err
+	}
+	if ci.State == nil || !ci.State.Running {
+		exitCode := 0
+		if ci.State != nil {
+			exitCode = ci.State.ExitCode
+		}
+		return fmt.Errorf("not running, exited with code %d", exitCode)
+	}
+	return nil
+}
+
+// publishedAddress finds the address on the endpoint's host that a container
+// port is published on.
+func publishedAddress(name, port string, e endpoint.Endpoint) (string, error) {
+	if !strings.Contains(port, "/") {
+		port += "/tcp"
+	}
+
+	ci, err := e.InspectContainer(name)
+	if err != nil {
+		return "", err
+	}
+
+	bindings := ci.NetworkSettings.Ports[port]
+	if len(bindings) == 0 || bindings[0].HostPort == "" {
+		return "", fmt.Errorf("port %s isn't published", port)
+	}
+
+	host := e.Host()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "" {
+		host = "localhost"
+	}
+
+	return net.JoinHostPort(host, bindings[0].HostPort), nil
+}
+
+func retry(timeout time.Duration, f func() error) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := f()
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(pollInterval)
+	}
+}
@@ -0,0 +1,172 @@
+package actions
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CenturyLinkLabs/zodiac/endpoint"
+	"github.com/CenturyLinkLabs/zodiac/proxy"
+	"github.com/samalba/dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockHealthEndpoint struct {
+	mockEndpoint
+	running  bool
+	exitCode int
+}
+
+func (e mockHealthEndpoint) Host() string {
+	return "docker.example.com:2376"
+}
+
+func (e mockHealthEndpoint) InspectContainer(nm string) (*dockerclient.ContainerInfo, error) {
+	ci := &dockerclient.ContainerInfo{State: &dockerclient.State{Running: e.running, ExitCode: 2}}
+	ci.NetworkSettings.Ports = map[string][]dockerclient.PortBinding{
+		"8080/tcp": {{HostIp: "0.0.0.0", HostPort: "49153"}},
+	}
+	return ci, nil
+}
+
+func (e mockHealthEndpoint) Exec(nm string, cmd []string) (int, error) {
+	return e.exitCode, nil
+}
+
+func stubHealthNetwork(t *testing.T, status int) func() {
+	dial, get := healthDial, healthGet
+	healthDial = func(network, addr string, timeout time.Duration) (net.Conn, error) {
+		assert.Equal(t, "docker.example.com:49153", addr)
+		client, server := net.Pipe()
+		server.Close()
+		return client, nil
+	}
+	healthGet = func(url string) (*http.Response, error) {
+		assert.Equal(t, "http://docker.example.com:49153/health", url)
+		return &http.Response{
+			StatusCode: status,
+			Status:     http.StatusText(status),
+			Body:       ioutil.NopCloser(strings.NewReader("")),
+		}, nil
+	}
+
+	return func() {
+		healthDial, healthGet = dial, get
+	}
+}
+
+func TestHealthCheckFor(t *testing.T) {
+	svc := Service{Name: "app_web_1"}
+	svc.ContainerConfig.Labels = map[string]string{
+		"com.centurylinklabs.zodiac.health.http": "8080/health",
+	}
+	config := projectConfig{Health: map[string]HealthCheck{
+		"web": {HTTP: "80/", Timeout: "1m"},
+	}}
+
+	check := healthCheckFor(svc, config)
+
+	assert.Equal(t, HealthCheck{HTTP: "8080/health", Timeout: "1m"}, check)
+}
+
+func TestCheckHealth_Healthy(t *testing.T) {
+	defer stubHealthNetwork(t, 200)()
+	svc := Service{Name: "app_web_1", Scale: 2}
+	svc.ContainerConfig.Labels = map[string]string{
+		"com.centurylinklabs.zodiac.health.running": "1ms",
+		"com.centurylinklabs.zodiac.health.tcp":     "8080",
+		"com.centurylinklabs.zodiac.health.http":    "8080/health",
+		"com.centurylinklabs.zodiac.health.exec":    "pg_isready",
+	}
+
+	results, err := checkHealth([]Service{svc, {Name: "app_db_1"}}, projectConfig{}, mockHealthEndpoint{running: true})
+
+	assert.NoError(t, err)
+	assert.Len(t, results, 8)
+	assert.Equal(t, "app_web_2", results[4].Container)
+	assert.Equal(t, "running 1ms", results[4].Probe)
+	for _, r := range results {
+		assert.True(t, r.Healthy, r.Probe)
+	}
+}
+
+func TestCheckHealth_Unhealthy(t *testing.T) {
+	defer stubHealthNetwork(t, 503)()
+	svc := Service{Name: "app_web_1"}
+	config := projectConfig{Health: map[string]HealthCheck{
+		"web": {Running: "1ms", HTTP: "8080/health", Exec: "false", Timeout: "1ms"},
+	}}
+
+	results, err := checkHealth([]Service{svc}, config, mockHealthEndpoint{exitCode: 1})
+
+	assert.EqualError(t, err, "Health checks failed: app_web_1 running 1ms (not running, exited with code 2), app_web_1 http 8080/health (returned Service Unavailable), app_web_1 exec false (exited with code 1)")
+	assert.Len(t, results, 3)
+	assert.False(t, results[0].Healthy)
+}
+
+func TestPublishedAddress_NotPublished(t *testing.T) {
+	_, err := publishedAddress("app_web_1", "9000", mockHealthEndpoint{})
+
+	assert.EqualError(t, err, "port 9000/tcp isn't published")
+}
+
+func TestDeploy_RecordsHealth(t *testing.T) {
+	defer stubHealthNetwork(t, 200)()
+	store := &memoryHistoryStore{}
+	defer useFixture(store, mockHealthEndpoint{running: false}, &mockRequestComposer{requests: []proxy.ContainerRequest{{
+		Name:          "app_web_1",
+		CreateOptions: []byte(`{"Image": "web", "Labels": {"com.centurylinklabs.zodiac.health.running": "1ms"}}`),
+	}}})()
+
+	_, err := Deploy(Options{})
+
+	assert.EqualError(t, err, "Health checks failed: app_web_1 running 1ms (not running, exited with code 2)")
+	assert.Len(t, store.manifests, 1)
+	assert.Len(t, store.manifests[0].Health, 1)
+	assert.False(t, store.manifests[0].Health[0].Healthy)
+}
+
+func TestDeploy_LabelStoreKeepsHealthPending(t *testing.T) {
+	defer stubHealthNetwork(t, 200)()
+	e := newMockEngineEndpoint()
+	defer saveFactories()()
+	endpointFactory = func(endpoint.EndpointOptions) (endpoint.Endpoint, error) {
+		return e, nil
+	}
+	DefaultComposer = &mockRequestComposer{requests: []proxy.ContainerRequest{{
+		Name:          "app_web_1",
+		CreateOptions: []byte(`{"Image": "web", "Labels": {"com.centurylinklabs.zodiac.health.running": "1ms"}}`),
+	}}}
+	var progress bytes.Buffer
+	stdout := Progress
+	Progress = &progress
+	defer func() { Progress = stdout }()
+
+	_, err := Deploy(Options{})
+
+	assert.NoError(t, err)
+	assert.Contains(t, progress.String(), "Warning: the label history store can't record health check results")
+	var manifests DeploymentManifests
+	assert.NoError(t, json.Unmarshal([]byte(e.containers["app_web_1"].Labels[manifestLabel]), &manifests))
+	assert.Equal(t, []HealthResult{{Container: "app_web_1", Probe: "running 1ms", Pending: true}}, manifests[0].Health)
+}
+
+func TestRetry(t *testing.T) {
+	attempts := 0
+	err := retry(time.Second, func() error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("not yet")
+		}
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+}
@@ -0,0 +1,284 @@
+package actions
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	"github.com/CenturyLinkLabs/zodiac/composer"
+	"github.com/CenturyLinkLabs/zodiac/endpoint"
+	"github.com/CenturyLinkLabs/zodiac/proxy"
+	"github.com/samalba/dockerclient"
+)
+
+const (
+	LabelHistory     = "label"
+	FileHistory      = "file"
+	ContainerHistory = "container"
+
+	manifestLabel         = "zodiacManifest"
+	HistoryContainerImage = "busybox:latest"
+)
+
+// A HistoryStore keeps the DeploymentManifests of a project.
+type HistoryStore interface {
+	// Load returns every recorded deployment, oldest first. A project that
+	// has never been deployed has an empty history, not an error.
+	Load() (DeploymentManifests, error)
+	// Labels returns the labels the deployed containers should carry for
+	// the given history.
+	Labels(DeploymentManifests) (map[string]string, error)
+	// Save records the history once its containers have been started.
+	Save(DeploymentManifests) error
+	// Remove deletes the recorded history.
+	Remove() error
+}
+
+type HistoryStoreFactory func(kind string, options Options, e endpoint.Endpoint, reqs []proxy.ContainerRequest) (HistoryStore, error)
+
+func NewHistoryStore(kind string, options Options, e endpoint.Endpoint, reqs []proxy.ContainerRequest) (HistoryStore, error) {
+	project := projectName(options)
+
+	switch kind {
+	case "", LabelHistory:
+		var names []string
+		for _, req := range reqs {
+			names = append(names, req.Name)
+		}
+		return &LabelHistoryStore{endpoint: e, containerNames: names}, nil
+	case FileHistory:
+		return NewFileHistoryStore(e, project)
+	case ContainerHistory:
+		return &ContainerHistoryStore{endpoint: e, name: historyContainerName(project)}, nil
+	}
+
+	return nil, fmt.Errorf("Unknown history store '%s', use one of: %s, %s, %s", kind, LabelHistory, FileHistory, ContainerHistory)
+}
+
+// projectName is the name compose prefixes the project's containers with.
+func projectName(options Options) string {
+	name := options.Flags["name"]
+	if name == "" {
+		name = composer.DefaultProjectName
+	}
+	return composer.NormalizeProjectName(name)
+}
+
+func historyContainerName(project string) string {
+	return fmt.Sprintf("%s_zodiac_history", project)
+}
+
+func historyStore(options Options, e endpoint.Endpoint, reqs []proxy.ContainerRequest) (HistoryStore, error) {
+	return historyStoreFactory(options.History, options, e, reqs)
+}
+
+// LabelHistoryStore keeps the whole history as JSON in a label on every
+// deployed container. This is the original scheme: removing the containers
+// removes the history.
+type LabelHistoryStore struct {
+	endpoint       endpoint.Endpoint
+	containerNames []string
+}
+
+// Load reads the newest history found on the containers, the one that goes
+// up to the highest ID. The containers recreated by reconcile carry a newer
+// history than the ones left alone.
+func (s *LabelHistoryStore) Load() (DeploymentManifests, error) {
+	var manifests DeploymentManifests
+
+	for _, name := range s.containerNames {
+		ci, err := s.endpoint.InspectContainer(name)
+		if err == dockerclient.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if ci == nil || ci.Config == nil || ci.Config.Labels[manifestLabel] == "" {
+			continue
+		}
+
+		var found DeploymentManifests
+		if err := json.Unmarshal([]byte(ci.Config.Labels[manifestLabel]), &found); err != nil {
+			return nil, err
+		}
+		if found.nextID() > manifests.nextID() {
+			manifests = found
+		}
+	}
+
+	return manifests, nil
+}
+
+func (s *LabelHistoryStore) Labels(manifests DeploymentManifests) (map[string]string, error) {
+	blob, err := json.Marshal(manifests.retained())
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{manifestLabel: string(blob)}, nil
+}
+
+// Save has nothing to do, the history was written when the containers were
+// created with its labels.
+func (s *LabelHistoryStore) Save(DeploymentManifests) error {
+	return nil
+}
+
+// Remove has nothing to do, the history goes away with the containers.
+func (s *LabelHistoryStore) Remove() error {
+	return nil
+}
+
+// FileHistoryStore keeps the history in a JSON file on the local machine, one
+// per endpoint and project.
+type FileHistoryStore struct {
+	path string
+}
+
+func NewFileHistoryStore(e endpoint.Endpoint, project string) (*FileHistoryStore, error) {
+	usr, err := user.Current()
+	if err != nil {
+		return nil, err
+	}
+
+	host := strings.NewReplacer(":", "_", "/", "_").Replace(e.Host())
+	path := filepath.Join(usr.HomeDir, ".zodiac", "history", host, project+".json")
+
+	return &FileHistoryStore{path: path}, nil
+}
+
+func (s *FileHistoryStore) Load() (DeploymentManifests, error) {
+	var manifests DeploymentManifests
+
+	blob, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return manifests, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(blob, &manifests); err != nil {
+		return nil, fmt.Errorf("can't read deployment history from %s: %s", s.path, err)
+	}
+
+	return manifests, nil
+}
+
+func (s *FileHistoryStore) Labels(DeploymentManifests) (map[string]string, error) {
+	return map[string]string{}, nil
+}
+
+func (s *FileHistoryStore) Save(manifests DeploymentManifests) error {
+	blob, err := json.MarshalIndent(manifests.retained(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+
+	// Write beside the real file and rename so a failure can't leave a
+	// truncated history behind.
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, blob, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (s *FileHistoryStore) Remove() error {
+	err := os.Remove(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// ContainerHistoryStore keeps the history in a label on a data-only
+// container on the endpoint, so it survives the application's containers
+// being removed and is shared by everyone deploying to the endpoint.
+type ContainerHistoryStore struct {
+	endpoint endpoint.Endpoint
+	name     string
+}
+
+func (s *ContainerHistoryStore) Load() (DeploymentManifests, error) {
+	var manifests DeploymentManifests
+
+	ci, err := s.endpoint.InspectContainer(s.name)
+	if err == dockerclient.ErrNotFound {
+		return manifests, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ci == nil || ci.Config == nil || ci.Config.Labels[manifestLabel] == "" {
+		return manifests, nil
+	}
+
+	if err := json.Unmarshal([]byte(ci.Config.Labels[manifestLabel]), &manifests); err != nil {
+		return nil, err
+	}
+
+	return manifests, nil
+}
+
+func (s *ContainerHistoryStore) Labels(DeploymentManifests) (map[string]string, error) {
+	return map[string]string{}, nil
+}
+
+// Save replaces the history container with one carrying the new history.
+func (s *ContainerHistoryStore) Save(manifests DeploymentManifests) error {
+	blob, err := json.Marshal(manifests.retained())
+	if err != nil {
+		return err
+	}
+
+	image, err := s.endpoint.ResolveImage(HistoryContainerImage, nil)
+	if err != nil {
+		return err
+	}
+
+	var cc endpoint.ContainerConfig
+	cc.Image = image
+	cc.Cmd = []string{"true"}
+	cc.Labels = map[string]string{manifestLabel: string(blob)}
+
+	// Create the new container beside the old one, so the history survives
+	// a failed create, and only then swap it in.
+	tmp := s.name + "_new"
+	if err := removeIfExists(s.endpoint, tmp); err != nil {
+		return err
+	}
+	if err := s.endpoint.StartContainer(tmp, cc); err != nil {
+		removeIfExists(s.endpoint, tmp)
+		return err
+	}
+
+	if err := s.Remove(); err != nil {
+		return err
+	}
+	return s.endpoint.RenameContainer(tmp, s.name)
+}
+
+func (s *ContainerHistoryStore) Remove() error {
+	return removeIfExists(s.endpoint, s.name)
+}
+
+func removeIfExists(e endpoint.Endpoint, name string) error {
+	if _, err := e.InspectContainer(name); err != nil {
+		if err == dockerclient.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+
+	return e.RemoveContainer(name)
+}
@@ -0,0 +1,286 @@
+package actions
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/CenturyLinkLabs/zodiac/endpoint"
+	"github.com/CenturyLinkLabs/zodiac/proxy"
+	"github.com/samalba/dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockHistoryEndpoint struct {
+	mockEndpoint
+	containers map[string]endpoint.ContainerConfig
+	failStart  bool
+}
+
+func (e *mockHistoryEndpoint) InspectContainer(nm string) (*dockerclient.ContainerInfo, error) {
+	cc, ok := e.containers[nm]
+	if !ok {
+		return nil, dockerclient.ErrNotFound
+	}
+	return &dockerclient.ContainerInfo{
+		Config: &dockerclient.ContainerConfig{Image: cc.Image, Labels: cc.Labels},
+	}, nil
+}
+
+func (e *mockHistoryEndpoint) StartContainer(nm string, cc endpoint.ContainerConfig) error {
+	if e.failStart {
+		return errors.New("no such image")
+	}
+	e.containers[nm] = cc
+	return nil
+}
+
+func (e *mockHistoryEndpoint) RenameContainer(oldName, newName string) error {
+	e.containers[newName] = e.containers[oldName]
+	delete(e.containers, oldName)
+	return nil
+}
+
+func (e *mockHistoryEndpoint) RemoveContainer(nm string) error {
+	delete(e.containers, nm)
+	return nil
+}
+
+type memoryHistoryStore struct {
+	manifests DeploymentManifests
+	removed   bool
+}
+
+func (s *memoryHistoryStore) Load() (DeploymentManifests, error) {
+	return s.manifests, nil
+}
+
+func (s *memoryHistoryStore) Labels(DeploymentManifests) (map[string]string, error) {
+	return map[string]string{}, nil
+}
+
+func (s *memoryHistoryStore) Save(manifests DeploymentManifests) error {
+	s.manifests = manifests.retained()
+	return nil
+}
+
+func (s *memoryHistoryStore) Remove() error {
+	s.removed = true
+	return nil
+}
+
+func labelledConfig(labels map[string]string) endpoint.ContainerConfig {
+	var cc endpoint.ContainerConfig
+	cc.Labels = labels
+	return cc
+}
+
+func TestLabelHistoryStore_Load(t *testing.T) {
+	blob, _ := json.Marshal(DeploymentManifests{{Message: "first"}})
+	e := &mockHistoryEndpoint{containers: map[string]endpoint.ContainerConfig{
+		"zodiac_db_1": labelledConfig(map[string]string{manifestLabel: string(blob)}),
+	}}
+	s := &LabelHistoryStore{endpoint: e, containerNames: []string{"zodiac_web_1", "zodiac_db_1"}}
+
+	manifests, err := s.Load()
+
+	assert.NoError(t, err)
+	assert.Len(t, manifests, 1)
+	assert.Equal(t, "first", manifests[0].Message)
+}
+
+func TestLabelHistoryStore_LoadLongestHistory(t *testing.T) {
+	older, _ := json.Marshal(DeploymentManifests{{Message: "first"}})
+	newer, _ := json.Marshal(DeploymentManifests{{Message: "first"}, {Message: "reconcile"}})
+	e := &mockHistoryEndpoint{containers: map[string]endpoint.ContainerConfig{
+		"zodiac_web_1": labelledConfig(map[string]string{manifestLabel: string(older)}),
+		"zodiac_db_1":  labelledConfig(map[string]string{manifestLabel: string(newer)}),
+	}}
+	s := &LabelHistoryStore{endpoint: e, containerNames: []string{"zodiac_web_1", "zodiac_db_1"}}
+
+	manifests, err := s.Load()
+
+	assert.NoError(t, err)
+	assert.Len(t, manifests, 2)
+	assert.Equal(t, "reconcile", manifests[1].Message)
+}
+
+func TestLabelHistoryStore_LoadNeverDeployed(t *testing.T) {
+	e := &mockHistoryEndpoint{containers: map[string]endpoint.ContainerConfig{}}
+	s := &LabelHistoryStore{endpoint: e, containerNames: []string{"zodiac_web_1"}}
+
+	manifests, err := s.Load()
+
+	assert.NoError(t, err)
+	assert.Empty(t, manifests)
+}
+
+func TestFileHistoryStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zodiac-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := &FileHistoryStore{path: filepath.Join(dir, "tcp___host_2376", "zodiac.json")}
+
+	manifests, err := s.Load()
+	assert.NoError(t, err)
+	assert.Empty(t, manifests)
+
+	labels, err := s.Labels(DeploymentManifests{{Message: "first"}})
+	assert.NoError(t, err)
+	assert.Empty(t, labels)
+
+	assert.NoError(t, s.Save(DeploymentManifests{{Message: "first"}, {Message: "second"}}))
+	manifests, err = s.Load()
+	assert.NoError(t, err)
+	assert.Len(t, manifests, 2)
+	assert.Equal(t, "second", manifests[1].Message)
+
+	assert.NoError(t, s.Remove())
+	_, err = os.Stat(s.path)
+	assert.True(t, os.IsNotExist(err))
+	assert.NoError(t, s.Remove())
+}
+
+func TestContainerHistoryStore(t *testing.T) {
+	e := &mockHistoryEndpoint{containers: map[string]endpoint.ContainerConfig{}}
+	s := &ContainerHistoryStore{endpoint: e, name: "zodiac_zodiac_history"}
+
+	manifests, err := s.Load()
+	assert.NoError(t, err)
+	assert.Empty(t, manifests)
+
+	assert.NoError(t, s.Save(DeploymentManifests{{Message: "first"}}))
+	assert.NoError(t, s.Save(DeploymentManifests{{Message: "first"}, {Message: "second"}}))
+	assert.Len(t, e.containers, 1)
+	assert.Equal(t, "abc123", e.containers["zodiac_zodiac_history"].Image)
+
+	manifests, err = s.Load()
+	assert.NoError(t, err)
+	assert.Len(t, manifests, 2)
+
+	assert.NoError(t, s.Remove())
+	assert.Empty(t, e.containers)
+}
+
+func TestContainerHistoryStore_SaveFails(t *testing.T) {
+	e := &mockHistoryEndpoint{containers: map[string]endpoint.ContainerConfig{}}
+	s := &ContainerHistoryStore{endpoint: e, name: "zodiac_zodiac_history"}
+	assert.NoError(t, s.Save(DeploymentManifests{{Message: "first"}}))
+
+	e.failStart = true
+	err := s.Save(DeploymentManifests{{Message: "first"}, {Message: "second"}})
+
+	assert.EqualError(t, err, "no such image")
+	manifests, err := s.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, DeploymentManifests{{ID: 1, Message: "first"}}, manifests)
+}
+
+func TestNewHistoryStore(t *testing.T) {
+	e := &mockHistoryEndpoint{}
+	options := Options{Flags: map[string]string{"name": "My-App"}}
+
+	s, err := NewHistoryStore("container", options, e, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "myapp_zodiac_history", s.(*ContainerHistoryStore).name)
+
+	s, err = NewHistoryStore("", options, e, []proxy.ContainerRequest{{Name: "myapp_web_1"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"myapp_web_1"}, s.(*LabelHistoryStore).containerNames)
+
+	_, err = NewHistoryStore("bolt", options, e, nil)
+	assert.EqualError(t, err, "Unknown history store 'bolt', use one of: label, file, container")
+}
+
+func TestDeploy_FileHistory(t *testing.T) {
+	store := &memoryHistoryStore{manifests: DeploymentManifests{{Message: "first"}}}
+	e := &mockHistoryEndpoint{containers: map[string]endpoint.ContainerConfig{}}
+	defer useFixture(store, e, &mockRequestComposer{
+		requests: []proxy.ContainerRequest{{Name: "zodiac_web_1", CreateOptions: []byte(`{"Image": "web"}`)}},
+	})()
+	historyStoreFactory = func(kind string, options Options, e endpoint.Endpoint, reqs []proxy.ContainerRequest) (HistoryStore, error) {
+		assert.Equal(t, FileHistory, kind)
+		return store, nil
+	}
+
+	_, err := Deploy(Options{History: FileHistory, Flags: map[string]string{"message": "second"}})
+
+	assert.NoError(t, err)
+	assert.Len(t, store.manifests, 2)
+	assert.Equal(t, "second", store.manifests[1].Message)
+	_, labelled := e.containers["zodiac_web_1"].Labels[manifestLabel]
+	assert.False(t, labelled)
+}
+
+func TestMigrate_LabelToContainer(t *testing.T) {
+	blob, _ := json.Marshal(DeploymentManifests{
+		{Services: []Service{{Name: "zodiac_web_1"}}, Message: "first"},
+		{Services: []Service{{Name: "zodiac_web_1", OriginalImage: "web"}}, Message: "second"},
+	})
+	e := &mockHistoryEndpoint{containers: map[string]endpoint.ContainerConfig{
+		"zodiac_web_1": labelledConfig(map[string]string{manifestLabel: string(blob)}),
+	}}
+	defer saveFactories()()
+	endpointFactory = func(endpoint.EndpointOptions) (endpoint.Endpoint, error) {
+		return e, nil
+	}
+	DefaultComposer = &mockRequestComposer{
+		requests: []proxy.ContainerRequest{{Name: "zodiac_web_1"}},
+	}
+
+	o, err := Migrate(Options{Flags: map[string]string{"from": "label", "to": "container"}})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Migrated 2 deployment(s) from label to container history", o.ToPrettyOutput())
+	_, labelled := e.containers["zodiac_web_1"].Labels[manifestLabel]
+	assert.False(t, labelled)
+	assert.Equal(t, "web", e.containers["zodiac_web_1"].Labels["com.centurylinklabs.zodiac.original-image"])
+	assert.NotEmpty(t, e.containers["zodiac_zodiac_history"].Labels[manifestLabel])
+}
+
+func TestMigrate_FailureRestoresLabels(t *testing.T) {
+	blob, _ := json.Marshal(DeploymentManifests{{Services: []Service{{Name: "zodiac_web_1"}}}})
+	e := newMockEngineEndpoint()
+	e.containers["zodiac_web_1"] = labelledConfig(map[string]string{manifestLabel: string(blob)})
+	e.failStart = "zodiac_web_1"
+	defer saveFactories()()
+	endpointFactory = func(endpoint.EndpointOptions) (endpoint.Endpoint, error) {
+		return e, nil
+	}
+	DefaultComposer = &mockRequestComposer{
+		requests: []proxy.ContainerRequest{{Name: "zodiac_web_1"}},
+	}
+
+	_, err := Migrate(Options{Flags: map[string]string{"from": "label", "to": "container"}})
+
+	assert.EqualError(t, err, "port is already allocated (the previous deployment was restored)")
+	assert.Equal(t, []string{"remove zodiac_web_1", "start zodiac_web_1", "remove zodiac_web_1", "start zodiac_web_1"}, e.calls)
+	assert.NotEmpty(t, e.containers["zodiac_web_1"].Labels[manifestLabel])
+	assert.Len(t, e.containers, 1)
+}
+
+func TestMigrate_SameStore(t *testing.T) {
+	_, err := Migrate(Options{Flags: map[string]string{"from": "file", "to": "file"}})
+
+	assert.EqualError(t, err, "The history is already kept in the file store")
+}
+
+func TestMigrate_NoHistory(t *testing.T) {
+	e := &mockHistoryEndpoint{containers: map[string]endpoint.ContainerConfig{}}
+	defer saveFactories()()
+	endpointFactory = func(endpoint.EndpointOptions) (endpoint.Endpoint, error) {
+		return e, nil
+	}
+	DefaultComposer = &mockRequestComposer{
+		requests: []proxy.ContainerRequest{{Name: "zodiac_web_1"}},
+	}
+
+	_, err := Migrate(Options{Flags: map[string]string{"from": "label", "to": "container"}})
+
+	assert.EqualError(t, err, "There is no deployment history in the label store")
+}
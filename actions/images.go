@@ -0,0 +1,255 @@
+package actions
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/CenturyLinkLabs/prettycli"
+	"github.com/CenturyLinkLabs/zodiac/endpoint"
+	"github.com/samalba/dockerclient"
+)
+
+// A CollectedImage is a project image no retained deployment uses.
+type CollectedImage struct {
+	ID    string
+	Tags  []string `json:",omitempty"`
+	Size  int64
+	Error string `json:",omitempty"`
+}
+
+type ImageGCResult struct {
+	Removed   []CollectedImage
+	Failed    []CollectedImage `json:",omitempty"`
+	Reclaimed int64
+	DryRun    bool `json:",omitempty"`
+}
+
+// ImagesGC removes the project's images that none of the retained
+// deployments use.
+func ImagesGC(options Options) (prettycli.Output, error) {
+
+	endpoint, err := endpointFactory(options.EndpointOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	reqs, err := collectRequests(options, true)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := historyStore(options, endpoint, reqs)
+	if err != nil {
+		return nil, err
+	}
+
+	dryRun := isDryRun(options)
+	if !dryRun {
+		// A deploy that is running may have pulled images it hasn't
+		// recorded yet.
+		release, err := projectLocker(options, endpoint)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+
+	manifests, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := collectImages(manifests, projectName(options), endpoint, dryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	return ResultOutput{Message: formatImageGC(result), Result: result}, nil
+}
+
+// collectImages removes the images of the project that the retained
+// deployments don't use. The project's images are those it built or tagged
+// for its deployments. Images that containers still use can't be removed
+// and are reported as failed.
+func collectImages(manifests DeploymentManifests, project string, e endpoint.Endpoint, dryRun bool) (ImageGCResult, error) {
+	result := ImageGCResult{DryRun: dryRun}
+
+	var retained []string
+	for _, m := range manifests {
+		retained = append(retained, manifestImages(m)...)
+	}
+	builds := map[string]bool{}
+	for name := range manifests.services() {
+		builds[project+"_"+name] = true
+	}
+
+	images, err := e.ListImages()
+	if err != nil {
+		return result, err
+	}
+
+	for _, img := range images {
+		if matchesImage(img.Id, retained) {
+			continue
+		}
+		if !isProjectImage(img.RepoTags, project, builds) {
+			continue
+		}
+
+		collected := CollectedImage{ID: img.Id, Size: img.Size}
+		var deploymentTags []string
+		for _, tag := range img.RepoTags {
+			if tag != "<none>:<none>" {
+				collected.Tags = append(collected.Tags, tag)
+			}
+			if strings.HasPrefix(tag, deploymentRepoPrefix(project)) {
+				deploymentTags = append(deploymentTags, tag)
+			}
+		}
+
+		if !dryRun {
+			fmt.Fprintf(Progress, "Removing image %s\n", shortID(img.Id))
+			// Docker won't remove an image by ID while it has several
+			// tags, so the deployment tags go first.
+			for _, tag := range deploymentTags {
+				e.RemoveImage(tag)
+			}
+			if err := e.RemoveImage(img.Id); err != nil {
+				collected.Error = err.Error()
+				result.Failed = append(result.Failed, collected)
+				continue
+			}
+		}
+		result.Removed = append(result.Removed, collected)
+		result.Reclaimed += collected.Size
+	}
+
+	return result, nil
+}
+
+func matchesImage(id string, ids []string) bool {
+	for _, other := range ids {
+		if sameImage(id, other) {
+			return true
+		}
+	}
+	return false
+}
+
+// isProjectImage tells whether the image was built or deployed by the
+// project. Built images are named <project>_<service> after one of the
+// services in builds, deployed ones are tagged zodiac/<project>/<service>.
+func isProjectImage(tags []string, project string, builds map[string]bool) bool {
+	for _, tag := range tags {
+		if builds[imageRepository(tag)] || strings.HasPrefix(tag, deploymentRepoPrefix(project)) {
+			return true
+		}
+	}
+	return false
+}
+
+func deploymentRepoPrefix(project string) string {
+	return fmt.Sprintf("zodiac/%s/", project)
+}
+
+// tagImages tags the image of every service as
+// zodiac/<project>/<service>:<deployment ID>. The tag keeps the image
+// referenced, so pruning dangling images doesn't take rollback targets.
+func tagImages(services []Service, project string, id int, e endpoint.Endpoint) {
+	for _, svc := range services {
+		_, name, ok := splitContainerName(svc.Name)
+		if !ok {
+			name = svc.Name
+		}
+		repo := deploymentRepoPrefix(project) + name
+
+		if err := e.TagImage(svc.ContainerConfig.Image, repo, strconv.Itoa(id)); err != nil {
+			fmt.Fprintf(Progress, "Couldn't tag the image of %s as %s:%d: %s\n", svc.Name, repo, id, err)
+		}
+	}
+}
+
+// missingImages returns the services whose image is no longer on the
+// endpoint.
+func missingImages(services []Service, e endpoint.Endpoint) ([]Service, error) {
+	var missing []Service
+	for _, svc := range services {
+		_, err := e.LookupImage(svc.ContainerConfig.Image)
+		if err == dockerclient.ErrNotFound {
+			missing = append(missing, svc)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return missing, nil
+}
+
+// ensureImages pulls the missing images of the services again, by digest
+// when they have one. Otherwise they are pulled by their original name, as
+// long as that still is the same image.
+func ensureImages(services []Service, auths endpoint.AuthConfigs, e endpoint.Endpoint) error {
+	missing, err := missingImages(services, e)
+	if err != nil {
+		return err
+	}
+
+	for _, svc := range missing {
+		id := svc.ContainerConfig.Image
+		name := pullName(svc)
+
+		fmt.Fprintf(Progress, "The image of %s is missing, pulling %s\n", svc.Name, name)
+		pulled, err := e.ResolveImage(name, auths.ForImage(name))
+		if err != nil {
+			return fmt.Errorf("The image %s of %s is no longer on the endpoint and can't be pulled: %s", shortID(id), svc.Name, err)
+		}
+		if !sameImage(pulled, id) {
+			return fmt.Errorf("The image %s of %s is no longer on the endpoint and %s is now a different image", shortID(id), svc.Name, name)
+		}
+	}
+	return nil
+}
+
+// pullName is what a missing image of the service is pulled again by.
+func pullName(svc Service) string {
+	if d := svc.Digest(); d != "" {
+		return d
+	}
+	return svc.OriginalImage
+}
+
+func formatImageGC(r ImageGCResult) string {
+	var lines []string
+	for _, img := range r.Removed {
+		lines = append(lines, fmt.Sprintf("%s %s %s", shortID(img.ID), formatSize(img.Size), strings.Join(img.Tags, ", ")))
+	}
+	for _, img := range r.Failed {
+		lines = append(lines, fmt.Sprintf("%s kept: %s", shortID(img.ID), img.Error))
+	}
+
+	summary := fmt.Sprintf("Removed %d image(s), reclaiming %s", len(r.Removed), formatSize(r.Reclaimed))
+	if r.DryRun {
+		summary = fmt.Sprintf("Would remove %d image(s), reclaiming %s, dry run, nothing was changed", len(r.Removed), formatSize(r.Reclaimed))
+	}
+	return strings.Join(append(lines, summary), "\n")
+}
+
+func shortID(id string) string {
+	id = strings.TrimPrefix(id, "sha256:")
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// formatSize gives a size in bytes the way docker images does.
+func formatSize(size int64) string {
+	units := []string{"B", "kB", "MB", "GB", "TB"}
+	value := float64(size)
+	i := 0
+	for value >= 1000 && i < len(units)-1 {
+		value /= 1000
+		i++
+	}
+	return fmt.Sprintf("%.4g %s", value, units[i])
+}
@@ -0,0 +1,185 @@
+package actions
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/CenturyLinkLabs/zodiac/endpoint"
+	"github.com/CenturyLinkLabs/zodiac/proxy"
+	"github.com/samalba/dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockImagesEndpoint struct {
+	mockEndpoint
+	images  []*dockerclient.Image
+	inUse   string
+	removed *[]string
+}
+
+func (e mockImagesEndpoint) ListImages() ([]*dockerclient.Image, error) {
+	return e.images, nil
+}
+
+func (e mockImagesEndpoint) RemoveImage(name string) error {
+	if name == e.inUse {
+		return errors.New("conflict: unable to delete, image is being used by a stopped container")
+	}
+	*e.removed = append(*e.removed, name)
+	return nil
+}
+
+func imagesFixture() (mockImagesEndpoint, DeploymentManifests) {
+	e := mockImagesEndpoint{
+		images: []*dockerclient.Image{
+			{Id: "aaa111", RepoTags: []string{"zodiac_web:latest"}, Size: 2000000},
+			{Id: "bbb222", RepoTags: []string{"zodiac/zodiac/web:1"}, Size: 1500},
+			{Id: "ccc333", RepoTags: []string{"zodiac_web:old"}, Size: 1000},
+			{Id: "ddd444", RepoTags: []string{"redis:latest"}, Size: 3000},
+			{Id: "eee555", RepoTags: []string{"zodiac_web:older"}, Size: 4000},
+			{Id: "fff000", RepoTags: []string{"<none>:<none>"}, Size: 5000},
+			{Id: "ggg777", RepoTags: []string{"zodiac_tools:latest", "zodiac_web/x:1"}, Size: 6000},
+		},
+		removed: &[]string{},
+	}
+
+	var web endpoint.ContainerConfig
+	web.Image = "aaa111"
+	manifests := DeploymentManifests{
+		{Services: []Service{{Name: "zodiac_web_1", ContainerConfig: web}}},
+	}
+	return e, manifests
+}
+
+func TestCollectImages(t *testing.T) {
+	e, manifests := imagesFixture()
+	e.inUse = "eee555"
+
+	result, err := collectImages(manifests, "zodiac", e, false)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"zodiac/zodiac/web:1", "bbb222", "ccc333"}, *e.removed)
+	assert.Len(t, result.Removed, 2)
+	assert.Equal(t, int64(2500), result.Reclaimed)
+	if assert.Len(t, result.Failed, 1) {
+		assert.Equal(t, "eee555", result.Failed[0].ID)
+	}
+	assert.Equal(t, "2.5 kB", formatSize(result.Reclaimed))
+}
+
+func TestImagesGC_DryRun(t *testing.T) {
+	e, manifests := imagesFixture()
+	store := &memoryHistoryStore{manifests: manifests}
+	defer useFixture(store, e, &mockComposer{})()
+
+	o, err := ImagesGC(Options{Flags: map[string]string{"dry-run": "true"}})
+
+	assert.NoError(t, err)
+	assert.Empty(t, *e.removed)
+	result := o.(ResultOutput).Result.(ImageGCResult)
+	assert.Len(t, result.Removed, 3)
+	assert.Contains(t, o.ToPrettyOutput(), "Would remove 3 image(s), reclaiming 6.5 kB")
+}
+
+func TestCollectImages_RemovesDeploymentTags(t *testing.T) {
+	e := mockImagesEndpoint{
+		images: []*dockerclient.Image{
+			{Id: "fff666", RepoTags: []string{"zodiac/zodiac/web:1", "nginx:1.7"}, Size: 500},
+		},
+		removed: &[]string{},
+	}
+
+	result, err := collectImages(nil, "zodiac", e, false)
+
+	assert.NoError(t, err)
+	assert.Len(t, result.Removed, 1)
+	assert.Equal(t, []string{"zodiac/zodiac/web:1", "fff666"}, *e.removed)
+}
+
+type mockTagEndpoint struct {
+	mockEndpoint
+	present map[string]bool
+	pulls   map[string]string
+	tags    *[]string
+}
+
+func (e mockTagEndpoint) TagImage(id, repo, tag string) error {
+	*e.tags = append(*e.tags, id+" "+repo+":"+tag)
+	return nil
+}
+
+func (e mockTagEndpoint) LookupImage(name string) (string, error) {
+	if !e.present[name] {
+		return "", dockerclient.ErrNotFound
+	}
+	return name, nil
+}
+
+func (e mockTagEndpoint) ResolveImage(name string, auth *dockerclient.AuthConfig) (string, error) {
+	if id, ok := e.pulls[name]; ok {
+		return id, nil
+	}
+	return "", errors.New("manifest unknown")
+}
+
+func TestTagImages(t *testing.T) {
+	e := mockTagEndpoint{tags: &[]string{}}
+	var web, db endpoint.ContainerConfig
+	web.Image = "aaa111"
+	db.Image = "bbb222"
+
+	tagImages([]Service{
+		{Name: "zodiac_web_1", ContainerConfig: web},
+		{Name: "zodiac_db_1", ContainerConfig: db},
+	}, "zodiac", 3, e)
+
+	assert.Equal(t, []string{"aaa111 zodiac/zodiac/web:3", "bbb222 zodiac/zodiac/db:3"}, *e.tags)
+}
+
+// taggingEngineEndpoint records the images tagged on a mockEngineEndpoint.
+type taggingEngineEndpoint struct {
+	*mockEngineEndpoint
+	tags []string
+}
+
+func (e *taggingEngineEndpoint) TagImage(id, repo, tag string) error {
+	e.tags = append(e.tags, id+" "+repo+":"+tag)
+	return nil
+}
+
+func TestDeploy_TagsOnlyAfterSuccess(t *testing.T) {
+	e := &taggingEngineEndpoint{mockEngineEndpoint: newMockEngineEndpoint()}
+	e.failStart = "zodiac_web_1"
+	store := &memoryHistoryStore{}
+	reqs := []proxy.ContainerRequest{{Name: "zodiac_web_1", CreateOptions: []byte(`{"Image": "web"}`)}}
+	defer useFixture(store, e, &mockComposer{})()
+
+	_, err := deployTo(Options{}, e, reqs, nil, recreate, projectConfig{})
+
+	assert.Error(t, err)
+	assert.Empty(t, e.tags)
+
+	e.failStart = ""
+	_, err = deployTo(Options{}, e, reqs, nil, recreate, projectConfig{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"abc123 zodiac/zodiac/web:1"}, e.tags)
+}
+
+func TestEnsureImages(t *testing.T) {
+	var web endpoint.ContainerConfig
+	web.Image = "aaa111"
+	services := []Service{{Name: "zodiac_web_1", OriginalImage: "web:1.0", ContainerConfig: web}}
+
+	e := mockTagEndpoint{present: map[string]bool{"aaa111": true}}
+	assert.NoError(t, ensureImages(services, nil, e))
+
+	e = mockTagEndpoint{pulls: map[string]string{"web:1.0": "aaa111"}}
+	assert.NoError(t, ensureImages(services, nil, e))
+
+	e = mockTagEndpoint{pulls: map[string]string{"web:1.0": "ccc333"}}
+	assert.EqualError(t, ensureImages(services, nil, e), "The image aaa111 of zodiac_web_1 is no longer on the endpoint and web:1.0 is now a different image")
+
+	e = mockTagEndpoint{}
+	assert.EqualError(t, ensureImages(services, nil, e), "The image aaa111 of zodiac_web_1 is no longer on the endpoint and can't be pulled: manifest unknown")
+}
@@ -24,18 +24,37 @@ func List(options Options) (prettycli.Output, error) {
 		return nil, err
 	}
 
-	// Get most recent deployment's manifests
-	manifests, err := getDeploymentManifests(reqs, endpoint)
+	store, err := historyStore(options, endpoint, reqs)
 	if err != nil {
 		return nil, err
 	}
 
+	manifests, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	showChanges := options.Flags["changes"] == "true"
+	reveal := options.Flags["show-secrets"] == "true"
+	if showChanges {
+		output.Labels = append(output.Labels, "Changes")
+	}
+
+	var records []DeploymentRecord
+
 	// Iterate backwards from most recent mani to oldest
 	for i := len(manifests) - 1; i >= 0; i-- {
 		mani := manifests[i]
+		if mani.Pruned {
+			continue
+		}
 		var serviceList []string
 		for _, svc := range mani.Services {
-			serviceList = append(serviceList, svc.Name)
+			if d := svc.Digest(); d != "" {
+				serviceList = append(serviceList, fmt.Sprintf("%s (%s)", svc.Name, shortDigest(d)))
+			} else {
+				serviceList = append(serviceList, svc.Name)
+			}
 		}
 
 		var isActive string
@@ -43,16 +62,45 @@ func List(options Options) (prettycli.Output, error) {
 			isActive = "*"
 		}
 
-		output.AddRow(map[string]string{
+		record := DeploymentRecord{
+			ID:                 manifests.id(i),
+			Active:             isActive != "",
+			DeploymentManifest: mani,
+		}
+		row := map[string]string{
 			"Active":      isActive,
-			"ID":          strconv.Itoa(i + 1),
+			"ID":          strconv.Itoa(manifests.id(i)),
 			"Deploy Date": mani.DeployedAt,
 			"Services":    strings.Join(serviceList, ", "),
 			"Message":     truncate(mani.Message, 72),
-		})
+		}
+
+		if showChanges {
+			var previous []Service
+			if prev := previousDeployment(manifests, i); prev >= 0 {
+				previous = manifests[prev].Services
+			}
+			if record.Changes, err = diffServices(previous, mani.Services, reveal); err != nil {
+				return nil, err
+			}
+			row["Changes"] = summarizeChanges(record.Changes)
+		}
+
+		records = append(records, record)
+		output.AddRow(row)
 	}
 
-	return output, nil
+	return HistoryOutput{ListOutput: output, Deployments: records}, nil
+}
+
+// shortDigest abbreviates repo@sha256:... to the algorithm and the first 12
+// characters of the hash, like image IDs are.
+func shortDigest(ref string) string {
+	d := ref[strings.Index(ref, "@")+1:]
+	if i := strings.Index(d, ":"); i >= 0 && len(d) > i+13 {
+		return d[:i+13]
+	}
+	return d
 }
 
 func truncate(msg string, length int) string {
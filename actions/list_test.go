@@ -5,7 +5,6 @@ import (
 	_ "fmt"
 	"testing"
 
-	"github.com/CenturyLinkLabs/prettycli"
 	"github.com/CenturyLinkLabs/zodiac/endpoint"
 	"github.com/CenturyLinkLabs/zodiac/proxy"
 	"github.com/samalba/dockerclient"
@@ -30,6 +29,8 @@ func TestList_Success(t *testing.T) {
 			Services: []Service{
 				{
 					Name:            "oldService",
+					OriginalImage:   "oldimage:1.0",
+					RepoDigests:     []string{"oldimage@sha256:5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c2d3e4f5a6b7c8d9e0f1a2b3c4d5e6f"},
 					ContainerConfig: oldServiceConfig,
 				},
 			},
@@ -59,7 +60,7 @@ func TestList_Success(t *testing.T) {
 		},
 	}
 
-	proxyFactory = func(string, endpoint.Endpoint, bool) proxy.Proxy {
+	proxyFactory = func(string, endpoint.Endpoint, bool, endpoint.BuildOptions) proxy.Proxy {
 		return &mockProxy{
 			requests: []proxy.ContainerRequest{
 				{
@@ -83,7 +84,8 @@ func TestList_Success(t *testing.T) {
 
 	o, err := List(Options{})
 
-	output, _ := o.(prettycli.ListOutput)
+	history, _ := o.(HistoryOutput)
+	output := history.ListOutput
 
 	assert.NoError(t, err)
 	assert.Len(t, output.Labels, 5)
@@ -98,4 +100,8 @@ func TestList_Success(t *testing.T) {
 	assert.Equal(t, "newService, Another service", output.Rows[0]["Services"])
 	assert.Equal(t, "initial release for deployment to production, but don't worry you won't...", output.Rows[0]["Message"])
 	assert.Equal(t, "", output.Rows[1]["Active"])
+	assert.Equal(t, "oldService (sha256:5e6f7a8b9c0d)", output.Rows[1]["Services"])
+	assert.Len(t, history.Deployments, 2)
+	assert.Equal(t, 2, history.Deployments[0].ID)
+	assert.True(t, history.Deployments[0].Active)
 }
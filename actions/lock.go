@@ -0,0 +1,253 @@
+package actions
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+
+	"github.com/CenturyLinkLabs/prettycli"
+	"github.com/CenturyLinkLabs/zodiac/endpoint"
+	"github.com/samalba/dockerclient"
+)
+
+const (
+	lockOwnerLabel   = "zodiac.lock.owner"
+	lockHostLabel    = "zodiac.lock.host"
+	lockStartedLabel = "zodiac.lock.started"
+	lockExpiresLabel = "zodiac.lock.expires"
+	lockTokenLabel   = "zodiac.lock.token"
+)
+
+// LockLease is how long a lock is held before others may take it over, in
+// case the deploy holding it died without releasing it. The deploy holding
+// it renews the lease every third of it while it runs.
+var LockLease = 30 * time.Minute
+
+// A Lock is the advisory lock a deploy, rollback or teardown holds on a
+// project while it runs. It is kept on a stopped container on the endpoint,
+// so everyone deploying to the endpoint sees it.
+type Lock struct {
+	Project string
+	Owner   string
+	Host    string
+	Started time.Time
+	Expires time.Time
+
+	// token tells locks taken by the same owner apart.
+	token string
+}
+
+func (l Lock) Expired() bool {
+	return time.Now().After(l.Expires)
+}
+
+func (l Lock) String() string {
+	return fmt.Sprintf("%s@%s since %s, until %s", l.Owner, l.Host, l.Started.Format(BasicDateTime), l.Expires.Format(BasicDateTime))
+}
+
+// A LockedError is returned when another deploy holds the project's lock.
+type LockedError struct {
+	Lock Lock
+}
+
+func (e LockedError) Error() string {
+	return fmt.Sprintf("%s is locked by %s. Use `zodiac unlock --force` if that deploy is no longer running", e.Lock.Project, e.Lock)
+}
+
+func lockContainerName(project string) string {
+	return fmt.Sprintf("%s_zodiac_lock", project)
+}
+
+// acquireLock takes the project's lock on the endpoint, taking over an
+// expired one. Creating the lock container fails if it already exists, so
+// only one deploy can get it. The lease is renewed until the returned func
+// releases the lock, unless it has been taken over in the meantime.
+func acquireLock(options Options, e endpoint.Endpoint) (func(), error) {
+	project := projectName(options)
+	name := lockContainerName(project)
+
+	image, err := e.ResolveImage(HistoryContainerImage, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	token := make([]byte, 8)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+
+	owner := "unknown"
+	if usr, err := user.Current(); err == nil {
+		owner = usr.Username
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	now := time.Now()
+
+	var cc endpoint.ContainerConfig
+	cc.Image = image
+	cc.Cmd = []string{"true"}
+	cc.Labels = map[string]string{
+		lockOwnerLabel:   owner,
+		lockHostLabel:    host,
+		lockStartedLabel: now.Format(time.RFC3339),
+		lockExpiresLabel: now.Add(LockLease).Format(time.RFC3339),
+		lockTokenLabel:   hex.EncodeToString(token),
+	}
+
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	release := func() {
+		close(stop)
+		<-stopped
+
+		lock, err := readLock(project, e)
+		if err == dockerclient.ErrNotFound {
+			return
+		}
+		if err == nil && lock.token != cc.Labels[lockTokenLabel] {
+			fmt.Fprintf(Progress, "Not releasing the lock on %s, it was taken over by %s\n", project, lock)
+			return
+		}
+		if err == nil {
+			err = e.RemoveContainer(name)
+		}
+		if err != nil {
+			fmt.Fprintf(Progress, "Couldn't release the lock on %s: %s\n", project, err)
+		}
+	}
+
+	// The lock container is only created, and without the retries
+	// StartContainer makes, so a held lock is reported straight away.
+	for attempt := 0; attempt < 2; attempt++ {
+		err := e.CreateContainer(name, cc)
+		if err == nil {
+			go renewLock(project, cc, e, stop, stopped)
+			return release, nil
+		}
+		if err != endpoint.ErrNameInUse {
+			return nil, err
+		}
+
+		lock, err := readLock(project, e)
+		if err == dockerclient.ErrNotFound {
+			// Released since, try again.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !lock.Expired() {
+			return nil, LockedError{Lock: lock}
+		}
+
+		fmt.Fprintf(Progress, "Taking over the expired lock of %s\n", lock)
+		if err := e.RemoveContainer(name); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, errors.New("Couldn't take the lock on " + project)
+}
+
+// renewLock extends the lease of the lock every third of it until stop is
+// closed, as long as the lock is still the one cc created. The labels of a
+// container can't be changed, so the lock container is created again with
+// the new lease.
+func renewLock(project string, cc endpoint.ContainerConfig, e endpoint.Endpoint, stop <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+	name := lockContainerName(project)
+	ticker := time.NewTicker(LockLease / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		lock, err := readLock(project, e)
+		if err == dockerclient.ErrNotFound || (err == nil && lock.token != cc.Labels[lockTokenLabel]) {
+			// Removed with unlock --force or taken over, it's no
+			// longer ours to renew.
+			return
+		}
+
+		renewed := cc
+		renewed.Labels = map[string]string{}
+		for k, v := range cc.Labels {
+			renewed.Labels[k] = v
+		}
+		renewed.Labels[lockExpiresLabel] = time.Now().Add(LockLease).Format(time.RFC3339)
+
+		if err == nil {
+			err = e.RemoveContainer(name)
+		}
+		if err == nil {
+			err = e.CreateContainer(name, renewed)
+		}
+		if err != nil {
+			fmt.Fprintf(Progress, "Couldn't renew the lock on %s: %s\n", project, err)
+		}
+	}
+}
+
+// readLock reads the project's lock, dockerclient.ErrNotFound means it isn't
+// locked.
+func readLock(project string, e endpoint.Endpoint) (Lock, error) {
+	ci, err := e.InspectContainer(lockContainerName(project))
+	if err != nil {
+		return Lock{}, err
+	}
+
+	lock := Lock{Project: project}
+	if ci == nil || ci.Config == nil {
+		return lock, nil
+	}
+	labels := ci.Config.Labels
+	lock.Owner = labels[lockOwnerLabel]
+	lock.Host = labels[lockHostLabel]
+	lock.Started, _ = time.Parse(time.RFC3339, labels[lockStartedLabel])
+	// A lock without a readable lease has expired.
+	lock.Expires, _ = time.Parse(time.RFC3339, labels[lockExpiresLabel])
+	lock.token = labels[lockTokenLabel]
+
+	return lock, nil
+}
+
+// Unlock shows who holds the project's lock and, with --force, removes it.
+func Unlock(options Options) (prettycli.Output, error) {
+
+	endpoint, err := endpointFactory(options.EndpointOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	project := projectName(options)
+	lock, err := readLock(project, endpoint)
+	if err == dockerclient.ErrNotFound {
+		return ResultOutput{Message: fmt.Sprintf("%s isn't locked", project)}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Flags["force"] != "true" {
+		return nil, fmt.Errorf("%s is locked by %s. Use --force to remove the lock", project, lock)
+	}
+
+	if err := endpoint.RemoveContainer(lockContainerName(project)); err != nil {
+		return nil, err
+	}
+
+	return ResultOutput{
+		Message: fmt.Sprintf("Removed the lock on %s held by %s", project, lock),
+		Result:  lock,
+	}, nil
+}
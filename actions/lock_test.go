@@ -0,0 +1,168 @@
+package actions
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/CenturyLinkLabs/prettycli"
+	"github.com/CenturyLinkLabs/zodiac/endpoint"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockLockEndpoint fails the way a retrying StartContainer would if the
+// lock were taken with it.
+type mockLockEndpoint struct {
+	*mockEngineEndpoint
+}
+
+func (e mockLockEndpoint) StartContainer(nm string, cc endpoint.ContainerConfig) error {
+	if nm == "zodiac_zodiac_lock" {
+		return fmt.Errorf("the lock must not be started with retries")
+	}
+	return e.mockEngineEndpoint.StartContainer(nm, cc)
+}
+
+func lockConfig(expires time.Time) endpoint.ContainerConfig {
+	var cc endpoint.ContainerConfig
+	cc.Labels = map[string]string{
+		lockOwnerLabel:   "alice",
+		lockHostLabel:    "laptop",
+		lockStartedLabel: expires.Add(-LockLease).Format(time.RFC3339),
+		lockExpiresLabel: expires.Format(time.RFC3339),
+	}
+	return cc
+}
+
+func TestAcquireLock(t *testing.T) {
+	e := mockLockEndpoint{newMockEngineEndpoint()}
+
+	release, err := acquireLock(Options{}, e)
+
+	assert.NoError(t, err)
+	labels := e.containers["zodiac_zodiac_lock"].Labels
+	assert.NotEmpty(t, labels[lockOwnerLabel])
+	assert.NotEmpty(t, labels[lockHostLabel])
+
+	_, err = acquireLock(Options{}, e)
+	_, locked := err.(LockedError)
+	assert.True(t, locked)
+
+	release()
+	_, held := e.containers["zodiac_zodiac_lock"]
+	assert.False(t, held)
+}
+
+func TestAcquireLock_ReleaseLeavesTakenOverLock(t *testing.T) {
+	e := mockLockEndpoint{newMockEngineEndpoint()}
+	release, err := acquireLock(Options{}, e)
+	assert.NoError(t, err)
+
+	e.containers["zodiac_zodiac_lock"] = lockConfig(time.Now().Add(time.Minute))
+	release()
+
+	assert.Equal(t, "alice", e.containers["zodiac_zodiac_lock"].Labels[lockOwnerLabel])
+	assert.Equal(t, []string{"create zodiac_zodiac_lock"}, e.calls)
+}
+
+func TestAcquireLock_RenewsLease(t *testing.T) {
+	lease := LockLease
+	LockLease = 30 * time.Millisecond
+	defer func() { LockLease = lease }()
+	e := mockLockEndpoint{newMockEngineEndpoint()}
+
+	release, err := acquireLock(Options{}, e)
+	assert.NoError(t, err)
+	time.Sleep(25 * time.Millisecond)
+	release()
+
+	assert.Equal(t, []string{
+		"create zodiac_zodiac_lock",
+		"remove zodiac_zodiac_lock",
+		"create zodiac_zodiac_lock",
+	}, e.calls[:3])
+	assert.Equal(t, "remove zodiac_zodiac_lock", e.calls[len(e.calls)-1])
+}
+
+func TestAcquireLock_TakesOverExpiredLock(t *testing.T) {
+	e := mockLockEndpoint{newMockEngineEndpoint()}
+	e.containers["zodiac_zodiac_lock"] = lockConfig(time.Now().Add(-time.Minute))
+
+	_, err := acquireLock(Options{}, e)
+
+	assert.NoError(t, err)
+	assert.NotEqual(t, "alice", e.containers["zodiac_zodiac_lock"].Labels[lockOwnerLabel])
+}
+
+func TestDeploy_Locked(t *testing.T) {
+	e := mockLockEndpoint{newMockEngineEndpoint()}
+	e.containers["zodiac_zodiac_lock"] = lockConfig(time.Now().Add(time.Minute))
+	endpointFactory = func(endpoint.EndpointOptions) (endpoint.Endpoint, error) {
+		return e, nil
+	}
+	DefaultComposer = &mockComposer{}
+	projectLocker = acquireLock
+	defer func() {
+		projectLocker = func(Options, endpoint.Endpoint) (func(), error) { return func() {}, nil }
+	}()
+
+	o, err := Deploy(Options{})
+
+	assert.Nil(t, o)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "zodiac is locked by alice@laptop since")
+	}
+}
+
+func TestLockedCommands(t *testing.T) {
+	projectLocker = acquireLock
+	defer func() {
+		projectLocker = func(Options, endpoint.Endpoint) (func(), error) { return func() {}, nil }
+	}()
+	DefaultComposer = &mockComposer{}
+
+	commands := map[string]func(Options) (prettycli.Output, error){
+		"teardown":  Teardown,
+		"scale":     Scale,
+		"reconcile": Reconcile,
+		"migrate":   Migrate,
+	}
+	for name, command := range commands {
+		e := mockLockEndpoint{newMockEngineEndpoint()}
+		e.containers["zodiac_zodiac_lock"] = lockConfig(time.Now().Add(time.Minute))
+		endpointFactory = func(endpoint.EndpointOptions) (endpoint.Endpoint, error) {
+			return e, nil
+		}
+
+		_, err := command(Options{Args: []string{"web=2"}, Flags: map[string]string{"to": FileHistory}})
+
+		_, locked := err.(LockedError)
+		assert.True(t, locked, name)
+		assert.Equal(t, []string{"create zodiac_zodiac_lock"}, e.calls, name)
+	}
+}
+
+func TestUnlock(t *testing.T) {
+	e := mockLockEndpoint{newMockEngineEndpoint()}
+	e.containers["zodiac_zodiac_lock"] = lockConfig(time.Now().Add(time.Minute))
+	endpointFactory = func(endpoint.EndpointOptions) (endpoint.Endpoint, error) {
+		return e, nil
+	}
+
+	_, err := Unlock(Options{})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Use --force to remove the lock")
+	}
+	assert.Empty(t, e.calls)
+
+	o, err := Unlock(Options{Flags: map[string]string{"force": "true"}})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", o.(ResultOutput).Result.(Lock).Owner)
+	assert.Equal(t, []string{"remove zodiac_zodiac_lock"}, e.calls)
+
+	o, err = Unlock(Options{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "zodiac isn't locked", o.ToPrettyOutput())
+}
@@ -0,0 +1,112 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/CenturyLinkLabs/prettycli"
+	"github.com/CenturyLinkLabs/zodiac/endpoint"
+)
+
+func Migrate(options Options) (prettycli.Output, error) {
+	from, to := options.Flags["from"], options.Flags["to"]
+	if from == "" {
+		from = LabelHistory
+	}
+	if to == "" {
+		return nil, errors.New("Specify the history store to migrate to with --to")
+	}
+	if from == to {
+		return nil, fmt.Errorf("The history is already kept in the %s store", to)
+	}
+
+	endpoint, err := endpointFactory(options.EndpointOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := projectLocker(options, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	reqs, err := collectRequests(options, true)
+	if err != nil {
+		return nil, err
+	}
+
+	src, err := historyStoreFactory(from, options, endpoint, reqs)
+	if err != nil {
+		return nil, err
+	}
+
+	dst, err := historyStoreFactory(to, options, endpoint, reqs)
+	if err != nil {
+		return nil, err
+	}
+
+	manifests, err := src.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(manifests) == 0 {
+		return nil, fmt.Errorf("There is no deployment history in the %s store", from)
+	}
+
+	// The label store keeps the history on the running containers, so moving
+	// into or out of it means recreating them with the new labels.
+	if from == LabelHistory || to == LabelHistory {
+		if err := relabel(manifests, src, dst, endpoint); err != nil {
+			return nil, err
+		}
+	} else if err := dst.Save(manifests); err != nil {
+		return nil, err
+	}
+
+	if err := src.Remove(); err != nil {
+		return nil, err
+	}
+
+	return ResultOutput{
+		Message: fmt.Sprintf("Migrated %d deployment(s) from %s to %s history", len(manifests), from, to),
+		Result:  MigrateResult{Deployments: len(manifests), From: from, To: to},
+	}, nil
+}
+
+// relabel recreates the containers of the current deployment with the labels
+// of dst and then saves the history to dst. If anything fails the containers
+// are put back with the labels of src.
+func relabel(manifests DeploymentManifests, src, dst HistoryStore, e endpoint.Endpoint) error {
+	current := manifests[len(manifests)-1]
+	// restorePrevious puts back the deployment before the failed one, so
+	// the current deployment stands in for both.
+	restore := func(err error, created []string) error {
+		return restorePrevious(err, created, append(manifests[:len(manifests):len(manifests)], current), src, e)
+	}
+
+	if err := removeServices(current.Services, e); err != nil {
+		return restore(err, nil)
+	}
+
+	historyLabels, err := dst.Labels(manifests)
+	if err != nil {
+		return restore(err, nil)
+	}
+
+	var created []string
+	for _, spec := range containerSpecs(current.Services, historyLabels) {
+		created = append(created, spec.Name)
+		fmt.Fprintf(Progress, "Creating %s\n", spec.Name)
+		if err := e.StartContainer(spec.Name, spec.Config); err != nil {
+			return restore(err, created)
+		}
+	}
+
+	if err := dst.Save(manifests); err != nil {
+		return restore(err, created)
+	}
+
+	return nil
+}
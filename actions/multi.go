@@ -0,0 +1,181 @@
+package actions
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/CenturyLinkLabs/prettycli"
+	"github.com/CenturyLinkLabs/zodiac/composer"
+	"github.com/CenturyLinkLabs/zodiac/proxy"
+)
+
+const (
+	SequentialRollout = "sequential"
+	ParallelRollout   = "parallel"
+
+	EndpointDeployed = "deployed"
+	EndpointFailed   = "failed"
+	EndpointSkipped  = "skipped"
+)
+
+// An EndpointResult is the outcome of a deploy to one of several endpoints.
+type EndpointResult struct {
+	Endpoint string
+	Status   string
+	Error    string        `json:",omitempty"`
+	Result   *DeployResult `json:",omitempty"`
+}
+
+// MultiDeployOutput is the table of results of a deploy to several
+// endpoints, followed by a summary line.
+type MultiDeployOutput struct {
+	prettycli.ListOutput
+	Results []EndpointResult
+}
+
+func (o MultiDeployOutput) ToPrettyOutput() string {
+	deployed := 0
+	for _, r := range o.Results {
+		if r.Status == EndpointDeployed {
+			deployed++
+		}
+	}
+
+	return fmt.Sprintf("%s\nDeployed to %d of %d endpoint(s)", o.ListOutput.ToPrettyOutput(), deployed, len(o.Results))
+}
+
+func (o MultiDeployOutput) Data() interface{} {
+	return o.Results
+}
+
+// deployEndpoints deploys to every endpoint in options.Endpoints, one after
+// the other or all at once. Each endpoint keeps its own history.
+func deployEndpoints(options Options, strategy deployStrategy, config projectConfig) (prettycli.Output, error) {
+	rollout := options.Flags["rollout"]
+	if rollout == "" {
+		rollout = SequentialRollout
+	}
+	if rollout != SequentialRollout && rollout != ParallelRollout {
+		return nil, fmt.Errorf("Unknown rollout '%s', use one of: %s, %s", rollout, SequentialRollout, ParallelRollout)
+	}
+	stopOnFailure := options.Flags["stop-on-failure"] == "true"
+
+	fmt.Fprintf(Progress, "Deploying your application to %d endpoints...\n", len(options.Endpoints))
+
+	auths, err := authConfigsLoader(options.DockerConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	// Composers that can build separately only read the compose file once,
+	// the others have to be run against each endpoint to build there.
+	var reqs []proxy.ContainerRequest
+	_, canBuild := DefaultComposer.(composer.Builder)
+	if canBuild {
+		if reqs, err = collectRequests(options, true); err != nil {
+			return nil, err
+		}
+	}
+	// Only one capturing proxy can listen at a time.
+	var captureLock sync.Mutex
+
+	deployOne := func(host string) EndpointResult {
+		start := time.Now()
+		result := EndpointResult{Endpoint: host, Status: EndpointFailed}
+
+		opts := options
+		opts.EndpointOptions.Host = host
+
+		e, err := endpointFactory(opts.EndpointOptions)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+
+		// deployTo builds on the endpoint when the composer can.
+		epReqs := reqs
+		if !canBuild {
+			captureLock.Lock()
+			epReqs, err = collectRequests(opts, false)
+			captureLock.Unlock()
+			if err != nil {
+				result.Error = err.Error()
+				return result
+			}
+		}
+
+		dr, err := deployTo(opts, e, epReqs, auths, strategy, config)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		dr.Duration = time.Since(start).String()
+
+		result.Status = EndpointDeployed
+		result.Result = &dr
+		return result
+	}
+
+	results := make([]EndpointResult, len(options.Endpoints))
+	if rollout == ParallelRollout {
+		var wg sync.WaitGroup
+		for i, host := range options.Endpoints {
+			wg.Add(1)
+			go func(i int, host string) {
+				defer wg.Done()
+				results[i] = deployOne(host)
+			}(i, host)
+		}
+		wg.Wait()
+	} else {
+		failed := false
+		for i, host := range options.Endpoints {
+			if failed && stopOnFailure {
+				results[i] = EndpointResult{Endpoint: host, Status: EndpointSkipped}
+				continue
+			}
+
+			fmt.Fprintf(Progress, "Deploying to %s...\n", host)
+			results[i] = deployOne(host)
+			failed = failed || results[i].Status == EndpointFailed
+		}
+	}
+
+	return multiDeployOutput(results)
+}
+
+// multiDeployOutput tabulates the results. The output comes with an error
+// when any of the endpoints failed.
+func multiDeployOutput(results []EndpointResult) (prettycli.Output, error) {
+	output := MultiDeployOutput{
+		ListOutput: prettycli.ListOutput{
+			Labels: []string{"Endpoint", "Status", "Deployment", "Containers", "Duration", "Error"},
+		},
+		Results: results,
+	}
+
+	failed := 0
+	for _, r := range results {
+		row := map[string]string{
+			"Endpoint": r.Endpoint,
+			"Status":   r.Status,
+			"Error":    r.Error,
+		}
+		if r.Result != nil {
+			row["Deployment"] = strconv.Itoa(r.Result.DeploymentID)
+			row["Containers"] = strconv.Itoa(len(r.Result.Containers))
+			row["Duration"] = r.Result.Duration
+		}
+		if r.Status == EndpointFailed {
+			failed++
+		}
+		output.AddRow(row)
+	}
+
+	if failed > 0 {
+		return output, fmt.Errorf("Deploying failed on %d of %d endpoint(s)", failed, len(results))
+	}
+	return output, nil
+}
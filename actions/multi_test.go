@@ -0,0 +1,110 @@
+package actions
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/CenturyLinkLabs/zodiac/endpoint"
+	"github.com/CenturyLinkLabs/zodiac/proxy"
+	"github.com/stretchr/testify/assert"
+)
+
+func multiEndpointFixture() (map[string]*mockEngineEndpoint, func()) {
+	engines := map[string]*mockEngineEndpoint{
+		"tcp://a:2376": newMockEngineEndpoint(),
+		"tcp://c:2376": newMockEngineEndpoint(),
+	}
+	stores := map[endpoint.Endpoint]*memoryHistoryStore{}
+	for _, e := range engines {
+		stores[e] = &memoryHistoryStore{}
+	}
+
+	restore := saveFactories()
+	endpointFactory = func(opts endpoint.EndpointOptions) (endpoint.Endpoint, error) {
+		if e, ok := engines[opts.Host]; ok {
+			return e, nil
+		}
+		return nil, errors.New("connection refused")
+	}
+	historyStoreFactory = func(kind string, options Options, e endpoint.Endpoint, reqs []proxy.ContainerRequest) (HistoryStore, error) {
+		return stores[e], nil
+	}
+	DefaultComposer = &mockRequestComposer{requests: []proxy.ContainerRequest{
+		{Name: "app_web_1", CreateOptions: []byte(`{"Image": "web"}`)},
+	}}
+
+	return engines, restore
+}
+
+func TestDeploy_MultipleEndpoints(t *testing.T) {
+	engines, restore := multiEndpointFixture()
+	defer restore()
+
+	for _, rollout := range []string{SequentialRollout, ParallelRollout} {
+		o, err := Deploy(Options{
+			Flags:     map[string]string{"rollout": rollout},
+			Endpoints: []string{"tcp://a:2376", "tcp://c:2376"},
+		})
+
+		assert.NoError(t, err)
+		results := o.(MultiDeployOutput).Results
+		assert.Len(t, results, 2)
+		assert.Equal(t, EndpointDeployed, results[1].Status)
+		assert.Equal(t, []string{"app_web_1"}, results[1].Result.Containers)
+		assert.Contains(t, o.ToPrettyOutput(), "Deployed to 2 of 2 endpoint(s)")
+	}
+
+	for _, e := range engines {
+		_, ok := e.containers["app_web_1"]
+		assert.True(t, ok)
+	}
+}
+
+func TestDeploy_MultipleEndpointsStopOnFailure(t *testing.T) {
+	engines, restore := multiEndpointFixture()
+	defer restore()
+
+	o, err := Deploy(Options{
+		Flags:     map[string]string{"stop-on-failure": "true"},
+		Endpoints: []string{"tcp://a:2376", "tcp://b:2376", "tcp://c:2376"},
+	})
+
+	assert.EqualError(t, err, "Deploying failed on 1 of 3 endpoint(s)")
+	results := o.(MultiDeployOutput).Results
+	assert.Equal(t, EndpointDeployed, results[0].Status)
+	assert.Equal(t, EndpointResult{Endpoint: "tcp://b:2376", Status: EndpointFailed, Error: "connection refused"}, results[1])
+	assert.Equal(t, EndpointResult{Endpoint: "tcp://c:2376", Status: EndpointSkipped}, results[2])
+	assert.Empty(t, engines["tcp://c:2376"].containers)
+}
+
+func TestDeploy_UnknownRollout(t *testing.T) {
+	_, restore := multiEndpointFixture()
+	defer restore()
+
+	_, err := Deploy(Options{
+		Flags:     map[string]string{"rollout": "random"},
+		Endpoints: []string{"tcp://a:2376", "tcp://c:2376"},
+	})
+
+	assert.EqualError(t, err, "Unknown rollout 'random', use one of: sequential, parallel")
+}
+
+func TestEndpointHosts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zodiac-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config := "endpoints:\n  production:\n    - tcp://a:2376\n    - tcp://b:2376\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, ProjectConfigFile), []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	hosts, err := EndpointHosts(map[string]string{"file": filepath.Join(dir, "docker-compose.yml")}, []string{"production", "tcp://c:2376"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"tcp://a:2376", "tcp://b:2376", "tcp://c:2376"}, hosts)
+}
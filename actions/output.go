@@ -0,0 +1,132 @@
+package actions
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/CenturyLinkLabs/prettycli"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	JSONOutput     = "json"
+	YAMLOutput     = "yaml"
+	TemplateOutput = "go-template"
+)
+
+// A DataOutput is an Output that can also be rendered for machines, with
+// --output json, yaml or go-template.
+type DataOutput interface {
+	prettycli.Output
+	Data() interface{}
+}
+
+// ResultOutput pairs the message shown to people with the result data.
+type ResultOutput struct {
+	Message string
+	Result  interface{}
+}
+
+func (o ResultOutput) ToPrettyOutput() string {
+	return o.Message
+}
+
+func (o ResultOutput) Data() interface{} {
+	return o.Result
+}
+
+type DeployResult struct {
+	DeploymentID int
+	RolledBackTo int `json:",omitempty"`
+	Strategy     string
+	Containers   []string
+	Health       []HealthResult `json:",omitempty"`
+	Duration     string
+}
+
+type TeardownResult struct {
+	Services   int
+	Containers []string
+	Duration   string
+}
+
+type VerifyResult struct {
+	Endpoint string
+	Version  string
+	Target   string            `json:",omitempty"`
+	Settings map[string]string `json:",omitempty"`
+}
+
+type MigrateResult struct {
+	Deployments int
+	From        string
+	To          string
+}
+
+// DeploymentRecord is a deployment as listed by list, with its ID and, for
+// list --changes, how it differs from the deployment before it.
+type DeploymentRecord struct {
+	ID     int
+	Active bool
+	DeploymentManifest
+	Changes []ServiceDiff `json:",omitempty"`
+}
+
+// HistoryOutput is the deployment list, which machines get in full.
+type HistoryOutput struct {
+	prettycli.ListOutput
+	Deployments []DeploymentRecord
+}
+
+func (o HistoryOutput) Data() interface{} {
+	return o.Deployments
+}
+
+// FormatOutput renders the output of an action. An empty format is the
+// normal human readable output, otherwise format is json, yaml or
+// go-template=<template>.
+func FormatOutput(o prettycli.Output, format string) (string, error) {
+	if format == "" {
+		return o.ToPrettyOutput(), nil
+	}
+
+	var data interface{}
+	if do, ok := o.(DataOutput); ok {
+		data = do.Data()
+	} else {
+		data = map[string]string{"Message": o.ToPrettyOutput()}
+	}
+
+	switch {
+	case format == JSONOutput:
+		b, err := json.MarshalIndent(data, "", "  ")
+		return string(b), err
+	case format == YAMLOutput:
+		// Go through JSON so the keys are the same in both formats.
+		b, err := json.Marshal(data)
+		if err != nil {
+			return "", err
+		}
+		var generic interface{}
+		if err := json.Unmarshal(b, &generic); err != nil {
+			return "", err
+		}
+		b, err = yaml.Marshal(generic)
+		return strings.TrimSpace(string(b)), err
+	case strings.HasPrefix(format, TemplateOutput+"="):
+		t, err := template.New("output").Parse(strings.TrimPrefix(format, TemplateOutput+"="))
+		if err != nil {
+			return "", err
+		}
+		var b bytes.Buffer
+		if err := t.Execute(&b, data); err != nil {
+			return "", err
+		}
+		return b.String(), nil
+	}
+
+	return "", fmt.Errorf("Unknown output format '%s', use one of: %s, %s, %s=<template>", format, JSONOutput, YAMLOutput, TemplateOutput)
+}
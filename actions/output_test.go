@@ -0,0 +1,61 @@
+package actions
+
+import (
+	"testing"
+
+	"github.com/CenturyLinkLabs/prettycli"
+	"github.com/stretchr/testify/assert"
+)
+
+var verifyOutput = ResultOutput{
+	Message: "Successfully verified endpoint: tcp://1.2.3.4:2376",
+	Result:  VerifyResult{Endpoint: "tcp://1.2.3.4:2376", Version: "1.7.0"},
+}
+
+func TestFormatOutput_Pretty(t *testing.T) {
+	s, err := FormatOutput(verifyOutput, "")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Successfully verified endpoint: tcp://1.2.3.4:2376", s)
+}
+
+func TestFormatOutput_JSON(t *testing.T) {
+	s, err := FormatOutput(verifyOutput, "json")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "{\n  \"Endpoint\": \"tcp://1.2.3.4:2376\",\n  \"Version\": \"1.7.0\"\n}", s)
+}
+
+func TestFormatOutput_YAML(t *testing.T) {
+	o := ResultOutput{Result: DeployResult{DeploymentID: 2, Strategy: "recreate", Containers: []string{"app_web_1"}, Duration: "1s"}}
+
+	s, err := FormatOutput(o, "yaml")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Containers:\n- app_web_1\nDeploymentID: 2\nDuration: 1s\nStrategy: recreate", s)
+}
+
+func TestFormatOutput_Template(t *testing.T) {
+	o := HistoryOutput{Deployments: []DeploymentRecord{
+		{ID: 2, Active: true, DeploymentManifest: DeploymentManifest{Message: "second"}},
+		{ID: 1, DeploymentManifest: DeploymentManifest{Message: "first"}},
+	}}
+
+	s, err := FormatOutput(o, "go-template={{range .}}{{.ID}}:{{.Message}} {{end}}")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "2:second 1:first ", s)
+}
+
+func TestFormatOutput_PlainOutput(t *testing.T) {
+	s, err := FormatOutput(prettycli.PlainOutput{Output: "done"}, "json")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "{\n  \"Message\": \"done\"\n}", s)
+}
+
+func TestFormatOutput_Unknown(t *testing.T) {
+	_, err := FormatOutput(verifyOutput, "xml")
+
+	assert.EqualError(t, err, "Unknown output format 'xml', use one of: json, yaml, go-template=<template>")
+}
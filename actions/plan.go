@@ -0,0 +1,307 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/CenturyLinkLabs/prettycli"
+	"github.com/CenturyLinkLabs/zodiac/composer"
+	"github.com/CenturyLinkLabs/zodiac/endpoint"
+	"github.com/CenturyLinkLabs/zodiac/proxy"
+	"github.com/samalba/dockerclient"
+)
+
+const (
+	PlanCreate   = "create"
+	PlanRecreate = "recreate"
+	PlanRemove   = "remove"
+	PlanKeep     = "leave alone"
+)
+
+// A PlannedContainer is what a command would do to one container.
+type PlannedContainer struct {
+	Container string
+	Action    string
+	Detail    string `json:",omitempty"`
+}
+
+// A PlanResult is what --dry-run found a command would do. DeploymentID is
+// the ID the deployment would be recorded under.
+type PlanResult struct {
+	Command       string
+	DeploymentID  int    `json:",omitempty"`
+	Strategy      string `json:",omitempty"`
+	Containers    []PlannedContainer
+	Pull          []string `json:",omitempty"`
+	Build         []string `json:",omitempty"`
+	RemoveHistory bool     `json:",omitempty"`
+}
+
+// PlanOutput lists the planned changes, followed by a summary of them.
+type PlanOutput struct {
+	prettycli.ListOutput
+	Result PlanResult
+}
+
+func (o PlanOutput) ToPrettyOutput() string {
+	counts := map[string]int{}
+	for _, c := range o.Result.Containers {
+		counts[c.Action]++
+	}
+
+	lines := []string{
+		o.ListOutput.ToPrettyOutput(),
+		fmt.Sprintf("Plan: %d to create, %d to recreate, %d to remove, %d to leave alone",
+			counts[PlanCreate], counts[PlanRecreate], counts[PlanRemove], counts[PlanKeep]),
+	}
+	if len(o.Result.Pull) > 0 {
+		lines = append(lines, "Images to pull: "+strings.Join(o.Result.Pull, ", "))
+	}
+	if len(o.Result.Build) > 0 {
+		lines = append(lines, "Images to build: "+strings.Join(o.Result.Build, ", "))
+	}
+	if o.Result.DeploymentID > 0 {
+		lines = append(lines, fmt.Sprintf("The %s would be recorded as deployment %d", o.Result.Command, o.Result.DeploymentID))
+	}
+	if o.Result.RemoveHistory {
+		lines = append(lines, "The deployment history would be removed")
+	}
+	lines = append(lines, "Dry run, nothing was changed")
+
+	return strings.Join(lines, "\n")
+}
+
+func (o PlanOutput) Data() interface{} {
+	return o.Result
+}
+
+func isDryRun(options Options) bool {
+	return options.Flags["dry-run"] == "true"
+}
+
+// planDeploy does the read-only part of a deploy: the compose file is read
+// without building and the images are looked up without pulling. Services
+// with a build are listed as built unless the deploy would reuse the image
+// built for the active deployment.
+func planDeploy(options Options) (prettycli.Output, error) {
+	if len(options.Endpoints) > 1 {
+		return nil, errors.New("--dry-run plans one endpoint at a time")
+	}
+
+	endpoint, err := endpointFactory(options.EndpointOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	reqs, err := collectRequests(options, true)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := historyStore(options, endpoint, reqs)
+	if err != nil {
+		return nil, err
+	}
+
+	manifests, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	var previous []Service
+	if len(manifests) > 0 {
+		previous = manifests[len(manifests)-1].Services
+	}
+
+	builds := map[string]proxy.BuildInfo{}
+	if builder, ok := DefaultComposer.(composer.Builder); ok {
+		if builds, err = builder.BuildInfos(options.Flags); err != nil {
+			return nil, err
+		}
+	}
+	reuse := reuseBuild(options, previous, endpoint)
+
+	var services []Service
+	var pull, build []string
+	for _, req := range reqs {
+		s, err := serviceForRequest(req)
+		if err != nil {
+			return nil, err
+		}
+		s.OriginalImage = s.ContainerConfig.Image
+
+		if info, ok := builds[req.Name]; ok {
+			s.Build = &info
+			if id := reuse(req.Name, info); id != "" {
+				s.ContainerConfig.Image = id
+			} else {
+				build = append(build, s.OriginalImage)
+			}
+			services = append(services, s)
+			continue
+		}
+
+		imageId, err := endpoint.LookupImage(s.ContainerConfig.Image)
+		switch {
+		case err == dockerclient.ErrNotFound:
+			pull = append(pull, s.OriginalImage)
+		case err != nil:
+			return nil, err
+		default:
+			s.ContainerConfig.Image = imageId
+		}
+
+		services = append(services, s)
+	}
+
+	if services, err = resolveVolumesFrom(services); err != nil {
+		return nil, err
+	}
+
+	if err := applyScale(services, previous, options.Flags["scale"]); err != nil {
+		return nil, err
+	}
+
+	containers, err := planContainers(previous, services, projectName(options), manifests.services(), endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	strategy := options.Flags["strategy"]
+	if strategy == "" {
+		strategy = RecreateStrategy
+	}
+
+	return planOutput(PlanResult{
+		Command:      "deploy",
+		DeploymentID: manifests.nextID(),
+		Strategy:     strategy,
+		Containers:   containers,
+		Pull:         pull,
+		Build:        build,
+	}), nil
+}
+
+// planContainers works out what replacing the previous services with the
+// next ones does. Every container of the next services is started afresh,
+// the detail says whether its service changed. Containers of the services
+// in the history outside both deployments are left alone.
+func planContainers(previous, next []Service, project string, services map[string]bool, e endpoint.Endpoint) ([]PlannedContainer, error) {
+	existing, err := existingContainers(e)
+	if err != nil {
+		return nil, err
+	}
+
+	diffs, err := diffServices(previous, next, false)
+	if err != nil {
+		return nil, err
+	}
+	details := map[string]string{}
+	for _, d := range diffs {
+		switch d.Change {
+		case ServiceAdded:
+			details[d.Service] = "new service"
+		case ServiceChanged:
+			var fields []string
+			for _, f := range d.Fields {
+				fields = append(fields, f.Field)
+			}
+			details[d.Service] = "changed " + strings.Join(fields, ", ")
+		}
+	}
+
+	var planned []PlannedContainer
+	wanted := map[string]bool{}
+	for _, svc := range next {
+		detail, ok := details[svc.Name]
+		if !ok {
+			detail = "unchanged"
+		}
+		for _, name := range svc.ContainerNames() {
+			wanted[name] = true
+			if existing[name] {
+				planned = append(planned, PlannedContainer{Container: name, Action: PlanRecreate, Detail: detail})
+			} else {
+				planned = append(planned, PlannedContainer{Container: name, Action: PlanCreate})
+			}
+		}
+	}
+
+	for _, svc := range previous {
+		for _, name := range svc.ContainerNames() {
+			if !wanted[name] && existing[name] {
+				wanted[name] = true
+				planned = append(planned, PlannedContainer{Container: name, Action: PlanRemove})
+			}
+		}
+	}
+
+	strays, err := strayContainers(project, services, wanted, e)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range strays {
+		planned = append(planned, PlannedContainer{Container: name, Action: PlanKeep, Detail: "not part of either deployment"})
+	}
+
+	return planned, nil
+}
+
+// planRemovals lists which of the named containers a teardown would remove.
+// Those that don't exist are skipped and the other containers of the
+// services in the history are left alone.
+func planRemovals(names []string, project string, services map[string]bool, e endpoint.Endpoint) ([]PlannedContainer, error) {
+	existing, err := existingContainers(e)
+	if err != nil {
+		return nil, err
+	}
+
+	var planned []PlannedContainer
+	wanted := map[string]bool{}
+	for _, name := range names {
+		wanted[name] = true
+		if existing[name] {
+			planned = append(planned, PlannedContainer{Container: name, Action: PlanRemove})
+		}
+	}
+
+	strays, err := strayContainers(project, services, wanted, e)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range strays {
+		planned = append(planned, PlannedContainer{Container: name, Action: PlanKeep, Detail: "not part of the deployment"})
+	}
+
+	return planned, nil
+}
+
+func existingContainers(e endpoint.Endpoint) (map[string]bool, error) {
+	listed, err := e.ListContainers()
+	if err != nil {
+		return nil, err
+	}
+	existing := map[string]bool{}
+	for _, c := range listed {
+		existing[containerName(c)] = true
+	}
+	return existing, nil
+}
+
+func planOutput(result PlanResult) PlanOutput {
+	output := PlanOutput{
+		ListOutput: prettycli.ListOutput{
+			Labels: []string{"Container", "Action", "Detail"},
+		},
+		Result: result,
+	}
+	for _, c := range result.Containers {
+		output.AddRow(map[string]string{
+			"Container": c.Container,
+			"Action":    c.Action,
+			"Detail":    c.Detail,
+		})
+	}
+	return output
+}
@@ -0,0 +1,144 @@
+package actions
+
+import (
+	"testing"
+
+	"github.com/CenturyLinkLabs/zodiac/endpoint"
+	"github.com/CenturyLinkLabs/zodiac/proxy"
+	"github.com/samalba/dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockPlanEndpoint struct {
+	*mockEngineEndpoint
+	images map[string]string
+}
+
+func (e mockPlanEndpoint) LookupImage(name string) (string, error) {
+	if id, ok := e.images[name]; ok {
+		return id, nil
+	}
+	return "", dockerclient.ErrNotFound
+}
+
+func planFixture() (mockPlanEndpoint, *memoryHistoryStore, func()) {
+	e := mockPlanEndpoint{mockEngineEndpoint: newMockEngineEndpoint(), images: map[string]string{"web:1": "abc123"}}
+	for _, nm := range []string{"zodiac_web_1", "zodiac_old_1", "zodiac_web_3", "other_web_1"} {
+		e.containers[nm] = endpoint.ContainerConfig{}
+	}
+
+	var web, old endpoint.ContainerConfig
+	web.Image = "abc123"
+	old.Image = "def456"
+	store := &memoryHistoryStore{manifests: DeploymentManifests{
+		{Services: []Service{{Name: "zodiac_web_1", OriginalImage: "web:0", ContainerConfig: old}}},
+		{Services: []Service{
+			{Name: "zodiac_web_1", OriginalImage: "web:1", ContainerConfig: web},
+			{Name: "zodiac_old_1", OriginalImage: "old:1", ContainerConfig: old},
+		}},
+	}}
+	restore := useFixture(store, e, &mockRequestComposer{requests: []proxy.ContainerRequest{
+		{Name: "zodiac_web_1", CreateOptions: []byte(`{"Image": "web:1"}`)},
+		{Name: "zodiac_cache_1", CreateOptions: []byte(`{"Image": "redis"}`)},
+	}})
+
+	return e, store, restore
+}
+
+func TestDeploy_DryRun(t *testing.T) {
+	e, store, restore := planFixture()
+	defer restore()
+
+	o, err := Deploy(Options{Flags: map[string]string{"dry-run": "true"}})
+
+	assert.NoError(t, err)
+	result := o.(PlanOutput).Result
+	assert.Equal(t, 3, result.DeploymentID)
+	assert.Equal(t, RecreateStrategy, result.Strategy)
+	assert.Equal(t, []string{"redis"}, result.Pull)
+	assert.Equal(t, []PlannedContainer{
+		{Container: "zodiac_web_1", Action: PlanRecreate, Detail: "unchanged"},
+		{Container: "zodiac_cache_1", Action: PlanCreate},
+		{Container: "zodiac_old_1", Action: PlanRemove},
+		{Container: "zodiac_web_3", Action: PlanKeep, Detail: "not part of either deployment"},
+	}, result.Containers)
+	assert.True(t, DefaultComposer.(*mockRequestComposer).noBuild)
+	assert.Empty(t, e.calls)
+	assert.Len(t, store.manifests, 2)
+	assert.Contains(t, o.ToPrettyOutput(), "Plan: 1 to create, 1 to recreate, 1 to remove, 1 to leave alone")
+}
+
+func TestDeploy_DryRunBuild(t *testing.T) {
+	e, store, restore := planFixture()
+	defer restore()
+	DefaultComposer = &mockBuilderComposer{mockRequestComposer: mockRequestComposer{
+		requests: []proxy.ContainerRequest{
+			{Name: "zodiac_web_1", CreateOptions: []byte(`{"Image": "zodiac_web"}`)},
+		},
+	}}
+
+	o, err := Deploy(Options{Flags: map[string]string{"dry-run": "true"}})
+
+	assert.NoError(t, err)
+	result := o.(PlanOutput).Result
+	assert.Equal(t, []string{"zodiac_web"}, result.Build)
+	assert.Empty(t, result.Pull)
+	assert.Contains(t, o.ToPrettyOutput(), "Images to build: zodiac_web")
+	assert.Empty(t, e.calls)
+
+	store.manifests[1].Services[0].Build = &proxy.BuildInfo{ContextHash: "sha256:1234"}
+	e.images["abc123"] = "abc123"
+
+	o, err = Deploy(Options{Flags: map[string]string{"dry-run": "true"}})
+
+	assert.NoError(t, err)
+	assert.Empty(t, o.(PlanOutput).Result.Build)
+	assert.Empty(t, o.(PlanOutput).Result.Pull)
+}
+
+func TestDeploy_DryRunSeveralEndpoints(t *testing.T) {
+	o, err := Deploy(Options{
+		Flags:     map[string]string{"dry-run": "true"},
+		Endpoints: []string{"tcp://a:2376", "tcp://b:2376"},
+	})
+
+	assert.EqualError(t, err, "--dry-run plans one endpoint at a time")
+	assert.Nil(t, o)
+}
+
+func TestRollback_DryRun(t *testing.T) {
+	e, store, restore := planFixture()
+	defer restore()
+
+	o, err := Rollback(Options{Flags: map[string]string{"dry-run": "true"}})
+
+	assert.NoError(t, err)
+	result := o.(PlanOutput).Result
+	assert.Equal(t, 3, result.DeploymentID)
+	assert.Equal(t, []PlannedContainer{
+		{Container: "zodiac_web_1", Action: PlanRecreate, Detail: "changed Image, ImageID"},
+		{Container: "zodiac_old_1", Action: PlanRemove},
+		{Container: "zodiac_web_3", Action: PlanKeep, Detail: "not part of either deployment"},
+	}, result.Containers)
+	assert.Empty(t, e.calls)
+	assert.Len(t, store.manifests, 2)
+}
+
+func TestTeardown_DryRun(t *testing.T) {
+	e, store, restore := planFixture()
+	defer restore()
+
+	o, err := Teardown(Options{Flags: map[string]string{"dry-run": "true"}})
+
+	assert.NoError(t, err)
+	result := o.(PlanOutput).Result
+	assert.True(t, result.RemoveHistory)
+	assert.Equal(t, []PlannedContainer{
+		{Container: "zodiac_web_1", Action: PlanRemove},
+		{Container: "zodiac_old_1", Action: PlanKeep, Detail: "not part of the deployment"},
+		{Container: "zodiac_web_3", Action: PlanKeep, Detail: "not part of the deployment"},
+	}, result.Containers)
+	assert.Empty(t, e.calls)
+	assert.False(t, store.removed)
+	assert.Contains(t, o.ToPrettyOutput(), "The deployment history would be removed")
+}
@@ -0,0 +1,283 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CenturyLinkLabs/prettycli"
+)
+
+// Retention limits how much deployment history is kept. Keep keeps that many
+// of the most recent deployments and OlderThan, e.g. 30d, keeps those
+// deployed since. With both set a deployment either of them keeps is kept.
+type Retention struct {
+	Keep      int    `yaml:"keep"`
+	OlderThan string `yaml:"older_than"`
+	// GCImages removes the images no retained deployment uses after every
+	// deploy.
+	GCImages bool `yaml:"gc_images"`
+}
+
+func (r Retention) IsSet() bool {
+	return r.Keep > 0 || r.OlderThan != ""
+}
+
+type PruneResult struct {
+	Pruned    []int
+	Kept      int
+	Protected []int `json:",omitempty"`
+}
+
+// Prune trims the deployment history. Pruned deployments are dropped, the
+// others keep their IDs. With the label history store the running containers
+// have to be recreated to carry the trimmed history, so there it takes
+// --force.
+func Prune(options Options) (prettycli.Output, error) {
+	config, err := loadProjectConfig(options.Flags)
+	if err != nil {
+		return nil, err
+	}
+
+	retention := config.Retention
+	if k := options.Flags["keep"]; k != "" {
+		if retention.Keep, err = strconv.Atoi(k); err != nil || retention.Keep < 1 {
+			return nil, fmt.Errorf("Invalid --keep '%s', give the number of deployments to keep", k)
+		}
+	}
+	if age := options.Flags["older-than"]; age != "" {
+		retention.OlderThan = age
+	}
+
+	protect, err := parseIDs(options.Flags["protect"])
+	if err != nil {
+		return nil, err
+	}
+	unprotect, err := parseIDs(options.Flags["unprotect"])
+	if err != nil {
+		return nil, err
+	}
+
+	if !retention.IsSet() && len(protect) == 0 && len(unprotect) == 0 {
+		return nil, errors.New("Specify --keep or --older-than, or set a retention in .zodiac.yml")
+	}
+
+	endpoint, err := endpointFactory(options.EndpointOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	reqs, err := collectRequests(options, true)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := historyStore(options, endpoint, reqs)
+	if err != nil {
+		return nil, err
+	}
+
+	dryRun := isDryRun(options)
+	if !dryRun {
+		release, err := projectLocker(options, endpoint)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+
+	manifests, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	if len(manifests) == 0 {
+		return nil, errors.New("There are no deployments to prune")
+	}
+
+	for _, id := range append(protect, unprotect...) {
+		if _, err := checkDeployment(manifests, id); err != nil {
+			return nil, err
+		}
+	}
+	for _, id := range protect {
+		manifests[manifests.index(id)].Protected = true
+	}
+	for _, id := range unprotect {
+		manifests[manifests.index(id)].Protected = false
+	}
+
+	pruned, err := pruneManifests(manifests, retention, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	result := PruneResult{Pruned: pruned}
+	for i, m := range manifests {
+		if m.Pruned {
+			continue
+		}
+		result.Kept++
+		if m.Protected {
+			result.Protected = append(result.Protected, manifests.id(i))
+		}
+	}
+
+	if dryRun {
+		return ResultOutput{
+			Message: fmt.Sprintf("Would prune %d deployment(s)%s and keep %d, dry run, nothing was changed", len(pruned), idList(pruned), result.Kept),
+			Result:  result,
+		}, nil
+	}
+
+	if len(pruned) == 0 && len(protect) == 0 && len(unprotect) == 0 {
+		return ResultOutput{
+			Message: fmt.Sprintf("Nothing to prune, all %d deployment(s) are kept", result.Kept),
+			Result:  result,
+		}, nil
+	}
+
+	if _, ok := store.(*LabelHistoryStore); ok {
+		if options.Flags["force"] != "true" {
+			return nil, errors.New("Pruning the label history store recreates every running container, run prune with --force to do that or set a retention in .zodiac.yml to prune on the next deploy")
+		}
+		fmt.Fprintln(Progress, "Recreating every container to record the trimmed history in the label history store")
+		current := manifests[len(manifests)-1].Services
+		if err := recreate(current, current, manifests, store, endpoint); err != nil {
+			return nil, err
+		}
+	} else if err := store.Save(manifests); err != nil {
+		return nil, err
+	}
+
+	return ResultOutput{
+		Message: fmt.Sprintf("Pruned %d deployment(s)%s, %d kept", len(pruned), idList(pruned), result.Kept),
+		Result:  result,
+	}, nil
+}
+
+// pruneManifests prunes the deployments the retention doesn't keep, in place,
+// and returns their IDs. The active deployment and protected ones are never
+// pruned.
+func pruneManifests(manifests DeploymentManifests, r Retention, now time.Time) ([]int, error) {
+	if !r.IsSet() {
+		return nil, nil
+	}
+
+	var cutoff time.Time
+	if r.OlderThan != "" {
+		age, err := parseAge(r.OlderThan)
+		if err != nil {
+			return nil, err
+		}
+		cutoff = now.Add(-age)
+	}
+
+	var pruned []int
+	recent := 0
+	for i := len(manifests) - 1; i >= 0; i-- {
+		m := manifests[i]
+		if m.Pruned {
+			continue
+		}
+		recent++
+
+		if i == len(manifests)-1 || m.Protected || recent <= r.Keep {
+			continue
+		}
+		if r.OlderThan != "" {
+			deployed, err := time.ParseInLocation(BasicDateTime, m.DeployedAt, time.Local)
+			if err != nil || deployed.After(cutoff) {
+				continue
+			}
+		}
+
+		id := manifests.id(i)
+		manifests[i] = DeploymentManifest{ID: id, DeployedAt: m.DeployedAt, Pruned: true}
+		pruned = append([]int{id}, pruned...)
+	}
+
+	return pruned, nil
+}
+
+// manifestImages lists the image IDs the deployment ran.
+func manifestImages(m DeploymentManifest) []string {
+	var images []string
+	seen := map[string]bool{}
+	for _, svc := range m.Services {
+		if id := svc.ContainerConfig.Image; id != "" && !seen[id] {
+			seen[id] = true
+			images = append(images, id)
+		}
+	}
+	return images
+}
+
+// parseAge reads a duration like 12h, also allowing days, e.g. 30d.
+func parseAge(s string) (time.Duration, error) {
+	invalid := fmt.Errorf("Invalid age '%s', use e.g. 30d or 12h", s)
+
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return 0, invalid
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, invalid
+	}
+	return d, nil
+}
+
+func parseIDs(s string) ([]int, error) {
+	var ids []int
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		id, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid deployment ID '%s'", part)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func idList(ids []int) string {
+	if len(ids) == 0 {
+		return ""
+	}
+	var s []string
+	for _, id := range ids {
+		s = append(s, strconv.Itoa(id))
+	}
+	return " (" + strings.Join(s, ", ") + ")"
+}
+
+// checkDeployment returns the place in the history of the deployment with
+// the ID, failing unless it is still there.
+func checkDeployment(manifests DeploymentManifests, id int) (int, error) {
+	if i := manifests.index(id); i >= 0 && !manifests[i].Pruned {
+		return i, nil
+	}
+	if id >= 1 && id < manifests.nextID() {
+		return -1, fmt.Errorf("Deployment %d was pruned", id)
+	}
+	return -1, fmt.Errorf("Deployment %d does not exist", id)
+}
+
+// previousDeployment is the place of the newest deployment before the i-th
+// that wasn't pruned, -1 if there is none.
+func previousDeployment(manifests DeploymentManifests, i int) int {
+	for i--; i >= 0; i-- {
+		if !manifests[i].Pruned {
+			return i
+		}
+	}
+	return -1
+}
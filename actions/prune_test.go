@@ -0,0 +1,182 @@
+package actions
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/CenturyLinkLabs/zodiac/proxy"
+	"github.com/stretchr/testify/assert"
+)
+
+func manifestsDeployedAt(now time.Time, ages ...time.Duration) DeploymentManifests {
+	var manifests DeploymentManifests
+	for _, age := range ages {
+		manifests = append(manifests, DeploymentManifest{
+			DeployedAt: now.Add(-age).Format(BasicDateTime),
+			Services:   []Service{{Name: "zodiac_web_1"}},
+		})
+	}
+	return manifests
+}
+
+func TestPruneManifests_Keep(t *testing.T) {
+	now := time.Now()
+	manifests := manifestsDeployedAt(now, 4*time.Hour, 3*time.Hour, 2*time.Hour, time.Hour)
+	manifests[0].Protected = true
+
+	pruned, err := pruneManifests(manifests, Retention{Keep: 1}, now)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2, 3}, pruned)
+	assert.False(t, manifests[0].Pruned)
+	assert.True(t, manifests[1].Pruned)
+	assert.Empty(t, manifests[1].Services)
+	assert.Equal(t, manifests[2].DeployedAt, now.Add(-2*time.Hour).Format(BasicDateTime))
+	assert.False(t, manifests[3].Pruned)
+}
+
+func TestPruneManifests_OlderThanAndKeep(t *testing.T) {
+	now := time.Now()
+	manifests := manifestsDeployedAt(now, 60*24*time.Hour, 40*24*time.Hour, 20*24*time.Hour, time.Hour)
+
+	pruned, err := pruneManifests(manifests, Retention{Keep: 3, OlderThan: "30d"}, now)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1}, pruned)
+}
+
+func TestPruneManifests_NeverPrunesActive(t *testing.T) {
+	now := time.Now()
+	manifests := manifestsDeployedAt(now, 60*24*time.Hour)
+
+	pruned, err := pruneManifests(manifests, Retention{OlderThan: "1d"}, now)
+
+	assert.NoError(t, err)
+	assert.Empty(t, pruned)
+}
+
+func TestParseAge(t *testing.T) {
+	d, err := parseAge("30d")
+	assert.NoError(t, err)
+	assert.Equal(t, 30*24*time.Hour, d)
+
+	d, err = parseAge("12h")
+	assert.NoError(t, err)
+	assert.Equal(t, 12*time.Hour, d)
+
+	_, err = parseAge("a month")
+	assert.EqualError(t, err, "Invalid age 'a month', use e.g. 30d or 12h")
+}
+
+func TestPrune(t *testing.T) {
+	store := &memoryHistoryStore{manifests: manifestsDeployedAt(time.Now(), 3*time.Hour, 2*time.Hour, time.Hour)}
+	defer useFixture(store, mockEndpoint{}, &mockComposer{})()
+
+	o, err := Prune(Options{Flags: map[string]string{"keep": "1", "protect": "1"}})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Pruned 1 deployment(s) (2), 2 kept", o.ToPrettyOutput())
+	assert.Equal(t, PruneResult{Pruned: []int{2}, Kept: 2, Protected: []int{1}}, o.(ResultOutput).Result)
+	assert.Len(t, store.manifests, 2)
+	assert.True(t, store.manifests[0].Protected)
+	assert.Equal(t, 3, store.manifests[1].ID)
+
+	o, err = Show(Options{Args: []string{"3"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, o.(ResultOutput).Result.(ShowResult).DeploymentID)
+
+	_, err = Show(Options{Args: []string{"2"}})
+	assert.EqualError(t, err, "Deployment 2 was pruned")
+
+	_, err = Prune(Options{Flags: map[string]string{"protect": "2"}})
+	assert.EqualError(t, err, "Deployment 2 was pruned")
+}
+
+func TestPrune_LabelStoreNeedsForce(t *testing.T) {
+	e := newMockEngineEndpoint()
+	manifests := manifestsDeployedAt(time.Now(), 2*time.Hour, time.Hour)
+	manifests[1].Services = []Service{{Name: "zodiac_web_1"}}
+	labels, _ := (&LabelHistoryStore{}).Labels(manifests)
+	e.containers["zodiac_web_1"] = labelledConfig(labels)
+	store := &LabelHistoryStore{endpoint: e, containerNames: []string{"zodiac_web_1"}}
+	defer useFixture(store, e, &mockComposer{})()
+
+	_, err := Prune(Options{Flags: map[string]string{"keep": "1"}})
+
+	assert.EqualError(t, err, "Pruning the label history store recreates every running container, run prune with --force to do that or set a retention in .zodiac.yml to prune on the next deploy")
+	assert.Empty(t, e.calls)
+
+	_, err = Prune(Options{Flags: map[string]string{"keep": "1", "force": "true"}})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"remove zodiac_web_1", "start zodiac_web_1"}, e.calls)
+	loaded, _ := store.Load()
+	assert.Len(t, loaded, 1)
+}
+
+func TestPrune_NoRetention(t *testing.T) {
+	o, err := Prune(Options{Flags: map[string]string{"file": "/nonexistent/docker-compose.yml"}})
+
+	assert.Nil(t, o)
+	assert.EqualError(t, err, "Specify --keep or --older-than, or set a retention in .zodiac.yml")
+}
+
+func TestRollback_SkipsPrunedDeployments(t *testing.T) {
+	manifests := manifestsDeployedAt(time.Now(), 3*time.Hour, 2*time.Hour, time.Hour)
+	manifests[0].Message = "first"
+	manifests[1] = DeploymentManifest{Pruned: true}
+
+	dm, id, err := fetchTarget(manifests, nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, id)
+	assert.Equal(t, "first", dm.Message)
+
+	_, _, err = fetchTarget(manifests, []string{"2"})
+	assert.EqualError(t, err, "Deployment 2 was pruned")
+}
+
+func TestDeploy_PrunesOnlyAfterSuccess(t *testing.T) {
+	e := newMockEngineEndpoint()
+	e.failStart = "zodiac_web_1"
+	store := &memoryHistoryStore{manifests: manifestsDeployedAt(time.Now(), 2*time.Hour, time.Hour)}
+	reqs := []proxy.ContainerRequest{{Name: "zodiac_web_1", CreateOptions: []byte(`{"Image": "web"}`)}}
+	config := projectConfig{Retention: Retention{Keep: 1}}
+	defer useFixture(store, e, &mockComposer{})()
+
+	_, err := deployTo(Options{}, e, reqs, nil, recreate, config)
+
+	assert.EqualError(t, err, "port is already allocated (the previous deployment was restored)")
+	assert.Len(t, store.manifests, 2)
+	assert.False(t, store.manifests[0].Pruned)
+
+	_, err = deployTo(Options{}, e, reqs, nil, recreate, config)
+
+	assert.NoError(t, err)
+	assert.Len(t, store.manifests, 1)
+	assert.Equal(t, 3, store.manifests[0].ID)
+}
+
+func TestDeploymentManifests_IDs(t *testing.T) {
+	// The first two were saved before IDs were stored.
+	manifests := DeploymentManifests{{}, {}, {ID: 5}, {}}
+
+	assert.Equal(t, []int{1, 2, 5, 6}, []int{manifests.id(0), manifests.id(1), manifests.id(2), manifests.id(3)})
+	assert.Equal(t, 7, manifests.nextID())
+	assert.Equal(t, 2, manifests.index(5))
+	assert.Equal(t, -1, manifests.index(4))
+
+	_, err := checkDeployment(manifests, 4)
+	assert.EqualError(t, err, "Deployment 4 was pruned")
+	_, err = checkDeployment(manifests, 7)
+	assert.EqualError(t, err, "Deployment 7 does not exist")
+}
+
+func TestRestorePrevious_PrunedDeployment(t *testing.T) {
+	manifests := DeploymentManifests{{Pruned: true}, {Services: []Service{{Name: "zodiac_web_1"}}}}
+
+	err := restorePrevious(errors.New("boom"), nil, manifests, &memoryHistoryStore{}, newMockEngineEndpoint())
+
+	assert.EqualError(t, err, "boom (restoring the previous deployment also failed: it was pruned from the history)")
+}
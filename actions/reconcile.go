@@ -0,0 +1,210 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CenturyLinkLabs/prettycli"
+	"github.com/CenturyLinkLabs/zodiac/endpoint"
+	"github.com/samalba/dockerclient"
+)
+
+type ReconcileResult struct {
+	DeploymentID int
+	Recreated    []string
+	Removed      []string
+}
+
+// Reconcile converges the endpoint back to the active deployment. Containers
+// that are missing or have drifted are recreated from the stored config, and
+// project containers that aren't part of the deployment are removed. The
+// repair is recorded as a new deployment.
+func Reconcile(options Options) (prettycli.Output, error) {
+
+	endpoint, err := endpointFactory(options.EndpointOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := projectLocker(options, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	reqs, err := collectRequests(options, true)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := historyStore(options, endpoint, reqs)
+	if err != nil {
+		return nil, err
+	}
+
+	manifests, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	if len(manifests) == 0 {
+		return nil, errors.New("There are no deployments to reconcile")
+	}
+
+	dm := manifests[len(manifests)-1]
+	dm.ID = manifests.id(len(manifests) - 1)
+	wanted := map[string]bool{}
+	recreate := map[string]bool{}
+	var recreated []string
+	for _, svc := range dm.Services {
+		for _, name := range svc.ContainerNames() {
+			wanted[name] = true
+			status, err := containerStatus(svc, name, endpoint)
+			if err != nil {
+				return nil, err
+			}
+			if len(status.Drift) > 0 {
+				fmt.Fprintf(Progress, "%s: %s\n", name, strings.Join(status.Drift, ", "))
+				recreate[name] = true
+				recreated = append(recreated, name)
+			}
+		}
+	}
+
+	strays, err := strayContainers(projectName(options), manifests.services(), wanted, endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(recreated) == 0 && len(strays) == 0 {
+		return ResultOutput{
+			Message: fmt.Sprintf("Nothing to reconcile, all %d container(s) match deployment %d", len(wanted), dm.ID),
+			Result:  ReconcileResult{DeploymentID: dm.ID},
+		}, nil
+	}
+
+	dm.ID = manifests.nextID()
+	dm.DeployedAt = time.Now().Format(BasicDateTime)
+	dm.Message = options.Flags["message"]
+	if dm.Message == "" {
+		dm.Message = reconcileMessage(recreated, strays)
+	}
+
+	// The label history store records the new entry on the containers as
+	// they are created, so every container is recreated to carry it.
+	if _, ok := store.(*LabelHistoryStore); ok {
+		fmt.Fprintln(Progress, "Recreating every container to record the repair in the label history store")
+		recreated = nil
+		for _, svc := range dm.Services {
+			for _, name := range svc.ContainerNames() {
+				recreate[name] = true
+				recreated = append(recreated, name)
+			}
+		}
+	}
+	dm.Health = nil
+	dm.Protected = false
+	manifests = append(manifests, dm)
+
+	for _, name := range strays {
+		fmt.Fprintf(Progress, "Removing %s\n", name)
+		if err := endpoint.RemoveContainer(name); err != nil {
+			return nil, err
+		}
+	}
+
+	historyLabels, err := store.Labels(manifests)
+	if err != nil {
+		return nil, err
+	}
+	for _, spec := range containerSpecs(dm.Services, historyLabels) {
+		if !recreate[spec.Name] {
+			continue
+		}
+
+		if _, err := endpoint.InspectContainer(spec.Name); err == nil {
+			if err := endpoint.RemoveContainer(spec.Name); err != nil {
+				return nil, err
+			}
+		}
+		fmt.Fprintf(Progress, "Creating %s\n", spec.Name)
+		if err := endpoint.StartContainer(spec.Name, spec.Config); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := store.Save(manifests); err != nil {
+		return nil, err
+	}
+
+	return ResultOutput{
+		Message: fmt.Sprintf("Successfully reconciled: recreated %d container(s), removed %d", len(recreated), len(strays)),
+		Result:  ReconcileResult{DeploymentID: dm.ID, Recreated: recreated, Removed: strays},
+	}, nil
+}
+
+// strayContainers finds the containers of the project that aren't part of
+// the deployment, like those left behind by a scale down or an interrupted
+// blue-green deploy. Only the numbered containers of the services in the
+// history count, so one-off run containers and anything else named after
+// the project are left alone.
+func strayContainers(project string, services, wanted map[string]bool, e endpoint.Endpoint) ([]string, error) {
+	containers, err := e.ListContainers()
+	if err != nil {
+		return nil, err
+	}
+
+	var strays []string
+	for _, c := range containers {
+		name := containerName(c)
+		if wanted[name] || !isServiceContainer(name, project, services) {
+			continue
+		}
+		strays = append(strays, name)
+	}
+	sort.Strings(strays)
+	return strays, nil
+}
+
+// isServiceContainer reports whether name is <project>_<service>_<n> for one
+// of the services, optionally with a blue-green suffix.
+func isServiceContainer(name, project string, services map[string]bool) bool {
+	name = strings.TrimSuffix(strings.TrimSuffix(name, nextSuffix), previousSuffix)
+	p, service, ok := splitContainerName(name)
+	if !ok || p != project || !services[service] {
+		return false
+	}
+	_, err := strconv.Atoi(name[strings.LastIndex(name, "_")+1:])
+	return err == nil
+}
+
+// containerName is the name of a listed container. The names also hold the
+// aliases other containers link to it by, e.g. /app_web_1/db, and Swarm
+// prefixes them with the node, so the shortest one is the container's own.
+func containerName(c dockerclient.Container) string {
+	var parts []string
+	for _, name := range c.Names {
+		p := strings.Split(name, "/")
+		if parts == nil || len(p) < len(parts) {
+			parts = p
+		}
+	}
+	if parts == nil {
+		return ""
+	}
+	return parts[len(parts)-1]
+}
+
+func reconcileMessage(recreated, removed []string) string {
+	var parts []string
+	if len(recreated) > 0 {
+		parts = append(parts, "recreated "+strings.Join(recreated, ", "))
+	}
+	if len(removed) > 0 {
+		parts = append(parts, "removed "+strings.Join(removed, ", "))
+	}
+	return "Reconcile: " + strings.Join(parts, "; ")
+}
@@ -0,0 +1,148 @@
+package actions
+
+import (
+	"encoding/json"
+	"sort"
+	"testing"
+
+	"github.com/CenturyLinkLabs/zodiac/endpoint"
+	"github.com/CenturyLinkLabs/zodiac/proxy"
+	"github.com/samalba/dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func reconcileFixture(e *mockEngineEndpoint) (*memoryHistoryStore, func()) {
+	var web, db endpoint.ContainerConfig
+	web.Image = "web:1"
+	web.Env = []string{"DEBUG=false"}
+	db.Image = "db:1"
+
+	store := &memoryHistoryStore{manifests: DeploymentManifests{{
+		Services: []Service{
+			{Name: "zodiac_web_1", ContainerConfig: web, Scale: 2},
+			{Name: "zodiac_db_1", ContainerConfig: db},
+		},
+		Message: "initial",
+	}}}
+
+	return store, useFixture(store, e, &mockComposer{})
+}
+
+func TestReconcile(t *testing.T) {
+	e := newMockEngineEndpoint()
+	store, restore := reconcileFixture(e)
+	defer restore()
+
+	var changed endpoint.ContainerConfig
+	changed.Image = "web:1"
+	changed.Env = []string{"DEBUG=true"}
+	e.containers["zodiac_web_1"] = changed
+	e.containers["zodiac_db_1"] = endpoint.ContainerConfig{ContainerConfig: dockerclient.ContainerConfig{Image: "db:1"}}
+	e.containers["zodiac_web_3"] = endpoint.ContainerConfig{}
+	e.containers["zodiac_zodiac_history"] = endpoint.ContainerConfig{}
+	e.containers["other_web_1"] = endpoint.ContainerConfig{}
+
+	o, err := Reconcile(Options{})
+
+	assert.NoError(t, err)
+	result := o.(ResultOutput).Result.(ReconcileResult)
+	assert.Equal(t, 2, result.DeploymentID)
+	assert.Equal(t, []string{"zodiac_web_1", "zodiac_web_2"}, result.Recreated)
+	assert.Equal(t, []string{"zodiac_web_3"}, result.Removed)
+	assert.Equal(t, []string{"remove zodiac_web_3", "remove zodiac_web_1", "start zodiac_web_1", "start zodiac_web_2"}, e.calls)
+	assert.Equal(t, []string{"DEBUG=false"}, e.containers["zodiac_web_1"].Env)
+
+	var names []string
+	for nm := range e.containers {
+		names = append(names, nm)
+	}
+	sort.Strings(names)
+	assert.Equal(t, []string{"other_web_1", "zodiac_db_1", "zodiac_web_1", "zodiac_web_2", "zodiac_zodiac_history"}, names)
+
+	assert.Len(t, store.manifests, 2)
+	assert.Equal(t, "Reconcile: recreated zodiac_web_1, zodiac_web_2; removed zodiac_web_3", store.manifests[1].Message)
+}
+
+func TestReconcile_OnlyRemovesServiceContainers(t *testing.T) {
+	e := newMockEngineEndpoint()
+	store, restore := reconcileFixture(e)
+	defer restore()
+	for _, svc := range store.manifests[0].Services {
+		for _, nm := range svc.ContainerNames() {
+			e.containers[nm] = svc.ContainerConfig
+		}
+	}
+	e.containers["zodiac_web_1_next"] = endpoint.ContainerConfig{}
+	e.containers["zodiac_web_run_1"] = endpoint.ContainerConfig{}
+	e.containers["zodiac_tools_1"] = endpoint.ContainerConfig{}
+	e.containers["zodiac_zodiac_lock"] = endpoint.ContainerConfig{}
+
+	o, err := Reconcile(Options{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"zodiac_web_1_next"}, o.(ResultOutput).Result.(ReconcileResult).Removed)
+
+	var names []string
+	for nm := range e.containers {
+		names = append(names, nm)
+	}
+	sort.Strings(names)
+	assert.Equal(t, []string{"zodiac_db_1", "zodiac_tools_1", "zodiac_web_1", "zodiac_web_2", "zodiac_web_run_1", "zodiac_zodiac_lock"}, names)
+}
+
+func TestReconcile_NothingToDo(t *testing.T) {
+	e := newMockEngineEndpoint()
+	store, restore := reconcileFixture(e)
+	defer restore()
+	for _, svc := range store.manifests[0].Services {
+		for _, nm := range svc.ContainerNames() {
+			e.containers[nm] = svc.ContainerConfig
+		}
+	}
+
+	o, err := Reconcile(Options{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Nothing to reconcile, all 3 container(s) match deployment 1", o.ToPrettyOutput())
+	assert.Empty(t, e.calls)
+	assert.Len(t, store.manifests, 1)
+}
+
+func TestReconcile_LabelStoreRecreatesEverything(t *testing.T) {
+	e := newMockEngineEndpoint()
+	memory, restore := reconcileFixture(e)
+	defer restore()
+	labels, _ := (&LabelHistoryStore{}).Labels(memory.manifests)
+	for _, svc := range memory.manifests[0].Services {
+		for _, nm := range svc.ContainerNames() {
+			cc := svc.ContainerConfig
+			cc.Labels = labels
+			e.containers[nm] = cc
+		}
+	}
+	e.containers["zodiac_web_3"] = endpoint.ContainerConfig{}
+	historyStoreFactory = func(string, Options, endpoint.Endpoint, []proxy.ContainerRequest) (HistoryStore, error) {
+		return &LabelHistoryStore{endpoint: e, containerNames: []string{"zodiac_web_1", "zodiac_db_1"}}, nil
+	}
+
+	o, err := Reconcile(Options{})
+
+	assert.NoError(t, err)
+	result := o.(ResultOutput).Result.(ReconcileResult)
+	assert.Equal(t, []string{"zodiac_web_1", "zodiac_web_2", "zodiac_db_1"}, result.Recreated)
+	assert.Equal(t, []string{"zodiac_web_3"}, result.Removed)
+	for _, nm := range result.Recreated {
+		var manifests DeploymentManifests
+		assert.NoError(t, json.Unmarshal([]byte(e.containers[nm].Labels[manifestLabel]), &manifests))
+		assert.Len(t, manifests, 2, nm)
+		assert.Equal(t, "Reconcile: removed zodiac_web_3", manifests[1].Message)
+	}
+}
+
+func TestContainerName(t *testing.T) {
+	c := dockerclient.Container{Names: []string{"/app_web_1/db", "/app_db_1"}}
+	assert.Equal(t, "app_db_1", containerName(c))
+
+	c = dockerclient.Container{Names: []string{"/node-1/app_web_1/db", "/node-1/app_db_1"}}
+	assert.Equal(t, "app_db_1", containerName(c))
+}
@@ -10,24 +10,37 @@ import (
 )
 
 func Rollback(options Options) (prettycli.Output, error) {
-	fmt.Println("Rolling back your application...")
+	start := time.Now()
+	dryRun := isDryRun(options)
+	if !dryRun {
+		fmt.Fprintln(Progress, "Rolling back your application...")
+	}
 
 	endpoint, err := endpointFactory(options.EndpointOptions)
 	if err != nil {
 		return nil, err
 	}
 
-	reqs, err := collectRequests(options, false)
+	if !dryRun {
+		release, err := projectLocker(options, endpoint)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+
+	reqs, err := collectRequests(options, true)
 	if err != nil {
 		return nil, err
 	}
 
-	// Get most recent deployment's manifests
-	manifests, err := getDeploymentManifests(reqs, endpoint)
+	store, err := historyStore(options, endpoint, reqs)
+	if err != nil {
+		return nil, err
+	}
+
+	manifests, err := store.Load()
 	if err != nil {
-		if len(manifests) <= 1 {
-			return nil, errors.New("There are no previous deployments")
-		}
 		return nil, err
 	}
 
@@ -40,16 +53,50 @@ func Rollback(options Options) (prettycli.Output, error) {
 		return nil, err
 	}
 
-	// shut down current deployment
+	if newDeployment.Services, err = resolveVolumesFrom(newDeployment.Services); err != nil {
+		return nil, err
+	}
+
 	currentDeployment := manifests[len(manifests)-1]
 
-	for _, svc := range currentDeployment.Services {
-		endpoint.RemoveContainer(svc.Name)
+	if dryRun {
+		containers, err := planContainers(currentDeployment.Services, newDeployment.Services, projectName(options), manifests.services(), endpoint)
+		if err != nil {
+			return nil, err
+		}
+		missing, err := missingImages(newDeployment.Services, endpoint)
+		if err != nil {
+			return nil, err
+		}
+		var pull []string
+		for _, svc := range missing {
+			pull = append(pull, pullName(svc))
+		}
+		return planOutput(PlanResult{
+			Command:      "rollback",
+			DeploymentID: manifests.nextID(),
+			Strategy:     RecreateStrategy,
+			Containers:   containers,
+			Pull:         pull,
+		}), nil
 	}
 
+	// Nothing is removed before the images to roll back to are known to be
+	// there.
+	auths, err := authConfigsLoader(options.DockerConfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureImages(newDeployment.Services, auths, endpoint); err != nil {
+		return nil, err
+	}
+
+	newDeployment.ID = manifests.nextID()
 	manifests = append(manifests, newDeployment)
 	newDeployment = manifests[len(manifests)-1]
 	manifests[len(manifests)-1].DeployedAt = time.Now().Format(BasicDateTime)
+	manifests[len(manifests)-1].Protected = false
+	manifests[len(manifests)-1].Health = nil
 
 	if options.Flags["message"] == "" {
 		manifests[len(manifests)-1].Message = fmt.Sprintf("Rollback to: #%d %s", deploymentID, manifests[len(manifests)-1].Message)
@@ -57,30 +104,39 @@ func Rollback(options Options) (prettycli.Output, error) {
 		manifests[len(manifests)-1].Message = options.Flags["message"]
 	}
 
-	if err := startServices(newDeployment.Services, manifests, endpoint); err != nil {
+	// If the rollback fails partway the current deployment is put back.
+	if err := recreate(currentDeployment.Services, newDeployment.Services, manifests, store, endpoint); err != nil {
 		return nil, err
 	}
 
-	output := fmt.Sprintf("Successfully rolled back to deployment: %d", deploymentID)
-	return prettycli.PlainOutput{output}, nil
+	return ResultOutput{
+		Message: fmt.Sprintf("Successfully rolled back to deployment: %d", deploymentID),
+		Result: DeployResult{
+			DeploymentID: newDeployment.ID,
+			RolledBackTo: deploymentID,
+			Strategy:     RecreateStrategy,
+			Containers:   containerNames(newDeployment.Services),
+			Duration:     time.Since(start).String(),
+		},
+	}, nil
 }
 
 func fetchTarget(manifests DeploymentManifests, args []string) (DeploymentManifest, int, error) {
-	var target int
-	if len(args) == 0 {
-		target = len(manifests) - 2
-	} else {
-		var err error
-		target, err = strconv.Atoi(args[0])
+	target := previousDeployment(manifests, len(manifests)-1)
+	if len(args) > 0 {
+		id, err := strconv.Atoi(args[0])
 		if err != nil {
 			return DeploymentManifest{}, -1, err
 		}
-		target--
+		target = manifests.index(id)
+		if (target < 0 || manifests[target].Pruned) && id >= 1 && id < manifests.nextID() {
+			return DeploymentManifest{}, -1, fmt.Errorf("Deployment %d was pruned", id)
+		}
 	}
 
-	if (target < 0) || (target >= len(manifests)) {
+	if target < 0 {
 		return DeploymentManifest{}, -1, errors.New("The specified index does not exist")
 	}
 
-	return manifests[target], target + 1, nil
+	return manifests[target], manifests.id(target), nil
 }
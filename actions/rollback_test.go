@@ -68,7 +68,7 @@ func TestRollback_Success(t *testing.T) {
 		},
 	}
 
-	proxyFactory = func(string, endpoint.Endpoint, bool) proxy.Proxy {
+	proxyFactory = func(string, endpoint.Endpoint, bool, endpoint.BuildOptions) proxy.Proxy {
 		return &mockProxy{
 			requests: []proxy.ContainerRequest{
 				{
@@ -148,7 +148,7 @@ func TestRollbackWithID_Success(t *testing.T) {
 		},
 	}
 
-	proxyFactory = func(string, endpoint.Endpoint, bool) proxy.Proxy {
+	proxyFactory = func(string, endpoint.Endpoint, bool, endpoint.BuildOptions) proxy.Proxy {
 		return &mockProxy{
 			requests: []proxy.ContainerRequest{
 				{
@@ -212,7 +212,7 @@ func TestRollbackWithNoPreviousDeployment_Error(t *testing.T) {
 		},
 	}
 
-	proxyFactory = func(string, endpoint.Endpoint, bool) proxy.Proxy {
+	proxyFactory = func(string, endpoint.Endpoint, bool, endpoint.BuildOptions) proxy.Proxy {
 		return &mockProxy{
 			requests: []proxy.ContainerRequest{
 				{
@@ -284,7 +284,7 @@ func TestRollbackWithNonexistingID_Error(t *testing.T) {
 		},
 	}
 
-	proxyFactory = func(string, endpoint.Endpoint, bool) proxy.Proxy {
+	proxyFactory = func(string, endpoint.Endpoint, bool, endpoint.BuildOptions) proxy.Proxy {
 		return &mockProxy{
 			requests: []proxy.ContainerRequest{
 				{
@@ -325,3 +325,173 @@ func TestRollbackWithNonexistingID_Error(t *testing.T) {
 	assert.Len(t, startCalls, 0)
 	assert.Len(t, removeCalls, 0)
 }
+
+func TestRollback_VolumesFrom(t *testing.T) {
+	var startCalls []capturedStartParams
+	webConfig := endpoint.ContainerConfig{}
+	webConfig.HostConfig.VolumesFrom = []string{"data"}
+
+	previousManis := []DeploymentManifest{
+		{
+			Services: []Service{
+				{
+					Name:            "zodiac_web_1",
+					ContainerConfig: webConfig,
+				},
+				{
+					Name:            "zodiac_data_1",
+					ContainerConfig: endpoint.ContainerConfig{},
+				},
+			},
+		},
+		{
+			Services: []Service{
+				{
+					Name:            "zodiac_web_1",
+					ContainerConfig: endpoint.ContainerConfig{},
+				},
+			},
+		},
+	}
+	previousManisBlob, _ := json.Marshal(previousManis)
+
+	ci := dockerclient.ContainerInfo{
+		Config: &dockerclient.ContainerConfig{
+			Labels: map[string]string{
+				"zodiacManifest": string(previousManisBlob),
+			},
+		},
+	}
+
+	proxyFactory = func(string, endpoint.Endpoint, bool, endpoint.BuildOptions) proxy.Proxy {
+		return &mockProxy{
+			requests: []proxy.ContainerRequest{
+				{
+					Name:          "zodiac_web_1",
+					CreateOptions: []byte(`{"Image": "zodiac"}`),
+				},
+			},
+		}
+	}
+	DefaultComposer = &mockComposer{}
+
+	e := mockRollbackEndpoint{
+		inspectCallback: func(nm string) (*dockerclient.ContainerInfo, error) {
+			return &ci, nil
+		},
+		startCallback: func(nm string, cfg endpoint.ContainerConfig) error {
+			startCalls = append(startCalls, capturedStartParams{
+				Name:   nm,
+				Config: cfg,
+			})
+			return nil
+		},
+	}
+
+	endpointFactory = func(endpoint.EndpointOptions) (endpoint.Endpoint, error) {
+		return e, nil
+	}
+
+	_, err := Rollback(Options{})
+
+	assert.NoError(t, err)
+	assert.Len(t, startCalls, 2)
+	assert.Equal(t, "zodiac_data_1", startCalls[0].Name)
+	assert.Equal(t, "zodiac_web_1", startCalls[1].Name)
+	assert.Equal(t, []string{"zodiac_data_1"}, startCalls[1].Config.HostConfig.VolumesFrom)
+}
+
+func TestRollback_RestoresScale(t *testing.T) {
+	var startCalls []string
+	var removeCalls []string
+
+	previousManis := []DeploymentManifest{
+		{
+			Services: []Service{
+				{Name: "zodiac_web_1", Scale: 3},
+			},
+		},
+		{
+			Services: []Service{
+				{Name: "zodiac_web_1", Scale: 2},
+			},
+		},
+	}
+	previousManisBlob, _ := json.Marshal(previousManis)
+
+	ci := dockerclient.ContainerInfo{
+		Config: &dockerclient.ContainerConfig{
+			Labels: map[string]string{
+				"zodiacManifest": string(previousManisBlob),
+			},
+		},
+	}
+
+	proxyFactory = func(string, endpoint.Endpoint, bool, endpoint.BuildOptions) proxy.Proxy {
+		return &mockProxy{
+			requests: []proxy.ContainerRequest{{Name: "zodiac_web_1"}},
+		}
+	}
+	DefaultComposer = &mockComposer{}
+
+	e := mockRollbackEndpoint{
+		inspectCallback: func(nm string) (*dockerclient.ContainerInfo, error) {
+			return &ci, nil
+		},
+		startCallback: func(nm string, cfg endpoint.ContainerConfig) error {
+			startCalls = append(startCalls, nm)
+			return nil
+		},
+		removeCallback: func(nm string) error {
+			removeCalls = append(removeCalls, nm)
+			return nil
+		},
+	}
+
+	endpointFactory = func(endpoint.EndpointOptions) (endpoint.Endpoint, error) {
+		return e, nil
+	}
+
+	_, err := Rollback(Options{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"zodiac_web_1", "zodiac_web_2", "zodiac_web_3"}, removeCalls)
+	assert.Equal(t, []string{"zodiac_web_1", "zodiac_web_2", "zodiac_web_3"}, startCalls)
+}
+
+func TestRollback_FailureRestoresCurrentDeployment(t *testing.T) {
+	e := newMockEngineEndpoint()
+	var current, target endpoint.ContainerConfig
+	current.Image = "current"
+	target.Image = "target"
+	e.containers["zodiac_web_1"] = current
+	e.failStart = "zodiac_web_1"
+
+	store := &memoryHistoryStore{manifests: DeploymentManifests{
+		{Services: []Service{{Name: "zodiac_web_1", ContainerConfig: target}}},
+		{Services: []Service{{Name: "zodiac_web_1", ContainerConfig: current}}},
+	}}
+	defer useFixture(store, e, &mockRequestComposer{requests: []proxy.ContainerRequest{{Name: "zodiac_web_1"}}})()
+
+	_, err := Rollback(Options{})
+
+	assert.IsType(t, FailedDeployError{}, err)
+	assert.EqualError(t, err, "port is already allocated (the previous deployment was restored)")
+	assert.Equal(t, "current", e.containers["zodiac_web_1"].Image)
+	assert.Len(t, store.manifests, 2)
+}
+
+func TestRollback_DoesNotBuild(t *testing.T) {
+	e := newMockEngineEndpoint()
+	store := &memoryHistoryStore{manifests: DeploymentManifests{
+		{Services: []Service{{Name: "zodiac_web_1"}}},
+		{Services: []Service{{Name: "zodiac_web_1"}}},
+	}}
+	c := &mockRequestComposer{requests: []proxy.ContainerRequest{{Name: "zodiac_web_1"}}}
+	defer useFixture(store, e, c)()
+
+	_, err := Rollback(Options{})
+
+	assert.NoError(t, err)
+	assert.True(t, c.noBuild)
+}
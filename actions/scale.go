@@ -0,0 +1,230 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CenturyLinkLabs/prettycli"
+	"github.com/CenturyLinkLabs/zodiac/endpoint"
+)
+
+func Scale(options Options) (prettycli.Output, error) {
+	if len(options.Args) == 0 {
+		return nil, errors.New("Specify the services to scale, e.g. web=3")
+	}
+
+	scales, err := parseScale(strings.Join(options.Args, ","))
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	fmt.Fprintln(Progress, "Scaling your application...")
+
+	endpoint, err := endpointFactory(options.EndpointOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := projectLocker(options, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	reqs, err := collectRequests(options, true)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := historyStore(options, endpoint, reqs)
+	if err != nil {
+		return nil, err
+	}
+
+	manifests, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(manifests) == 0 {
+		return nil, errors.New("There are no deployments to scale")
+	}
+
+	current := manifests[len(manifests)-1]
+	dm := DeploymentManifest{
+		ID:         manifests.nextID(),
+		Services:   make([]Service, len(current.Services)),
+		DeployedAt: time.Now().Format(BasicDateTime),
+		Message:    options.Flags["message"],
+	}
+	copy(dm.Services, current.Services)
+
+	if err := setScale(dm.Services, scales); err != nil {
+		return nil, err
+	}
+
+	if dm.Message == "" {
+		dm.Message = fmt.Sprintf("Scale %s", formatScale(scales))
+	}
+
+	manifests = append(manifests, dm)
+
+	// The label history store records the new entry on the containers as
+	// they are created, so every container is recreated to carry it.
+	if _, ok := store.(*LabelHistoryStore); ok {
+		fmt.Fprintln(Progress, "Recreating every container to record the new scale in the label history store")
+		err = recreate(current.Services, dm.Services, manifests, store, endpoint)
+	} else {
+		err = rescale(current.Services, dm.Services, manifests, store, endpoint)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return ResultOutput{
+		Message: fmt.Sprintf("Successfully scaled to %d container(s)", countContainers(dm.Services)),
+		Result: DeployResult{
+			DeploymentID: dm.ID,
+			Strategy:     RecreateStrategy,
+			Containers:   containerNames(dm.Services),
+			Duration:     time.Since(start).String(),
+		},
+	}, nil
+}
+
+// rescale removes the instances the services no longer run and creates the
+// ones they gained, leaving the other containers alone. If anything fails the
+// new instances are removed and the removed ones are put back.
+func rescale(previous, next []Service, manifests DeploymentManifests, store HistoryStore, e endpoint.Endpoint) error {
+	wanted := map[string]bool{}
+	for _, name := range containerNames(next) {
+		wanted[name] = true
+	}
+	running := map[string]bool{}
+	for _, name := range containerNames(previous) {
+		running[name] = true
+		if wanted[name] {
+			continue
+		}
+		if _, err := e.InspectContainer(name); err != nil {
+			continue
+		}
+		fmt.Fprintf(Progress, "Removing %s\n", name)
+		if err := e.RemoveContainer(name); err != nil {
+			return restorePrevious(err, nil, manifests, store, e)
+		}
+	}
+
+	historyLabels, err := store.Labels(manifests)
+	if err != nil {
+		return restorePrevious(err, nil, manifests, store, e)
+	}
+
+	var created []string
+	for _, spec := range containerSpecs(next, historyLabels) {
+		if running[spec.Name] {
+			continue
+		}
+		created = append(created, spec.Name)
+		fmt.Fprintf(Progress, "Creating %s\n", spec.Name)
+		if err := e.StartContainer(spec.Name, spec.Config); err != nil {
+			return restorePrevious(err, created, manifests, store, e)
+		}
+	}
+
+	if err := store.Save(manifests); err != nil {
+		return restorePrevious(err, created, manifests, store, e)
+	}
+
+	return nil
+}
+
+// applyScale sets the instance count of each service being deployed. Counts
+// given in spec win, otherwise a service keeps the count it had in the
+// previous deployment.
+func applyScale(services []Service, previous []Service, spec string) error {
+	for i := range services {
+		for _, prev := range previous {
+			if prev.Name == services[i].Name {
+				services[i].Scale = prev.Scale
+			}
+		}
+	}
+
+	if spec == "" {
+		return nil
+	}
+
+	scales, err := parseScale(spec)
+	if err != nil {
+		return err
+	}
+
+	return setScale(services, scales)
+}
+
+func setScale(services []Service, scales map[string]int) error {
+	for key, count := range scales {
+		found := false
+		for i := range services {
+			if serviceMatches(services[i], key) {
+				services[i].Scale = count
+				found = true
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("No such service: %s", key)
+		}
+	}
+
+	return nil
+}
+
+// serviceMatches reports whether key names the service, either by its
+// compose service name or by its container name.
+func serviceMatches(svc Service, key string) bool {
+	if svc.Name == key {
+		return true
+	}
+	_, name, ok := splitContainerName(svc.Name)
+	return ok && name == key
+}
+
+// parseScale reads a comma separated list of service=count pairs.
+func parseScale(spec string) (map[string]int, error) {
+	scales := map[string]int{}
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid scale '%s', expected service=count", pair)
+		}
+
+		count, err := strconv.Atoi(parts[1])
+		if err != nil || count < 1 {
+			return nil, fmt.Errorf("Invalid scale '%s', count must be a number of at least 1", pair)
+		}
+		scales[parts[0]] = count
+	}
+
+	return scales, nil
+}
+
+func formatScale(scales map[string]int) string {
+	var pairs []string
+	for name, count := range scales {
+		pairs = append(pairs, fmt.Sprintf("%s=%d", name, count))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ", ")
+}
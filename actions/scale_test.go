@@ -0,0 +1,198 @@
+package actions
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/CenturyLinkLabs/zodiac/endpoint"
+	"github.com/CenturyLinkLabs/zodiac/proxy"
+	"github.com/samalba/dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScale_Success(t *testing.T) {
+	var startCalls []capturedStartParams
+	var removeCalls []string
+	webConfig := endpoint.ContainerConfig{}
+	webConfig.Labels = map[string]string{containerNumberLabel: "1"}
+
+	previousManis := []DeploymentManifest{
+		{
+			Message: "first",
+			Services: []Service{
+				{Name: "zodiac_web_1", ContainerConfig: webConfig, Scale: 2},
+				{Name: "zodiac_db_1", ContainerConfig: endpoint.ContainerConfig{}},
+			},
+		},
+	}
+	previousManisBlob, _ := json.Marshal(previousManis)
+
+	ci := dockerclient.ContainerInfo{
+		Config: &dockerclient.ContainerConfig{
+			Labels: map[string]string{
+				"zodiacManifest": string(previousManisBlob),
+			},
+		},
+	}
+
+	proxyFactory = func(string, endpoint.Endpoint, bool, endpoint.BuildOptions) proxy.Proxy {
+		return &mockProxy{
+			requests: []proxy.ContainerRequest{
+				{Name: "zodiac_web_1"},
+				{Name: "zodiac_db_1"},
+			},
+		}
+	}
+	DefaultComposer = &mockComposer{}
+
+	e := mockRollbackEndpoint{
+		inspectCallback: func(nm string) (*dockerclient.ContainerInfo, error) {
+			return &ci, nil
+		},
+		startCallback: func(nm string, cfg endpoint.ContainerConfig) error {
+			startCalls = append(startCalls, capturedStartParams{
+				Name:   nm,
+				Config: cfg,
+			})
+			return nil
+		},
+		removeCallback: func(nm string) error {
+			removeCalls = append(removeCalls, nm)
+			return nil
+		},
+	}
+
+	endpointFactory = func(endpoint.EndpointOptions) (endpoint.Endpoint, error) {
+		return e, nil
+	}
+
+	o, err := Scale(Options{Args: []string{"web=3"}})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Successfully scaled to 4 container(s)", o.ToPrettyOutput())
+	assert.Equal(t, []string{"zodiac_web_1", "zodiac_web_2", "zodiac_db_1", "zodiac_web_3"}, removeCalls)
+	assert.Len(t, startCalls, 4)
+	assert.Equal(t, "zodiac_web_1", startCalls[0].Name)
+	assert.Equal(t, "zodiac_web_3", startCalls[2].Name)
+	assert.Equal(t, "3", startCalls[2].Config.Labels[containerNumberLabel])
+	assert.Equal(t, "zodiac_db_1", startCalls[3].Name)
+
+	dms := DeploymentManifests{}
+	err = json.Unmarshal([]byte(startCalls[0].Config.Labels["zodiacManifest"]), &dms)
+	assert.NoError(t, err)
+	assert.Len(t, dms, 2)
+	assert.Equal(t, 3, dms[1].Services[0].Scale)
+	assert.Equal(t, "Scale web=3", dms[1].Message)
+}
+
+func scaleFixture(e *mockEngineEndpoint) (*memoryHistoryStore, func()) {
+	store := &memoryHistoryStore{manifests: DeploymentManifests{{Services: []Service{
+		{Name: "zodiac_web_1", Scale: 2},
+		{Name: "zodiac_db_1"},
+	}}}}
+	for _, nm := range containerNames(store.manifests[0].Services) {
+		e.containers[nm] = endpoint.ContainerConfig{}
+	}
+	return store, useFixture(store, e, &mockComposer{})
+}
+
+func TestScale_OnlyTouchesScaledServices(t *testing.T) {
+	e := newMockEngineEndpoint()
+	store, restore := scaleFixture(e)
+	defer restore()
+
+	_, err := Scale(Options{Args: []string{"web=3"}})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"start zodiac_web_3"}, e.calls)
+	assert.Len(t, store.manifests, 2)
+
+	e.calls = nil
+	_, err = Scale(Options{Args: []string{"web=1"}})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"remove zodiac_web_2", "remove zodiac_web_3"}, e.calls)
+	assert.Len(t, store.manifests, 3)
+}
+
+func TestScale_FailureRestoresInstances(t *testing.T) {
+	e := newMockEngineEndpoint()
+	e.failStart = "zodiac_db_2"
+	store, restore := scaleFixture(e)
+	defer restore()
+
+	_, err := Scale(Options{Args: []string{"web=1", "db=2"}})
+
+	assert.EqualError(t, err, "port is already allocated (the previous deployment was restored)")
+	assert.Equal(t, []string{
+		"remove zodiac_web_2",
+		"start zodiac_db_2",
+		"remove zodiac_db_2",
+		"start zodiac_web_2",
+	}, e.calls)
+	assert.Len(t, e.containers, 3)
+	assert.Len(t, store.manifests, 1)
+}
+
+func TestScale_UnknownService(t *testing.T) {
+	previousManisBlob, _ := json.Marshal([]DeploymentManifest{
+		{Services: []Service{{Name: "zodiac_web_1"}}},
+	})
+	ci := dockerclient.ContainerInfo{
+		Config: &dockerclient.ContainerConfig{
+			Labels: map[string]string{
+				"zodiacManifest": string(previousManisBlob),
+			},
+		},
+	}
+
+	proxyFactory = func(string, endpoint.Endpoint, bool, endpoint.BuildOptions) proxy.Proxy {
+		return &mockProxy{
+			requests: []proxy.ContainerRequest{{Name: "zodiac_web_1"}},
+		}
+	}
+	DefaultComposer = &mockComposer{}
+
+	e := mockRollbackEndpoint{
+		inspectCallback: func(nm string) (*dockerclient.ContainerInfo, error) {
+			return &ci, nil
+		},
+		startCallback: func(nm string, cfg endpoint.ContainerConfig) error {
+			t.Fatal("nothing should be started")
+			return nil
+		},
+	}
+
+	endpointFactory = func(endpoint.EndpointOptions) (endpoint.Endpoint, error) {
+		return e, nil
+	}
+
+	_, err := Scale(Options{Args: []string{"worker=2"}})
+
+	assert.EqualError(t, err, "No such service: worker")
+}
+
+func TestScale_NoArgs(t *testing.T) {
+	_, err := Scale(Options{})
+
+	assert.EqualError(t, err, "Specify the services to scale, e.g. web=3")
+}
+
+func TestParseScale(t *testing.T) {
+	scales, err := parseScale("web=3, worker=2")
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"web": 3, "worker": 2}, scales)
+
+	_, err = parseScale("web")
+	assert.EqualError(t, err, "Invalid scale 'web', expected service=count")
+
+	_, err = parseScale("web=0")
+	assert.EqualError(t, err, "Invalid scale 'web=0', count must be a number of at least 1")
+}
+
+func TestServiceContainerNames(t *testing.T) {
+	assert.Equal(t, []string{"zodiac_web_1"}, Service{Name: "zodiac_web_1"}.ContainerNames())
+	assert.Equal(t, []string{"app_web_1", "app_web_2"}, Service{Name: "app_web_1", Scale: 2}.ContainerNames())
+	assert.Equal(t, []string{"oldService"}, Service{Name: "oldService", Scale: 2}.ContainerNames())
+}
@@ -0,0 +1,244 @@
+package actions
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/CenturyLinkLabs/prettycli"
+	"github.com/CenturyLinkLabs/zodiac/endpoint"
+)
+
+const maskedValue = "********"
+
+// Env vars whose names look like they hold credentials are masked.
+var secretEnvPattern = regexp.MustCompile(`(?i)(PASSWORD|PASSWD|SECRET|TOKEN|API_?KEY|PRIVATE_?KEY|ACCESS_?KEY|CREDENTIAL)`)
+
+type ServiceDetails struct {
+	Name          string
+	OriginalImage string
+	ImageID       string
+	Digest        string `json:",omitempty"`
+	Scale         int
+	Command       []string `json:",omitempty"`
+	Entrypoint    []string `json:",omitempty"`
+	Env           []string `json:",omitempty"`
+	Ports         []string `json:",omitempty"`
+	Volumes       []string `json:",omitempty"`
+	VolumesFrom   []string `json:",omitempty"`
+	Links         []string `json:",omitempty"`
+	RestartPolicy string   `json:",omitempty"`
+}
+
+type ShowResult struct {
+	DeploymentID int
+	Active       bool
+	DeployedAt   string
+	Message      string
+	Services     []ServiceDetails
+	Health       []HealthResult `json:",omitempty"`
+}
+
+func Show(options Options) (prettycli.Output, error) {
+
+	endpoint, err := endpointFactory(options.EndpointOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	reqs, err := collectRequests(options, true)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := historyStore(options, endpoint, reqs)
+	if err != nil {
+		return nil, err
+	}
+
+	manifests, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	if len(manifests) == 0 {
+		return nil, errors.New("There are no deployments to show")
+	}
+
+	id := manifests.id(len(manifests) - 1)
+	if len(options.Args) > 0 {
+		if id, err = strconv.Atoi(options.Args[0]); err != nil {
+			return nil, fmt.Errorf("Invalid deployment ID '%s'", options.Args[0])
+		}
+	}
+	i, err := checkDeployment(manifests, id)
+	if err != nil {
+		return nil, err
+	}
+	dm := manifests[i]
+	reveal := options.Flags["show-secrets"] == "true"
+
+	if options.Flags["config"] == "true" {
+		return createConfigOutput(dm, reveal)
+	}
+
+	result := ShowResult{
+		DeploymentID: id,
+		Active:       i == len(manifests)-1,
+		DeployedAt:   dm.DeployedAt,
+		Message:      dm.Message,
+		Health:       dm.Health,
+	}
+	for _, svc := range dm.Services {
+		result.Services = append(result.Services, serviceDetails(svc, reveal))
+	}
+
+	return ResultOutput{Message: formatShow(result), Result: result}, nil
+}
+
+// createConfigOutput re-emits the stored create config of every service, by
+// container name, as JSON.
+func createConfigOutput(dm DeploymentManifest, reveal bool) (prettycli.Output, error) {
+	configs := map[string]endpoint.ContainerConfig{}
+	for _, svc := range dm.Services {
+		cc := svc.ContainerConfig
+		if !reveal {
+			cc.Env = maskEnv(cc.Env)
+		}
+		configs[svc.Name] = cc
+	}
+
+	j, err := json.MarshalIndent(configs, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return ResultOutput{Message: string(j), Result: configs}, nil
+}
+
+func serviceDetails(svc Service, reveal bool) ServiceDetails {
+	cc := svc.ContainerConfig
+	hc := cc.HostConfig
+
+	d := ServiceDetails{
+		Name:          svc.Name,
+		OriginalImage: svc.OriginalImage,
+		ImageID:       cc.Image,
+		Digest:        svc.Digest(),
+		Scale:         svc.Instances(),
+		Command:       cc.Cmd,
+		Entrypoint:    cc.Entrypoint.Value,
+		Env:           cc.Env,
+		Links:         hc.Links,
+		VolumesFrom:   svc.VolumesFrom,
+	}
+	if !reveal {
+		d.Env = maskEnv(d.Env)
+	}
+	if len(d.VolumesFrom) == 0 {
+		d.VolumesFrom = hc.VolumesFrom
+	}
+
+	for port, bindings := range hc.PortBindings {
+		if len(bindings) == 0 {
+			d.Ports = append(d.Ports, port)
+		}
+		for _, b := range bindings {
+			host := b.HostPort
+			if b.HostIp != "" {
+				host = b.HostIp + ":" + host
+			}
+			d.Ports = append(d.Ports, fmt.Sprintf("%s->%s", host, port))
+		}
+	}
+	sort.Strings(d.Ports)
+
+	d.Volumes = append(d.Volumes, hc.Binds...)
+	var volumes []string
+	for v := range cc.Volumes {
+		volumes = append(volumes, v)
+	}
+	sort.Strings(volumes)
+	d.Volumes = append(d.Volumes, volumes...)
+
+	if p := hc.RestartPolicy; p.Name != "" {
+		d.RestartPolicy = p.Name
+		if p.MaximumRetryCount > 0 {
+			d.RestartPolicy = fmt.Sprintf("%s:%d", p.Name, p.MaximumRetryCount)
+		}
+	}
+
+	return d
+}
+
+func maskEnv(env []string) []string {
+	var masked []string
+	for _, e := range env {
+		parts := strings.SplitN(e, "=", 2)
+		if len(parts) == 2 && secretEnvPattern.MatchString(parts[0]) {
+			e = parts[0] + "=" + maskedValue
+		}
+		masked = append(masked, e)
+	}
+	return masked
+}
+
+func formatShow(r ShowResult) string {
+	title := fmt.Sprintf("Deployment %d", r.DeploymentID)
+	if r.Active {
+		title += " (active)"
+	}
+	lines := []string{
+		title,
+		fmt.Sprintf("Deployed: %s", r.DeployedAt),
+		fmt.Sprintf("Message:  %s", r.Message),
+	}
+
+	for _, d := range r.Services {
+		image := d.OriginalImage
+		if d.ImageID != "" {
+			image = fmt.Sprintf("%s (%s)", d.OriginalImage, d.ImageID)
+		}
+
+		lines = append(lines, "", d.Name)
+		lines = append(lines, detailLines("Image", []string{image})...)
+		lines = append(lines, detailLines("Digest", []string{d.Digest})...)
+		lines = append(lines, detailLines("Containers", []string{strconv.Itoa(d.Scale)})...)
+		lines = append(lines, detailLines("Command", []string{strings.Join(d.Command, " ")})...)
+		lines = append(lines, detailLines("Entrypoint", []string{strings.Join(d.Entrypoint, " ")})...)
+		lines = append(lines, detailLines("Env", d.Env)...)
+		lines = append(lines, detailLines("Ports", d.Ports)...)
+		lines = append(lines, detailLines("Volumes", d.Volumes)...)
+		lines = append(lines, detailLines("Volumes from", d.VolumesFrom)...)
+		lines = append(lines, detailLines("Links", d.Links)...)
+		lines = append(lines, detailLines("Restart policy", []string{d.RestartPolicy})...)
+	}
+
+	if len(r.Health) > 0 {
+		lines = append(lines, "", "Health")
+		for _, h := range r.Health {
+			lines = append(lines, fmt.Sprintf("  %s %s: %s", h.Container, h.Probe, h.status()))
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// detailLines lays out a setting with one value per line. Unset settings are
+// left out.
+func detailLines(label string, values []string) []string {
+	var lines []string
+	for _, v := range values {
+		if v == "" {
+			continue
+		}
+		if len(lines) == 0 {
+			lines = append(lines, fmt.Sprintf("  %-16s%s", label+":", v))
+		} else {
+			lines = append(lines, fmt.Sprintf("  %-16s%s", "", v))
+		}
+	}
+	return lines
+}
@@ -0,0 +1,135 @@
+package actions
+
+import (
+	"testing"
+
+	"github.com/CenturyLinkLabs/zodiac/endpoint"
+	"github.com/samalba/dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func showFixture() (*memoryHistoryStore, func()) {
+	web := Service{Name: "app_web_1", OriginalImage: "nginx:1.9", Scale: 2, VolumesFrom: []string{"app_data_1"}}
+	web.ContainerConfig.Image = "abc123"
+	web.RepoDigests = []string{"nginx@sha256:4a3b2c1d0e9f8a7b6c5d4e3f2a1b0c9d8e7f6a5b4c3d2e1f0a9b8c7d6e5f4a3b"}
+	web.ContainerConfig.Cmd = []string{"nginx", "-g", "daemon off;"}
+	web.ContainerConfig.Entrypoint.Value = []string{"/entrypoint.sh"}
+	web.ContainerConfig.Env = []string{"DEBUG=false", "DB_PASSWORD=hunter2", "GITHUB_TOKEN=xyz"}
+	web.ContainerConfig.Volumes = map[string]struct{}{"/var/log": {}}
+	web.ContainerConfig.HostConfig.Binds = []string{"/srv/www:/usr/share/nginx/html:ro"}
+	web.ContainerConfig.HostConfig.Links = []string{"app_db_1:db"}
+	web.ContainerConfig.HostConfig.RestartPolicy = dockerclient.RestartPolicy{Name: "on-failure", MaximumRetryCount: 3}
+	web.ContainerConfig.HostConfig.PortBindings = map[string][]dockerclient.PortBinding{
+		"80/tcp": {{HostPort: "8080"}},
+	}
+
+	store := &memoryHistoryStore{manifests: DeploymentManifests{
+		{Services: []Service{web}, DeployedAt: "yesterday", Message: "a message much longer than the seventy two characters that list has room for"},
+		{Services: []Service{{Name: "app_web_1"}}},
+	}}
+	return store, useFixture(store, &mockEndpoint{}, &mockComposer{})
+}
+
+func TestShow(t *testing.T) {
+	_, restore := showFixture()
+	defer restore()
+
+	o, err := Show(Options{Args: []string{"1"}})
+
+	assert.NoError(t, err)
+	result := o.(ResultOutput).Result.(ShowResult)
+	assert.Equal(t, 1, result.DeploymentID)
+	assert.False(t, result.Active)
+	assert.Equal(t, ServiceDetails{
+		Name:          "app_web_1",
+		OriginalImage: "nginx:1.9",
+		ImageID:       "abc123",
+		Digest:        "nginx@sha256:4a3b2c1d0e9f8a7b6c5d4e3f2a1b0c9d8e7f6a5b4c3d2e1f0a9b8c7d6e5f4a3b",
+		Scale:         2,
+		Command:       []string{"nginx", "-g", "daemon off;"},
+		Entrypoint:    []string{"/entrypoint.sh"},
+		Env:           []string{"DEBUG=false", "DB_PASSWORD=********", "GITHUB_TOKEN=********"},
+		Ports:         []string{"8080->80/tcp"},
+		Volumes:       []string{"/srv/www:/usr/share/nginx/html:ro", "/var/log"},
+		VolumesFrom:   []string{"app_data_1"},
+		Links:         []string{"app_db_1:db"},
+		RestartPolicy: "on-failure:3",
+	}, result.Services[0])
+	assert.Equal(t, `Deployment 1
+Deployed: yesterday
+Message:  a message much longer than the seventy two characters that list has room for
+
+app_web_1
+  Image:          nginx:1.9 (abc123)
+  Digest:         nginx@sha256:4a3b2c1d0e9f8a7b6c5d4e3f2a1b0c9d8e7f6a5b4c3d2e1f0a9b8c7d6e5f4a3b
+  Containers:     2
+  Command:        nginx -g daemon off;
+  Entrypoint:     /entrypoint.sh
+  Env:            DEBUG=false
+                  DB_PASSWORD=********
+                  GITHUB_TOKEN=********
+  Ports:          8080->80/tcp
+  Volumes:        /srv/www:/usr/share/nginx/html:ro
+                  /var/log
+  Volumes from:   app_data_1
+  Links:          app_db_1:db
+  Restart policy: on-failure:3`, o.ToPrettyOutput())
+}
+
+func TestShow_ActiveByDefault(t *testing.T) {
+	_, restore := showFixture()
+	defer restore()
+
+	o, err := Show(Options{})
+
+	assert.NoError(t, err)
+	result := o.(ResultOutput).Result.(ShowResult)
+	assert.Equal(t, 2, result.DeploymentID)
+	assert.True(t, result.Active)
+}
+
+func TestShow_Health(t *testing.T) {
+	store, restore := showFixture()
+	defer restore()
+	store.manifests[1].Health = []HealthResult{
+		{Container: "app_web_1", Probe: "tcp 80", Healthy: true},
+		{Container: "app_web_1", Probe: "exec false", Message: "exited with code 1"},
+		{Container: "app_web_1", Probe: "running 5s", Pending: true},
+	}
+
+	o, err := Show(Options{})
+
+	assert.NoError(t, err)
+	assert.Contains(t, o.ToPrettyOutput(), `
+Health
+  app_web_1 tcp 80: ok
+  app_web_1 exec false: failed: exited with code 1
+  app_web_1 running 5s: not recorded`)
+}
+
+func TestShow_Config(t *testing.T) {
+	store, restore := showFixture()
+	defer restore()
+
+	o, err := Show(Options{Args: []string{"1"}, Flags: map[string]string{"config": "true"}})
+
+	assert.NoError(t, err)
+	configs := o.(ResultOutput).Result.(map[string]endpoint.ContainerConfig)
+	assert.Equal(t, []string{"DEBUG=false", "DB_PASSWORD=********", "GITHUB_TOKEN=********"}, configs["app_web_1"].Env)
+	assert.Contains(t, o.ToPrettyOutput(), `"Image": "abc123"`)
+	assert.Equal(t, "DB_PASSWORD=hunter2", store.manifests[0].Services[0].ContainerConfig.Env[1])
+
+	o, err = Show(Options{Args: []string{"1"}, Flags: map[string]string{"config": "true", "show-secrets": "true"}})
+
+	assert.NoError(t, err)
+	assert.Contains(t, o.ToPrettyOutput(), "DB_PASSWORD=hunter2")
+}
+
+func TestShow_MissingDeployment(t *testing.T) {
+	_, restore := showFixture()
+	defer restore()
+
+	_, err := Show(Options{Args: []string{"3"}})
+
+	assert.EqualError(t, err, "Deployment 3 does not exist")
+}
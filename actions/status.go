@@ -0,0 +1,252 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CenturyLinkLabs/prettycli"
+	"github.com/CenturyLinkLabs/zodiac/endpoint"
+	"github.com/samalba/dockerclient"
+)
+
+const (
+	StateRunning    = "running"
+	StateRestarting = "restarting"
+	StatePaused     = "paused"
+	StateExited     = "exited"
+	StateMissing    = "missing"
+)
+
+// A ContainerStatus is the live state of one container of the active
+// deployment. Drift lists the ways it doesn't match the deployment.
+type ContainerStatus struct {
+	Service      string
+	Container    string
+	State        string
+	Uptime       string   `json:",omitempty"`
+	RestartCount int      `json:",omitempty"`
+	ExitCode     int      `json:",omitempty"`
+	Ports        []string `json:",omitempty"`
+	ImageID      string   `json:",omitempty"`
+	ImageMatches bool
+	Node         string   `json:",omitempty"`
+	Drift        []string `json:",omitempty"`
+}
+
+type StatusResult struct {
+	DeploymentID int
+	Containers   []ContainerStatus
+}
+
+// StatusOutput lists the containers, followed by a line saying whether any
+// of them have drifted from the deployment.
+type StatusOutput struct {
+	prettycli.ListOutput
+	Result StatusResult
+}
+
+func (o StatusOutput) ToPrettyOutput() string {
+	drifted := 0
+	for _, c := range o.Result.Containers {
+		if len(c.Drift) > 0 {
+			drifted++
+		}
+	}
+
+	summary := fmt.Sprintf("All %d container(s) match deployment %d", len(o.Result.Containers), o.Result.DeploymentID)
+	if drifted > 0 {
+		summary = fmt.Sprintf("%d of %d container(s) have drifted from deployment %d", drifted, len(o.Result.Containers), o.Result.DeploymentID)
+	}
+
+	return o.ListOutput.ToPrettyOutput() + "\n" + summary
+}
+
+func (o StatusOutput) Data() interface{} {
+	return o.Result
+}
+
+func Status(options Options) (prettycli.Output, error) {
+
+	endpoint, err := endpointFactory(options.EndpointOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	reqs, err := collectRequests(options, true)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := historyStore(options, endpoint, reqs)
+	if err != nil {
+		return nil, err
+	}
+
+	manifests, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	if len(manifests) == 0 {
+		return nil, errors.New("There are no deployments to check")
+	}
+
+	output := StatusOutput{
+		ListOutput: prettycli.ListOutput{
+			Labels: []string{"Container", "State", "Uptime", "Restarts", "Ports", "Image", "Node", "Drift"},
+		},
+		Result: StatusResult{DeploymentID: manifests.id(len(manifests) - 1)},
+	}
+
+	for _, svc := range manifests[len(manifests)-1].Services {
+		for _, name := range svc.ContainerNames() {
+			status, err := containerStatus(svc, name, endpoint)
+			if err != nil {
+				return nil, err
+			}
+			output.Result.Containers = append(output.Result.Containers, status)
+
+			state := status.State
+			if status.State == StateExited {
+				state = fmt.Sprintf("exited (%d)", status.ExitCode)
+			}
+			image := "ok"
+			if !status.ImageMatches {
+				image = "differs"
+			}
+			if status.State == StateMissing {
+				image = ""
+			}
+
+			output.AddRow(map[string]string{
+				"Container": status.Container,
+				"State":     state,
+				"Uptime":    status.Uptime,
+				"Restarts":  strconv.Itoa(status.RestartCount),
+				"Ports":     strings.Join(status.Ports, ", "),
+				"Image":     image,
+				"Node":      status.Node,
+				"Drift":     strings.Join(status.Drift, ", "),
+			})
+		}
+	}
+
+	return output, nil
+}
+
+func containerStatus(svc Service, name string, e endpoint.Endpoint) (ContainerStatus, error) {
+	status := ContainerStatus{Service: svc.Name, Container: name}
+
+	details, err := e.InspectContainerDetails(name)
+	if err == dockerclient.ErrNotFound {
+		status.State = StateMissing
+		status.Drift = []string{"missing"}
+		return status, nil
+	}
+	if err != nil {
+		return status, err
+	}
+
+	status.RestartCount = details.RestartCount
+	status.ImageID = details.Image
+	status.ImageMatches = sameImage(details.Image, svc.ContainerConfig.Image)
+	if details.Node != nil {
+		status.Node = details.Node.Name
+	}
+
+	for port, bindings := range details.NetworkSettings.Ports {
+		for _, b := range bindings {
+			status.Ports = append(status.Ports, fmt.Sprintf("%s:%s->%s", b.HostIp, b.HostPort, port))
+		}
+	}
+	sort.Strings(status.Ports)
+
+	state := details.State
+	if state == nil {
+		state = &dockerclient.State{}
+	}
+	switch {
+	case state.Restarting:
+		status.State = StateRestarting
+		status.Drift = append(status.Drift, "restarting")
+	case state.Paused:
+		status.State = StatePaused
+		status.Drift = append(status.Drift, "paused")
+	case state.Running:
+		status.State = StateRunning
+		if !state.StartedAt.IsZero() {
+			status.Uptime = (time.Since(state.StartedAt) / time.Second * time.Second).String()
+		}
+	default:
+		status.State = StateExited
+		status.ExitCode = state.ExitCode
+		status.Drift = append(status.Drift, "stopped")
+	}
+
+	if !status.ImageMatches {
+		status.Drift = append(status.Drift, "image differs")
+	}
+	if fields := configDrift(instanceConfig(svc, name), details.Config); len(fields) > 0 {
+		status.Drift = append(status.Drift, fmt.Sprintf("config differs (%s)", strings.Join(fields, ", ")))
+	}
+
+	return status, nil
+}
+
+// instanceConfig is the config the named container of the service was
+// created with, which numbers the instances of a scaled service.
+func instanceConfig(svc Service, name string) endpoint.ContainerConfig {
+	for _, spec := range containerSpecs([]Service{svc}, nil) {
+		if spec.Name == name {
+			return spec.Config
+		}
+	}
+	return svc.ContainerConfig
+}
+
+// configDrift names the deployed settings that the live container doesn't
+// have. The live config also holds the image's defaults, so only the
+// deployed settings are looked for.
+func configDrift(cc endpoint.ContainerConfig, live *dockerclient.ContainerConfig) []string {
+	if live == nil {
+		return nil
+	}
+
+	var fields []string
+	env := map[string]bool{}
+	for _, e := range live.Env {
+		env[e] = true
+	}
+	for _, e := range cc.Env {
+		if !env[e] {
+			fields = append(fields, "Env")
+			break
+		}
+	}
+
+	if len(cc.Cmd) > 0 && !reflect.DeepEqual(cc.Cmd, live.Cmd) {
+		fields = append(fields, "Cmd")
+	}
+
+	for k, v := range cc.Labels {
+		if live.Labels[k] != v {
+			fields = append(fields, "Labels")
+			break
+		}
+	}
+
+	return fields
+}
+
+// sameImage compares image IDs, allowing for either of them being
+// abbreviated.
+func sameImage(a, b string) bool {
+	if a == "" || b == "" {
+		return a == b
+	}
+	return strings.HasPrefix(a, b) || strings.HasPrefix(b, a)
+}
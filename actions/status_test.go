@@ -0,0 +1,114 @@
+package actions
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/CenturyLinkLabs/zodiac/endpoint"
+	"github.com/samalba/dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockStatusEndpoint struct {
+	mockEndpoint
+	containers map[string]*endpoint.ContainerDetails
+}
+
+func (e mockStatusEndpoint) InspectContainerDetails(name string) (*endpoint.ContainerDetails, error) {
+	if d, ok := e.containers[name]; ok {
+		return d, nil
+	}
+	return nil, dockerclient.ErrNotFound
+}
+
+func runningDetails(image string) *endpoint.ContainerDetails {
+	d := &endpoint.ContainerDetails{RestartCount: 1, Node: &endpoint.SwarmNode{Name: "node-1"}}
+	d.Image = image
+	d.State = &dockerclient.State{Running: true, StartedAt: time.Now().Add(-time.Minute)}
+	d.NetworkSettings.Ports = map[string][]dockerclient.PortBinding{
+		"80/tcp": {{HostIp: "0.0.0.0", HostPort: "8080"}},
+	}
+	return d
+}
+
+func TestStatus(t *testing.T) {
+	web := Service{Name: "app_web_1", Scale: 3}
+	web.ContainerConfig.Image = "abc123"
+	store := &memoryHistoryStore{manifests: DeploymentManifests{{}, {Services: []Service{web}}}}
+
+	stopped := runningDetails("abc123")
+	stopped.State = &dockerclient.State{ExitCode: 137}
+	e := mockStatusEndpoint{containers: map[string]*endpoint.ContainerDetails{
+		"app_web_1": runningDetails("abc123def456"),
+		"app_web_2": stopped,
+	}}
+	defer useFixture(store, e, &mockComposer{})()
+
+	o, err := Status(Options{})
+
+	assert.NoError(t, err)
+	result := o.(StatusOutput).Result
+	assert.Equal(t, 2, result.DeploymentID)
+	assert.Len(t, result.Containers, 3)
+
+	running := result.Containers[0]
+	assert.Equal(t, StateRunning, running.State)
+	assert.Equal(t, "1m0s", running.Uptime)
+	assert.Equal(t, 1, running.RestartCount)
+	assert.Equal(t, []string{"0.0.0.0:8080->80/tcp"}, running.Ports)
+	assert.True(t, running.ImageMatches)
+	assert.Equal(t, "node-1", running.Node)
+	assert.Empty(t, running.Drift)
+
+	assert.Equal(t, StateExited, result.Containers[1].State)
+	assert.Equal(t, 137, result.Containers[1].ExitCode)
+	assert.Equal(t, []string{"stopped"}, result.Containers[1].Drift)
+
+	assert.Equal(t, StateMissing, result.Containers[2].State)
+	assert.Equal(t, []string{"missing"}, result.Containers[2].Drift)
+
+	assert.Contains(t, o.ToPrettyOutput(), "2 of 3 container(s) have drifted from deployment 2")
+}
+
+func TestContainerStatus_ImageDiffers(t *testing.T) {
+	svc := Service{Name: "app_web_1"}
+	svc.ContainerConfig.Image = "abc123"
+	e := mockStatusEndpoint{containers: map[string]*endpoint.ContainerDetails{
+		"app_web_1": runningDetails("def456"),
+	}}
+
+	status, err := containerStatus(svc, "app_web_1", e)
+
+	assert.NoError(t, err)
+	assert.False(t, status.ImageMatches)
+	assert.Equal(t, []string{"image differs"}, status.Drift)
+}
+
+func TestContainerStatus_ScaledInstanceLabels(t *testing.T) {
+	svc := Service{Name: "app_web_1", Scale: 2}
+	svc.ContainerConfig.Image = "abc123"
+	svc.ContainerConfig.Labels = map[string]string{containerNumberLabel: "1", "tier": "web"}
+	e := mockStatusEndpoint{containers: map[string]*endpoint.ContainerDetails{}}
+	for i, name := range svc.ContainerNames() {
+		details := runningDetails("abc123")
+		details.Config = &dockerclient.ContainerConfig{Labels: map[string]string{
+			containerNumberLabel: strconv.Itoa(i + 1),
+			"tier":               "web",
+		}}
+		e.containers[name] = details
+	}
+
+	for _, name := range svc.ContainerNames() {
+		status, err := containerStatus(svc, name, e)
+
+		assert.NoError(t, err)
+		assert.Empty(t, status.Drift, name)
+	}
+
+	e.containers["app_web_2"].Config.Labels["tier"] = "db"
+	status, err := containerStatus(svc, "app_web_2", e)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"config differs (Labels)"}, status.Drift)
+}
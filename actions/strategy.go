@@ -0,0 +1,420 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CenturyLinkLabs/zodiac/endpoint"
+)
+
+const (
+	RecreateStrategy  = "recreate"
+	BlueGreenStrategy = "blue-green"
+	RollingStrategy   = "rolling"
+
+	nextSuffix     = "_next"
+	previousSuffix = "_previous"
+)
+
+var (
+	startTimeout = 30 * time.Second
+	pollInterval = time.Second
+)
+
+// A deployStrategy replaces the containers of the previous deployment with
+// those of the next one and records manifests as the history.
+type deployStrategy func(previous, next []Service, manifests DeploymentManifests, store HistoryStore, e endpoint.Endpoint) error
+
+func strategyFor(flags map[string]string) (deployStrategy, error) {
+	switch name := flags["strategy"]; name {
+	case "", RecreateStrategy:
+		return recreate, nil
+	case BlueGreenStrategy:
+		return blueGreen, nil
+	case RollingStrategy:
+		batchSize := 1
+		if flags["batch-size"] != "" {
+			var err error
+			batchSize, err = strconv.Atoi(flags["batch-size"])
+			if err != nil || batchSize < 1 {
+				return nil, fmt.Errorf("Invalid batch size '%s', must be a number of at least 1", flags["batch-size"])
+			}
+		}
+
+		var pause time.Duration
+		if flags["pause"] != "" {
+			var err error
+			pause, err = time.ParseDuration(flags["pause"])
+			if err != nil || pause < 0 {
+				return nil, fmt.Errorf("Invalid pause '%s', expected a duration like 10s", flags["pause"])
+			}
+		}
+
+		return rolling(batchSize, pause), nil
+	default:
+		return nil, fmt.Errorf("Unknown deploy strategy '%s', use one of: %s, %s, %s", name, RecreateStrategy, BlueGreenStrategy, RollingStrategy)
+	}
+}
+
+// recreate removes the old containers before starting the new ones, so the
+// services are down in between. If any of the new containers can't be
+// created the ones that were are removed and the previous deployment is put
+// back.
+func recreate(previous, next []Service, manifests DeploymentManifests, store HistoryStore, e endpoint.Endpoint) error {
+	if err := removeServices(append(previous, next...), e); err != nil {
+		return restorePrevious(err, nil, manifests, store, e)
+	}
+
+	historyLabels, err := store.Labels(manifests)
+	if err != nil {
+		return restorePrevious(err, nil, manifests, store, e)
+	}
+
+	var created []string
+	for _, spec := range containerSpecs(next, historyLabels) {
+		created = append(created, spec.Name)
+		fmt.Fprintf(Progress, "Creating %s\n", spec.Name)
+		if err := e.StartContainer(spec.Name, spec.Config); err != nil {
+			return restorePrevious(err, created, manifests, store, e)
+		}
+	}
+
+	if err := store.Save(manifests); err != nil {
+		return restorePrevious(err, created, manifests, store, e)
+	}
+
+	return nil
+}
+
+// blueGreen starts the new containers alongside the old ones under temporary
+// names and waits for them to be running. Only then are the old containers
+// renamed out of the way, the new ones renamed into place and the old ones
+// stopped and removed. The old containers are stopped after the switch rather
+// than before it: the endpoint can't start a stopped container again, so
+// stopping them first would leave nothing running if a rename failed. If
+// anything fails before the history is saved the old containers are left
+// running.
+func blueGreen(previous, next []Service, manifests DeploymentManifests, store HistoryStore, e endpoint.Endpoint) error {
+	historyLabels, err := store.Labels(manifests)
+	if err != nil {
+		return err
+	}
+
+	specs := containerSpecs(next, historyLabels)
+	if err := checkFixedPorts(specs, e); err != nil {
+		return err
+	}
+
+	temporary := map[string]string{}
+	for _, spec := range specs {
+		temporary[spec.Name] = spec.Name + nextSuffix
+	}
+
+	var started []string
+	abort := func(err error) error {
+		for _, name := range started {
+			e.RemoveContainer(name)
+		}
+		return err
+	}
+
+	for _, spec := range specs {
+		name := temporary[spec.Name]
+		removeStale(name, e)
+
+		started = append(started, name)
+		fmt.Fprintf(Progress, "Creating %s\n", name)
+		if err := e.StartContainer(name, retarget(spec.Config, temporary)); err != nil {
+			return abort(err)
+		}
+	}
+
+	for _, name := range started {
+		if err := waitForRunning(name, e); err != nil {
+			return abort(err)
+		}
+	}
+
+	type swap struct {
+		name   string
+		hadOld bool
+	}
+	var swapped []swap
+	undo := func(err error) error {
+		for i := len(swapped) - 1; i >= 0; i-- {
+			e.RenameContainer(swapped[i].name, temporary[swapped[i].name])
+			if swapped[i].hadOld {
+				e.RenameContainer(swapped[i].name+previousSuffix, swapped[i].name)
+			}
+		}
+		return abort(err)
+	}
+
+	for _, spec := range specs {
+		s := swap{name: spec.Name}
+		if _, err := e.InspectContainer(spec.Name); err == nil {
+			removeStale(spec.Name+previousSuffix, e)
+			if err := e.RenameContainer(spec.Name, spec.Name+previousSuffix); err != nil {
+				return undo(err)
+			}
+			s.hadOld = true
+		}
+		if err := e.RenameContainer(temporary[spec.Name], spec.Name); err != nil {
+			if s.hadOld {
+				e.RenameContainer(spec.Name+previousSuffix, spec.Name)
+			}
+			return undo(err)
+		}
+		swapped = append(swapped, s)
+	}
+
+	if err := store.Save(manifests); err != nil {
+		return undo(err)
+	}
+
+	var old []string
+	for _, s := range swapped {
+		if s.hadOld {
+			old = append(old, s.name+previousSuffix)
+		}
+	}
+	for _, svc := range previous {
+		for _, name := range svc.ContainerNames() {
+			if _, ok := temporary[name]; !ok {
+				old = append(old, name)
+			}
+		}
+	}
+
+	for _, name := range old {
+		if _, err := e.InspectContainer(name); err != nil {
+			continue
+		}
+		fmt.Fprintf(Progress, "Removing %s\n", strings.TrimSuffix(name, previousSuffix))
+		e.StopContainer(name)
+		if err := e.RemoveContainer(name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkFixedPorts refuses containers that publish a fixed host port while
+// their live container is still running, as the new one couldn't bind the
+// port next to it.
+func checkFixedPorts(specs []containerSpec, e endpoint.Endpoint) error {
+	for _, spec := range specs {
+		var ports []string
+		for _, bindings := range spec.Config.HostConfig.PortBindings {
+			for _, b := range bindings {
+				if b.HostPort != "" {
+					ports = append(ports, b.HostPort)
+				}
+			}
+		}
+		if len(ports) == 0 {
+			continue
+		}
+		if _, err := e.InspectContainer(spec.Name); err != nil {
+			continue
+		}
+
+		sort.Strings(ports)
+		return fmt.Errorf("%s publishes fixed host port %s, so it can't run next to the live container; deploy it with --strategy=%s or %s", spec.Name, strings.Join(ports, ", "), RecreateStrategy, RollingStrategy)
+	}
+	return nil
+}
+
+// rolling replaces the containers batchSize at a time, waiting for each batch
+// to be running and then for pause before starting on the next. If a batch
+// fails the new containers are removed and the previous deployment is put
+// back.
+func rolling(batchSize int, pause time.Duration) deployStrategy {
+	return func(previous, next []Service, manifests DeploymentManifests, store HistoryStore, e endpoint.Endpoint) error {
+		historyLabels, err := store.Labels(manifests)
+		if err != nil {
+			return err
+		}
+
+		specs := containerSpecs(next, historyLabels)
+		replaced := map[string]bool{}
+		var created []string
+
+		for start := 0; start < len(specs); start += batchSize {
+			end := start + batchSize
+			if end > len(specs) {
+				end = len(specs)
+			}
+			batch := specs[start:end]
+
+			if start > 0 && pause > 0 {
+				fmt.Fprintf(Progress, "Waiting %s before the next batch...\n", pause)
+				time.Sleep(pause)
+			}
+
+			for _, spec := range batch {
+				replaced[spec.Name] = true
+				if _, err := e.InspectContainer(spec.Name); err == nil {
+					if err := e.RemoveContainer(spec.Name); err != nil {
+						return restorePrevious(err, created, manifests, store, e)
+					}
+				}
+
+				// A container that fails to start may still have been
+				// created, so it is cleaned up either way.
+				created = append(created, spec.Name)
+				fmt.Fprintf(Progress, "Creating %s\n", spec.Name)
+				if err := e.StartContainer(spec.Name, spec.Config); err != nil {
+					return restorePrevious(err, created, manifests, store, e)
+				}
+			}
+
+			for _, spec := range batch {
+				if err := waitForRunning(spec.Name, e); err != nil {
+					return restorePrevious(err, created, manifests, store, e)
+				}
+			}
+		}
+
+		for _, svc := range previous {
+			for _, name := range svc.ContainerNames() {
+				if replaced[name] {
+					continue
+				}
+				if _, err := e.InspectContainer(name); err != nil {
+					continue
+				}
+				if err := e.RemoveContainer(name); err != nil {
+					return err
+				}
+			}
+		}
+
+		return store.Save(manifests)
+	}
+}
+
+// retarget points the links, volumes_from and container networking of a
+// container at the temporary names of the containers deployed with it, so the
+// new containers don't end up attached to the old ones.
+func retarget(cc endpoint.ContainerConfig, names map[string]string) endpoint.ContainerConfig {
+	rename := func(ref string) string {
+		parts := strings.SplitN(ref, ":", 2)
+		if name, ok := names[parts[0]]; ok {
+			parts[0] = name
+		}
+		return strings.Join(parts, ":")
+	}
+
+	links := make([]string, len(cc.HostConfig.Links))
+	for i, link := range cc.HostConfig.Links {
+		links[i] = rename(link)
+	}
+	cc.HostConfig.Links = links
+
+	volumesFrom := make([]string, len(cc.HostConfig.VolumesFrom))
+	for i, ref := range cc.HostConfig.VolumesFrom {
+		volumesFrom[i] = rename(ref)
+	}
+	cc.HostConfig.VolumesFrom = volumesFrom
+
+	if strings.HasPrefix(cc.HostConfig.NetworkMode, "container:") {
+		target := strings.TrimPrefix(cc.HostConfig.NetworkMode, "container:")
+		if name, ok := names[target]; ok {
+			cc.HostConfig.NetworkMode = "container:" + name
+		}
+	}
+
+	return cc
+}
+
+// removeStale removes a container left behind by an earlier deploy that
+// didn't finish.
+func removeStale(name string, e endpoint.Endpoint) {
+	if _, err := e.InspectContainer(name); err == nil {
+		e.RemoveContainer(name)
+	}
+}
+
+// waitForRunning waits for a newly started container to be running, failing
+// if it exits or takes longer than startTimeout.
+func waitForRunning(name string, e endpoint.Endpoint) error {
+	deadline := time.Now().Add(startTimeout)
+	for {
+		ci, err := e.InspectContainer(name)
+		if err != nil {
+			return err
+		}
+
+		if ci.State != nil && ci.State.Running {
+			return nil
+		}
+		if ci.State != nil && !ci.State.FinishedAt.IsZero() {
+			return fmt.Errorf("%s exited with code %d", name, ci.State.ExitCode)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s wasn't running after %s", name, startTimeout)
+		}
+
+		time.Sleep(pollInterval)
+	}
+}
+
+// A FailedDeployError is returned when a deploy failed partway through and
+// zodiac tried to put the previous deployment back.
+type FailedDeployError struct {
+	Err        error
+	Restored   bool
+	RestoreErr error
+}
+
+func (e FailedDeployError) Error() string {
+	switch {
+	case e.RestoreErr != nil:
+		return fmt.Sprintf("%s (restoring the previous deployment also failed: %s)", e.Err, e.RestoreErr)
+	case e.Restored:
+		return fmt.Sprintf("%s (the previous deployment was restored)", e.Err)
+	}
+	return fmt.Sprintf("%s (the new containers were removed, there was no previous deployment to restore)", e.Err)
+}
+
+// restorePrevious removes the containers created by a failed deploy and
+// recreates any containers of the previous deployment that are missing. The
+// last of manifests is the failed deployment.
+func restorePrevious(cause error, created []string, manifests DeploymentManifests, store HistoryStore, e endpoint.Endpoint) error {
+	fmt.Fprintf(Progress, "Deploy failed, removing the new containers...\n")
+	for _, name := range created {
+		e.RemoveContainer(name)
+	}
+
+	if len(manifests) < 2 {
+		return FailedDeployError{Err: cause}
+	}
+
+	history := manifests[:len(manifests)-1]
+	if history[len(history)-1].Pruned {
+		return FailedDeployError{Err: cause, RestoreErr: errors.New("it was pruned from the history")}
+	}
+	historyLabels, err := store.Labels(history)
+	if err != nil {
+		return FailedDeployError{Err: cause, RestoreErr: err}
+	}
+
+	for _, spec := range containerSpecs(history[len(history)-1].Services, historyLabels) {
+		if _, err := e.InspectContainer(spec.Name); err == nil {
+			continue
+		}
+
+		fmt.Fprintf(Progress, "Restoring %s\n", spec.Name)
+		if err := e.StartContainer(spec.Name, spec.Config); err != nil {
+			return FailedDeployError{Err: cause, RestoreErr: err}
+		}
+	}
+
+	return FailedDeployError{Err: cause, Restored: true}
+}
@@ -0,0 +1,346 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/CenturyLinkLabs/zodiac/endpoint"
+	"github.com/CenturyLinkLabs/zodiac/proxy"
+	"github.com/samalba/dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	startTimeout = 50 * time.Millisecond
+	pollInterval = time.Millisecond
+}
+
+// mockEngineEndpoint keeps track of the containers it runs, by name.
+type mockEngineEndpoint struct {
+	mockEndpoint
+	containers map[string]endpoint.ContainerConfig
+	stopped    map[string]bool
+	calls      []string
+	failStart  string
+	exited     string
+}
+
+func newMockEngineEndpoint() *mockEngineEndpoint {
+	return &mockEngineEndpoint{
+		containers: map[string]endpoint.ContainerConfig{},
+		stopped:    map[string]bool{},
+	}
+}
+
+func (e *mockEngineEndpoint) InspectContainer(nm string) (*dockerclient.ContainerInfo, error) {
+	cc, ok := e.containers[nm]
+	if !ok {
+		return nil, dockerclient.ErrNotFound
+	}
+
+	state := &dockerclient.State{Running: !e.stopped[nm]}
+	if nm == e.exited {
+		state = &dockerclient.State{ExitCode: 1, FinishedAt: time.Now()}
+	}
+	return &dockerclient.ContainerInfo{
+		Config: &dockerclient.ContainerConfig{Image: cc.Image, Labels: cc.Labels, Env: cc.Env, Cmd: cc.Cmd},
+		State:  state,
+	}, nil
+}
+
+func (e *mockEngineEndpoint) InspectContainerDetails(nm string) (*endpoint.ContainerDetails, error) {
+	ci, err := e.InspectContainer(nm)
+	if err != nil {
+		return nil, err
+	}
+	ci.Image = ci.Config.Image
+	return &endpoint.ContainerDetails{ContainerInfo: *ci}, nil
+}
+
+func (e *mockEngineEndpoint) ListContainers() ([]dockerclient.Container, error) {
+	var containers []dockerclient.Container
+	for nm := range e.containers {
+		containers = append(containers, dockerclient.Container{Names: []string{"/" + nm}})
+	}
+	return containers, nil
+}
+
+func (e *mockEngineEndpoint) StartContainer(nm string, cc endpoint.ContainerConfig) error {
+	e.calls = append(e.calls, "start "+nm)
+	if nm == e.failStart {
+		e.failStart = ""
+		return errors.New("port is already allocated")
+	}
+	e.containers[nm] = cc
+	return nil
+}
+
+func (e *mockEngineEndpoint) CreateContainer(nm string, cc endpoint.ContainerConfig) error {
+	e.calls = append(e.calls, "create "+nm)
+	if _, ok := e.containers[nm]; ok {
+		return endpoint.ErrNameInUse
+	}
+	e.containers[nm] = cc
+	return nil
+}
+
+func (e *mockEngineEndpoint) RemoveContainer(nm string) error {
+	e.calls = append(e.calls, "remove "+nm)
+	delete(e.containers, nm)
+	return nil
+}
+
+func (e *mockEngineEndpoint) StopContainer(nm string) error {
+	e.calls = append(e.calls, "stop "+nm)
+	e.stopped[nm] = true
+	return nil
+}
+
+func (e *mockEngineEndpoint) RenameContainer(oldName, newName string) error {
+	e.calls = append(e.calls, fmt.Sprintf("rename %s %s", oldName, newName))
+	if _, ok := e.containers[newName]; ok {
+		return fmt.Errorf("Conflict, %s is already in use", newName)
+	}
+	e.containers[newName] = e.containers[oldName]
+	delete(e.containers, oldName)
+	return nil
+}
+
+func blueGreenFixture(e *mockEngineEndpoint) (*memoryHistoryStore, func()) {
+	var webConfig endpoint.ContainerConfig
+	webConfig.Image = "web:1"
+	webConfig.HostConfig.Links = []string{"app_db_1:db"}
+
+	e.containers["app_web_1"] = webConfig
+	e.containers["app_db_1"] = endpoint.ContainerConfig{}
+	e.containers["app_worker_1"] = endpoint.ContainerConfig{}
+
+	store := &memoryHistoryStore{manifests: DeploymentManifests{{
+		Services: []Service{{Name: "app_web_1"}, {Name: "app_db_1"}, {Name: "app_worker_1"}},
+	}}}
+	restore := useFixture(store, e, &mockRequestComposer{
+		requests: []proxy.ContainerRequest{
+			{Name: "app_db_1", CreateOptions: []byte(`{"Image": "db"}`)},
+			{Name: "app_web_1", CreateOptions: []byte(`{"Image": "web:2", "HostConfig": {"Links": ["app_db_1:db"]}}`)},
+		},
+	})
+
+	return store, restore
+}
+
+func TestDeploy_BlueGreen(t *testing.T) {
+	e := newMockEngineEndpoint()
+	store, restore := blueGreenFixture(e)
+	defer restore()
+
+	_, err := Deploy(Options{Flags: map[string]string{"strategy": "blue-green"}})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"start app_db_1_next",
+		"start app_web_1_next",
+		"rename app_db_1 app_db_1_previous",
+		"rename app_db_1_next app_db_1",
+		"rename app_web_1 app_web_1_previous",
+		"rename app_web_1_next app_web_1",
+		"stop app_db_1_previous",
+		"remove app_db_1_previous",
+		"stop app_web_1_previous",
+		"remove app_web_1_previous",
+		"stop app_worker_1",
+		"remove app_worker_1",
+	}, e.calls)
+	assert.Len(t, e.containers, 2)
+	assert.Equal(t, "abc123", e.containers["app_web_1"].Image)
+	assert.Equal(t, []string{"app_db_1_next:db"}, e.containers["app_web_1"].HostConfig.Links)
+	assert.Len(t, store.manifests, 2)
+}
+
+func TestDeploy_BlueGreenStartFailure(t *testing.T) {
+	e := newMockEngineEndpoint()
+	e.failStart = "app_web_1_next"
+	store, restore := blueGreenFixture(e)
+	defer restore()
+
+	_, err := Deploy(Options{Flags: map[string]string{"strategy": "blue-green"}})
+
+	assert.EqualError(t, err, "port is already allocated")
+	assert.Equal(t, []string{
+		"start app_db_1_next",
+		"start app_web_1_next",
+		"remove app_db_1_next",
+		"remove app_web_1_next",
+	}, e.calls)
+	assert.Equal(t, "web:1", e.containers["app_web_1"].Image)
+	assert.Len(t, e.containers, 3)
+	assert.Len(t, store.manifests, 1)
+}
+
+func TestDeploy_BlueGreenContainerExits(t *testing.T) {
+	e := newMockEngineEndpoint()
+	e.exited = "app_web_1_next"
+	_, restore := blueGreenFixture(e)
+	defer restore()
+
+	_, err := Deploy(Options{Flags: map[string]string{"strategy": "blue-green"}})
+
+	assert.EqualError(t, err, "app_web_1_next exited with code 1")
+	assert.Len(t, e.containers, 3)
+	assert.Equal(t, "web:1", e.containers["app_web_1"].Image)
+}
+
+func TestDeploy_BlueGreenFixedPort(t *testing.T) {
+	e := newMockEngineEndpoint()
+	_, restore := blueGreenFixture(e)
+	defer restore()
+	DefaultComposer = &mockRequestComposer{
+		requests: []proxy.ContainerRequest{
+			{Name: "app_web_1", CreateOptions: []byte(`{"Image": "web:2", "HostConfig": {"PortBindings": {"80/tcp": [{"HostPort": "8080"}]}}}`)},
+		},
+	}
+
+	_, err := Deploy(Options{Flags: map[string]string{"strategy": "blue-green"}})
+
+	assert.EqualError(t, err, "app_web_1 publishes fixed host port 8080, so it can't run next to the live container; deploy it with --strategy=recreate or rolling")
+	assert.Empty(t, e.calls)
+	assert.Equal(t, "web:1", e.containers["app_web_1"].Image)
+}
+
+func rollingFixture(e *mockEngineEndpoint) (*memoryHistoryStore, func()) {
+	for i := 1; i <= 3; i++ {
+		var cc endpoint.ContainerConfig
+		cc.Image = "web:1"
+		e.containers[fmt.Sprintf("app_web_%d", i)] = cc
+	}
+
+	store := &memoryHistoryStore{manifests: DeploymentManifests{{
+		Services: []Service{{Name: "app_web_1", Scale: 3, ContainerConfig: e.containers["app_web_1"]}},
+	}}}
+	restore := useFixture(store, e, &mockRequestComposer{
+		requests: []proxy.ContainerRequest{{Name: "app_web_1", CreateOptions: []byte(`{"Image": "web:2"}`)}},
+	})
+
+	return store, restore
+}
+
+func TestDeploy_Rolling(t *testing.T) {
+	e := newMockEngineEndpoint()
+	store, restore := rollingFixture(e)
+	defer restore()
+
+	o, err := Deploy(Options{Flags: map[string]string{"strategy": "rolling", "batch-size": "2"}})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Successfully deployed 3 container(s)", o.ToPrettyOutput())
+	assert.Equal(t, []string{
+		"remove app_web_1",
+		"start app_web_1",
+		"remove app_web_2",
+		"start app_web_2",
+		"remove app_web_3",
+		"start app_web_3",
+	}, e.calls)
+	assert.Equal(t, "abc123", e.containers["app_web_3"].Image)
+	assert.Len(t, store.manifests, 2)
+}
+
+func TestDeploy_RollingBatchFails(t *testing.T) {
+	e := newMockEngineEndpoint()
+	e.failStart = "app_web_3"
+	store, restore := rollingFixture(e)
+	defer restore()
+
+	_, err := Deploy(Options{Flags: map[string]string{"strategy": "rolling", "batch-size": "2"}})
+
+	assert.EqualError(t, err, "port is already allocated (the previous deployment was restored)")
+	assert.IsType(t, FailedDeployError{}, err)
+	assert.Equal(t, []string{
+		"remove app_web_1",
+		"start app_web_1",
+		"remove app_web_2",
+		"start app_web_2",
+		"remove app_web_3",
+		"start app_web_3",
+		"remove app_web_1",
+		"remove app_web_2",
+		"remove app_web_3",
+		"start app_web_1",
+		"start app_web_2",
+		"start app_web_3",
+	}, e.calls[:12])
+	assert.Equal(t, "web:1", e.containers["app_web_1"].Image)
+	assert.Len(t, store.manifests, 1)
+}
+
+func TestStrategyFor_InvalidRollingOptions(t *testing.T) {
+	_, err := strategyFor(map[string]string{"strategy": "rolling", "batch-size": "0"})
+	assert.EqualError(t, err, "Invalid batch size '0', must be a number of at least 1")
+
+	_, err = strategyFor(map[string]string{"strategy": "rolling", "pause": "soon"})
+	assert.EqualError(t, err, "Invalid pause 'soon', expected a duration like 10s")
+}
+
+func TestDeploy_UnknownStrategy(t *testing.T) {
+	_, err := Deploy(Options{Flags: map[string]string{"strategy": "canary"}})
+
+	assert.EqualError(t, err, "Unknown deploy strategy 'canary', use one of: recreate, blue-green, rolling")
+}
+
+func TestRetarget(t *testing.T) {
+	var cc endpoint.ContainerConfig
+	cc.HostConfig.Links = []string{"app_db_1:db", "external:ext"}
+	cc.HostConfig.VolumesFrom = []string{"app_data_1:ro"}
+	cc.HostConfig.NetworkMode = "container:app_db_1"
+	names := map[string]string{"app_db_1": "app_db_1_next", "app_data_1": "app_data_1_next"}
+
+	retargeted := retarget(cc, names)
+
+	assert.Equal(t, []string{"app_db_1_next:db", "external:ext"}, retargeted.HostConfig.Links)
+	assert.Equal(t, []string{"app_data_1_next:ro"}, retargeted.HostConfig.VolumesFrom)
+	assert.Equal(t, "container:app_db_1_next", retargeted.HostConfig.NetworkMode)
+	assert.Equal(t, []string{"app_db_1:db", "external:ext"}, cc.HostConfig.Links)
+}
+
+func TestDeploy_RecreateFailureRestoresPrevious(t *testing.T) {
+	e := newMockEngineEndpoint()
+	var old endpoint.ContainerConfig
+	old.Image = "old"
+	var previous []Service
+	var reqs []proxy.ContainerRequest
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		name = fmt.Sprintf("app_%s_1", name)
+		e.containers[name] = old
+		previous = append(previous, Service{Name: name, ContainerConfig: old})
+		reqs = append(reqs, proxy.ContainerRequest{Name: name, CreateOptions: []byte(`{"Image": "new"}`)})
+	}
+	e.failStart = "app_c_1"
+
+	store := &memoryHistoryStore{manifests: DeploymentManifests{{Services: previous}}}
+	defer useFixture(store, e, &mockRequestComposer{requests: reqs})()
+
+	_, err := Deploy(Options{})
+
+	assert.EqualError(t, err, "port is already allocated (the previous deployment was restored)")
+	assert.Len(t, e.containers, 5)
+	for name, cc := range e.containers {
+		assert.Equal(t, "old", cc.Image, name)
+	}
+	assert.Len(t, store.manifests, 1)
+}
+
+func TestDeploy_RecreateFailureWithoutPreviousDeployment(t *testing.T) {
+	e := newMockEngineEndpoint()
+	e.failStart = "app_b_1"
+
+	defer useFixture(&memoryHistoryStore{}, e, &mockRequestComposer{requests: []proxy.ContainerRequest{
+		{Name: "app_a_1", CreateOptions: []byte(`{"Image": "a"}`)},
+		{Name: "app_b_1", CreateOptions: []byte(`{"Image": "b"}`)},
+	}})()
+
+	_, err := Deploy(Options{})
+
+	assert.EqualError(t, err, "port is already allocated (the new containers were removed, there was no previous deployment to restore)")
+	assert.Empty(t, e.containers)
+}
@@ -2,26 +2,79 @@ package actions
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/CenturyLinkLabs/prettycli"
 )
 
 func Teardown(options Options) (prettycli.Output, error) {
+	start := time.Now()
 
 	endpoint, err := endpointFactory(options.EndpointOptions)
 	if err != nil {
 		return nil, err
 	}
 
+	if !isDryRun(options) {
+		release, err := projectLocker(options, endpoint)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+
 	reqs, err := collectRequests(options, true)
 	if err != nil {
 		return nil, err
 	}
 
+	store, err := historyStore(options, endpoint, reqs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Scaled services have more containers than the compose file names.
+	var extraNames []string
+	manifests, err := store.Load()
+	if err == nil && len(manifests) > 0 {
+		for _, svc := range manifests[len(manifests)-1].Services {
+			extraNames = append(extraNames, svc.ContainerNames()[1:]...)
+		}
+	}
+
+	if isDryRun(options) {
+		var names []string
+		for _, req := range reqs {
+			names = append(names, req.Name)
+		}
+		containers, err := planRemovals(append(names, extraNames...), projectName(options), manifests.services(), endpoint)
+		if err != nil {
+			return nil, err
+		}
+		return planOutput(PlanResult{Command: "teardown", Containers: containers, RemoveHistory: true}), nil
+	}
+
+	var removed []string
 	for _, req := range reqs {
 		endpoint.RemoveContainer(req.Name)
+		removed = append(removed, req.Name)
+	}
+
+	for _, name := range extraNames {
+		endpoint.RemoveContainer(name)
+		removed = append(removed, name)
+	}
+
+	if err := store.Remove(); err != nil {
+		return nil, err
 	}
 
-	output := fmt.Sprintf("Successfully removed %d services and all deployment history", len(reqs))
-	return prettycli.PlainOutput{output}, nil
+	return ResultOutput{
+		Message: fmt.Sprintf("Successfully removed %d services and all deployment history", len(reqs)),
+		Result: TeardownResult{
+			Services:   len(reqs),
+			Containers: removed,
+			Duration:   time.Since(start).String(),
+		},
+	}, nil
 }
@@ -26,7 +26,7 @@ func TestTeardown_Success(t *testing.T) {
 
 	var removeCalls []string
 
-	proxyFactory = func(string, endpoint.Endpoint, bool) proxy.Proxy {
+	proxyFactory = func(string, endpoint.Endpoint, bool, endpoint.BuildOptions) proxy.Proxy {
 		return &mockProxy{
 			requests: []proxy.ContainerRequest{
 				{
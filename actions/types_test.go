@@ -3,6 +3,7 @@ package actions
 import (
 	"io"
 
+	"github.com/CenturyLinkLabs/zodiac/composer"
 	"github.com/CenturyLinkLabs/zodiac/endpoint"
 	"github.com/CenturyLinkLabs/zodiac/proxy"
 	log "github.com/Sirupsen/logrus"
@@ -11,6 +12,37 @@ import (
 
 func init() {
 	log.SetLevel(log.FatalLevel)
+	authConfigsLoader = func(string) (endpoint.AuthConfigs, error) {
+		return endpoint.AuthConfigs{}, nil
+	}
+	projectLocker = func(Options, endpoint.Endpoint) (func(), error) {
+		return func() {}, nil
+	}
+}
+
+// useFixture points the actions at the history store, endpoint and composer
+// of a test. It returns a func that puts back what was there before, for the
+// test to defer.
+func useFixture(store HistoryStore, e endpoint.Endpoint, c composer.Composer) func() {
+	restore := saveFactories()
+	historyStoreFactory = func(string, Options, endpoint.Endpoint, []proxy.ContainerRequest) (HistoryStore, error) {
+		return store, nil
+	}
+	endpointFactory = func(endpoint.EndpointOptions) (endpoint.Endpoint, error) {
+		return e, nil
+	}
+	DefaultComposer = c
+	return restore
+}
+
+// saveFactories returns a func that restores the history store factory,
+// endpoint factory and composer as they are now, for tests that set them up
+// themselves.
+func saveFactories() func() {
+	stores, endpoints, c := historyStoreFactory, endpointFactory, DefaultComposer
+	return func() {
+		historyStoreFactory, endpointFactory, DefaultComposer = stores, endpoints, c
+	}
 }
 
 type mockEndpoint struct{}
@@ -27,22 +59,66 @@ func (e mockEndpoint) StartContainer(string, endpoint.ContainerConfig) error {
 	return nil
 }
 
-func (e mockEndpoint) ResolveImage(imgNm string) (string, error) {
+func (e mockEndpoint) CreateContainer(string, endpoint.ContainerConfig) error {
+	return nil
+}
+
+func (e mockEndpoint) ResolveImage(imgNm string, auth *dockerclient.AuthConfig) (string, error) {
+	return "abc123", nil
+}
+
+func (e mockEndpoint) LookupImage(imgNm string) (string, error) {
 	return "abc123", nil
 }
 
-func (e mockEndpoint) BuildImage(bctx io.Reader, sn string) error {
+func (e mockEndpoint) ImageDigests(imgNm string) ([]string, error) {
+	return nil, nil
+}
+
+func (e mockEndpoint) ListImages() ([]*dockerclient.Image, error) {
+	return nil, nil
+}
+
+func (e mockEndpoint) RemoveImage(name string) error {
+	return nil
+}
+
+func (e mockEndpoint) TagImage(id, repo, tag string) error {
 	return nil
 }
 
+func (e mockEndpoint) BuildImage(bctx io.Reader, sn string, opts endpoint.BuildOptions, out io.Writer) (string, error) {
+	return "", nil
+}
+
 func (e mockEndpoint) InspectContainer(name string) (*dockerclient.ContainerInfo, error) {
 	return &dockerclient.ContainerInfo{}, nil
 }
 
+func (e mockEndpoint) InspectContainerDetails(name string) (*endpoint.ContainerDetails, error) {
+	return &endpoint.ContainerDetails{}, nil
+}
+
+func (e mockEndpoint) ListContainers() ([]dockerclient.Container, error) {
+	return nil, nil
+}
+
 func (e mockEndpoint) RemoveContainer(name string) error {
 	return nil
 }
 
+func (e mockEndpoint) StopContainer(name string) error {
+	return nil
+}
+
+func (e mockEndpoint) RenameContainer(oldName, newName string) error {
+	return nil
+}
+
+func (e mockEndpoint) Exec(name string, cmd []string) (int, error) {
+	return 0, nil
+}
+
 func (e mockEndpoint) Version() (string, error) {
 	return "1.0", nil
 }
@@ -69,6 +145,20 @@ func (c *mockComposer) Run(flags map[string]string) error {
 	return nil
 }
 
+type mockRequestComposer struct {
+	requests []proxy.ContainerRequest
+	noBuild  bool
+}
+
+func (c *mockRequestComposer) Run(flags map[string]string) error {
+	return nil
+}
+
+func (c *mockRequestComposer) Requests(flags map[string]string, e endpoint.Endpoint, noBuild bool) ([]proxy.ContainerRequest, error) {
+	c.noBuild = noBuild
+	return c.requests, nil
+}
+
 type capturedStartParams struct {
 	Name   string
 	Config endpoint.ContainerConfig
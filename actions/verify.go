@@ -2,6 +2,8 @@ package actions
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/CenturyLinkLabs/prettycli"
@@ -22,19 +24,61 @@ func Verify(options Options) (prettycli.Output, error) {
 
 	log.Infof("Validating endpoint %s", endpoint.Name())
 
-	if err := verifyEndpoint(endpoint); err != nil {
+	version, err := verifyEndpoint(endpoint)
+	if err != nil {
 		return nil, err
 	}
 
-	s := fmt.Sprintf("Successfully verified endpoint: %s", endpoint.Name())
-	return prettycli.PlainOutput{s}, nil
+	result := VerifyResult{
+		Endpoint: endpoint.Name(),
+		Version:  version,
+		Target:   options.Target,
+		Settings: resolvedSettings(options),
+	}
+
+	message := fmt.Sprintf("Successfully verified endpoint: %s", endpoint.Name())
+	if options.Target != "" {
+		message += fmt.Sprintf("\nResolved settings for target %s:", options.Target)
+		var keys []string
+		for k := range result.Settings {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			message += fmt.Sprintf("\n  %-12s%s", k+":", result.Settings[k])
+		}
+	}
+
+	return ResultOutput{Message: message, Result: result}, nil
+}
+
+// resolvedSettings are the settings the command ended up with, after the
+// target, environment and flags were applied.
+func resolvedSettings(options Options) map[string]string {
+	eo := options.EndpointOptions
+	settings := map[string]string{
+		"endpoint":  eo.Host,
+		"tls":       strconv.FormatBool(eo.TLS),
+		"tlsverify": strconv.FormatBool(eo.TLSVerify),
+		"tlscacert": eo.TLSCaCert,
+		"tlscert":   eo.TLSCert,
+		"tlskey":    eo.TLSKey,
+		"history":   options.History,
+	}
+	for _, k := range []string{"name", "file"} {
+		if v := options.Flags[k]; v != "" {
+			settings[k] = v
+		}
+	}
+	return settings
 }
 
-func verifyEndpoint(e endpoint.Endpoint) error {
+func verifyEndpoint(e endpoint.Endpoint) (string, error) {
 	version, err := e.Version()
 	if err != nil {
-		return err
+		return "", err
 	}
+	reported := version
 
 	log.Infof("%s reported version %s", e.Name(), version)
 
@@ -47,16 +91,16 @@ func verifyEndpoint(e endpoint.Endpoint) error {
 
 	semver, err := semver.Make(version)
 	if err != nil {
-		return fmt.Errorf("can't understand version '%s'", version)
+		return "", fmt.Errorf("can't understand version '%s'", version)
 	}
 
 	if isSwarm && semver.LT(RequiredSwarmAPIVersion) {
-		return fmt.Errorf("Swarm API must be %s or above, but it is %s", RequiredSwarmAPIVersion, semver)
+		return "", fmt.Errorf("Swarm API must be %s or above, but it is %s", RequiredSwarmAPIVersion, semver)
 	}
 
 	if !isSwarm && semver.LT(RequiredDockerAPIVersion) {
-		return fmt.Errorf("Docker API must be %s or above, but it is %s", RequiredDockerAPIVersion, semver)
+		return "", fmt.Errorf("Docker API must be %s or above, but it is %s", RequiredDockerAPIVersion, semver)
 	}
 
-	return nil
+	return reported, nil
 }
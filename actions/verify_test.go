@@ -91,3 +91,24 @@ func TestVerify_SwarmOldVersion(t *testing.T) {
 	assert.EqualError(t, err, "Swarm API must be 0.3.0 or above, but it is 0.2.1")
 	assert.Nil(t, o)
 }
+
+func TestVerify_ReportsTargetSettings(t *testing.T) {
+	e := mockVerifyEndpoint{version: "1.6.1", url: "tcp://staging:2376"}
+	endpointFactory = func(endpoint.EndpointOptions) (endpoint.Endpoint, error) {
+		return e, nil
+	}
+	o, err := Verify(Options{
+		Target:          "staging",
+		Flags:           map[string]string{"name": "shop"},
+		EndpointOptions: endpoint.EndpointOptions{Host: "tcp://staging:2376", TLSVerify: true},
+		History:         "label",
+	})
+
+	assert.NoError(t, err)
+	result := o.(ResultOutput).Result.(VerifyResult)
+	assert.Equal(t, "staging", result.Target)
+	assert.Equal(t, "shop", result.Settings["name"])
+	assert.Equal(t, "true", result.Settings["tlsverify"])
+	assert.Contains(t, o.ToPrettyOutput(), "Resolved settings for target staging:")
+	assert.Contains(t, o.ToPrettyOutput(), "  history:    label")
+}
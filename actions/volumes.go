@@ -0,0 +1,97 @@
+package actions
+
+import (
+	"fmt"
+	"strings"
+)
+
+// resolveVolumesFrom rewrites each service's volumes_from references to the
+// names of the containers zodiac deploys, records which services they point
+// at, and orders the services so the containers providing volumes are
+// created first.
+func resolveVolumesFrom(services []Service) ([]Service, error) {
+	services = append([]Service(nil), services...)
+
+	for i := range services {
+		svc := &services[i]
+		svc.VolumesFrom = nil
+
+		var volumesFrom []string
+		for _, ref := range svc.ContainerConfig.HostConfig.VolumesFrom {
+			parts := strings.SplitN(ref, ":", 2)
+			if target, ok := findVolumesFromTarget(parts[0], services); ok {
+				parts[0] = target
+				svc.VolumesFrom = append(svc.VolumesFrom, target)
+			}
+			volumesFrom = append(volumesFrom, strings.Join(parts, ":"))
+		}
+		svc.ContainerConfig.HostConfig.VolumesFrom = volumesFrom
+	}
+
+	return orderByVolumesFrom(services)
+}
+
+// findVolumesFromTarget matches a volumes_from reference against the
+// services being deployed. References may be the full container name, the
+// name of a differently-numbered instance of the service, or the bare
+// compose service name.
+func findVolumesFromTarget(ref string, services []Service) (string, bool) {
+	for _, svc := range services {
+		if svc.Name == ref {
+			return svc.Name, true
+		}
+	}
+
+	for _, svc := range services {
+		project, name, _ := splitContainerName(svc.Name)
+		refProject, refName, ok := splitContainerName(ref)
+		if ok && refProject == project && refName == name {
+			return svc.Name, true
+		}
+		if ref == name {
+			return svc.Name, true
+		}
+	}
+
+	return "", false
+}
+
+func orderByVolumesFrom(services []Service) ([]Service, error) {
+	byName := map[string]Service{}
+	for _, svc := range services {
+		byName[svc.Name] = svc
+	}
+
+	var ordered []Service
+	added := map[string]bool{}
+	visiting := map[string]bool{}
+
+	var visit func(Service) error
+	visit = func(svc Service) error {
+		if added[svc.Name] {
+			return nil
+		}
+		if visiting[svc.Name] {
+			return fmt.Errorf("Circular volumes_from reference involving %s", svc.Name)
+		}
+		visiting[svc.Name] = true
+
+		for _, dep := range svc.VolumesFrom {
+			if err := visit(byName[dep]); err != nil {
+				return err
+			}
+		}
+
+		added[svc.Name] = true
+		ordered = append(ordered, svc)
+		return nil
+	}
+
+	for _, svc := range services {
+		if err := visit(svc); err != nil {
+			return nil, err
+		}
+	}
+
+	return ordered, nil
+}
@@ -0,0 +1,367 @@
+package composer
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/samalba/dockerclient"
+)
+
+type serviceConfig struct {
+	Image         string       `yaml:"image"`
+	Build         buildConfig  `yaml:"build"`
+	Dockerfile    string       `yaml:"dockerfile"`
+	Command       commandLine  `yaml:"command"`
+	Entrypoint    commandLine  `yaml:"entrypoint"`
+	Environment   keyValueList `yaml:"environment"`
+	EnvFile       stringList   `yaml:"env_file"`
+	Labels        keyValueList `yaml:"labels"`
+	Links         stringList   `yaml:"links"`
+	ExternalLinks stringList   `yaml:"external_links"`
+	Ports         stringList   `yaml:"ports"`
+	Expose        stringList   `yaml:"expose"`
+	Volumes       stringList   `yaml:"volumes"`
+	VolumesFrom   stringList   `yaml:"volumes_from"`
+	Net           string       `yaml:"net"`
+	DNS           stringList   `yaml:"dns"`
+	DNSSearch     stringList   `yaml:"dns_search"`
+	Restart       string       `yaml:"restart"`
+	Privileged    flexibleBool `yaml:"privileged"`
+	StdinOpen     flexibleBool `yaml:"stdin_open"`
+	Tty           flexibleBool `yaml:"tty"`
+	ReadOnly      flexibleBool `yaml:"read_only"`
+	User          string       `yaml:"user"`
+	Hostname      string       `yaml:"hostname"`
+	Domainname    string       `yaml:"domainname"`
+	WorkingDir    string       `yaml:"working_dir"`
+	CPUShares     int64        `yaml:"cpu_shares"`
+	CPUSet        string       `yaml:"cpuset"`
+	MemLimit      memoryLimit  `yaml:"mem_limit"`
+	MemswapLimit  memoryLimit  `yaml:"memswap_limit"`
+	MacAddress    string       `yaml:"mac_address"`
+	SecurityOpt   stringList   `yaml:"security_opt"`
+	LogDriver     string       `yaml:"log_driver"`
+	LogOpt        keyValueList `yaml:"log_opt"`
+	Ulimits       ulimitList   `yaml:"ulimits"`
+}
+
+// Options compose accepts but the Docker create request zodiac sends has no
+// room for. They are dropped with a warning, just as they are when the create
+// body is captured from docker-compose.
+var ignoredOptions = map[string]bool{
+	"cap_add":        true,
+	"cap_drop":       true,
+	"cgroup_parent":  true,
+	"container_name": true,
+	"devices":        true,
+	"extra_hosts":    true,
+	"pid":            true,
+}
+
+var supportedOptions = map[string]bool{
+	"image":          true,
+	"build":          true,
+	"dockerfile":     true,
+	"command":        true,
+	"entrypoint":     true,
+	"environment":    true,
+	"env_file":       true,
+	"labels":         true,
+	"links":          true,
+	"external_links": true,
+	"ports":          true,
+	"expose":         true,
+	"volumes":        true,
+	"volumes_from":   true,
+	"net":            true,
+	"dns":            true,
+	"dns_search":     true,
+	"restart":        true,
+	"privileged":     true,
+	"stdin_open":     true,
+	"tty":            true,
+	"read_only":      true,
+	"user":           true,
+	"hostname":       true,
+	"domainname":     true,
+	"working_dir":    true,
+	"cpu_shares":     true,
+	"cpuset":         true,
+	"mem_limit":      true,
+	"memswap_limit":  true,
+	"mac_address":    true,
+	"security_opt":   true,
+	"log_driver":     true,
+	"log_opt":        true,
+	"ulimits":        true,
+}
+
+func checkOptions(svcName string, raw map[string]interface{}) ([]string, error) {
+	var ignored []string
+	for key := range raw {
+		if supportedOptions[key] {
+			continue
+		}
+		if ignoredOptions[key] {
+			ignored = append(ignored, key)
+			continue
+		}
+		return nil, fmt.Errorf("Unsupported config option for %s service: '%s'", svcName, key)
+	}
+	sort.Strings(ignored)
+
+	return ignored, nil
+}
+
+// buildConfig accepts either the path of the build context or, as the
+// version 2 format has it, a mapping of the context, dockerfile and args.
+type buildConfig struct {
+	Context    string       `yaml:"context"`
+	Dockerfile string       `yaml:"dockerfile"`
+	Args       keyValueList `yaml:"args"`
+}
+
+func (b *buildConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var context string
+	if err := unmarshal(&context); err == nil {
+		b.Context = context
+		return nil
+	}
+
+	// A distinct type keeps unmarshal from calling this method again.
+	type plain buildConfig
+	return unmarshal((*plain)(b))
+}
+
+// stringList accepts either a single scalar or a list of scalars.
+type stringList []string
+
+func (l *stringList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var items []interface{}
+	if err := unmarshal(&items); err != nil {
+		var item interface{}
+		if err := unmarshal(&item); err != nil {
+			return err
+		}
+		items = []interface{}{item}
+	}
+
+	for _, item := range items {
+		if item == nil {
+			continue
+		}
+		*l = append(*l, fmt.Sprint(item))
+	}
+
+	return nil
+}
+
+// keyValueList accepts either a mapping or a list of "key=value" strings and
+// normalizes both to the list form.
+type keyValueList []string
+
+func (l *keyValueList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var m map[string]interface{}
+	if err := unmarshal(&m); err == nil {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			if m[k] == nil {
+				*l = append(*l, k)
+			} else {
+				*l = append(*l, fmt.Sprintf("%s=%v", k, m[k]))
+			}
+		}
+		return nil
+	}
+
+	var list stringList
+	if err := unmarshal(&list); err != nil {
+		return err
+	}
+	*l = keyValueList(list)
+
+	return nil
+}
+
+// Map splits the entries into a map, leaving the value empty for entries
+// without one.
+func (l keyValueList) Map() map[string]string {
+	m := make(map[string]string)
+	for _, kv := range l {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 2 {
+			m[parts[0]] = parts[1]
+		} else {
+			m[parts[0]] = ""
+		}
+	}
+	return m
+}
+
+// Env resolves entries without a value from the local environment, the way
+// compose does, and drops them when they are not set locally either.
+func (l keyValueList) Env() []string {
+	var env []string
+	for _, kv := range l {
+		if strings.Contains(kv, "=") {
+			env = append(env, kv)
+		} else if v := os.Getenv(kv); v != "" {
+			env = append(env, fmt.Sprintf("%s=%s", kv, v))
+		}
+	}
+	return env
+}
+
+// commandLine accepts either a list of arguments or a single string that is
+// split the way a shell would.
+type commandLine []string
+
+func (c *commandLine) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err == nil {
+		args, err := splitCommand(s)
+		if err != nil {
+			return err
+		}
+		*c = args
+		return nil
+	}
+
+	var list stringList
+	if err := unmarshal(&list); err != nil {
+		return err
+	}
+	*c = commandLine(list)
+
+	return nil
+}
+
+// flexibleBool accepts both YAML booleans and strings like "true".
+type flexibleBool bool
+
+func (b *flexibleBool) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var v interface{}
+	if err := unmarshal(&v); err != nil {
+		return err
+	}
+
+	switch t := v.(type) {
+	case bool:
+		*b = flexibleBool(t)
+	case string:
+		*b = flexibleBool(strings.ToLower(t) == "true")
+	default:
+		return fmt.Errorf("expected a boolean, got %v", v)
+	}
+
+	return nil
+}
+
+// ulimitList accepts a mapping of limit names to either a single number,
+// used as both the soft and hard limit, or a mapping of soft and hard.
+type ulimitList []dockerclient.Ulimit
+
+func (l *ulimitList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var m map[string]interface{}
+	if err := unmarshal(&m); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		ulimit := dockerclient.Ulimit{Name: name}
+		switch v := m[name].(type) {
+		case int:
+			if v < 0 {
+				return fmt.Errorf("invalid value for ulimit %s: %v", name, v)
+			}
+			ulimit.Soft, ulimit.Hard = uint64(v), uint64(v)
+		case map[interface{}]interface{}:
+			soft, ok := v["soft"].(int)
+			hard, ok2 := v["hard"].(int)
+			if !ok || !ok2 || soft < 0 || hard < 0 {
+				return fmt.Errorf("ulimit %s needs both a soft and a hard limit", name)
+			}
+			if soft > hard {
+				return fmt.Errorf("soft limit for ulimit %s is greater than its hard limit", name)
+			}
+			ulimit.Soft, ulimit.Hard = uint64(soft), uint64(hard)
+		default:
+			return fmt.Errorf("invalid value for ulimit %s: %v", name, v)
+		}
+		*l = append(*l, ulimit)
+	}
+
+	return nil
+}
+
+// memoryLimit accepts either a number of bytes or a size like "512m".
+type memoryLimit string
+
+func (m *memoryLimit) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var v interface{}
+	if err := unmarshal(&v); err != nil {
+		return err
+	}
+	*m = memoryLimit(fmt.Sprint(v))
+
+	return nil
+}
+
+func splitCommand(s string) ([]string, error) {
+	var args []string
+	var current []rune
+	var quote rune
+	inArg := false
+	escaped := false
+
+	for _, r := range s {
+		switch {
+		case escaped:
+			current = append(current, r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current = append(current, r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, string(current))
+				current = current[:0]
+				inArg = false
+			}
+		default:
+			current = append(current, r)
+			inArg = true
+		}
+	}
+
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unable to parse command: %s", s)
+	}
+	if inArg {
+		args = append(args, string(current))
+	}
+
+	return args, nil
+}
@@ -0,0 +1,196 @@
+package composer
+
+import (
+	"archive/tar"
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// buildContext is a service's build directory, less the files its
+// .dockerignore excludes.
+type buildContext struct {
+	dir        string
+	dockerfile string
+	ignore     []string
+}
+
+func loadBuildContext(dir, dockerfile string) (buildContext, error) {
+	c := buildContext{dir: dir, dockerfile: dockerfile}
+	if c.dockerfile == "" {
+		c.dockerfile = "Dockerfile"
+	}
+
+	f, err := os.Open(filepath.Join(dir, ".dockerignore"))
+	if os.IsNotExist(err) {
+		return c, nil
+	} else if err != nil {
+		return c, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		negated := strings.HasPrefix(line, "!")
+		pattern := filepath.ToSlash(filepath.Clean(strings.TrimPrefix(line, "!")))
+		pattern = strings.TrimPrefix(pattern, "/")
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return c, fmt.Errorf("Invalid pattern '%s' in %s", line, f.Name())
+		}
+		if negated {
+			pattern = "!" + pattern
+		}
+		c.ignore = append(c.ignore, pattern)
+	}
+	return c, scanner.Err()
+}
+
+// excluded tells whether .dockerignore excludes the file. A pattern matching
+// a directory excludes everything in it, and a later pattern starting with !
+// includes files again. The Dockerfile and .dockerignore are always sent,
+// as the Docker CLI does.
+func (c buildContext) excluded(rel string) bool {
+	if rel == filepath.ToSlash(filepath.Clean(c.dockerfile)) || rel == ".dockerignore" {
+		return false
+	}
+
+	excluded := false
+	for _, pattern := range c.ignore {
+		negated := strings.HasPrefix(pattern, "!")
+		if matchesPath(strings.TrimPrefix(pattern, "!"), rel) {
+			excluded = !negated
+		}
+	}
+	return excluded
+}
+
+func (c buildContext) hasExceptions() bool {
+	for _, pattern := range c.ignore {
+		if strings.HasPrefix(pattern, "!") {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesPath matches the pattern against the path and each of its parent
+// directories.
+func matchesPath(pattern, rel string) bool {
+	for p := rel; p != "." && p != "/"; p = filepath.ToSlash(filepath.Dir(p)) {
+		if ok, _ := filepath.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// walk calls fn for every file in the context, in lexical order.
+func (c buildContext) walk(fn func(path, rel string, info os.FileInfo) error) error {
+	return filepath.Walk(c.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(c.dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if c.excluded(rel) {
+			// Exceptions may include files further down.
+			if info.IsDir() && !c.hasExceptions() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		return fn(path, rel, info)
+	})
+}
+
+// write tars up the context the way the Docker CLI sends it.
+func (c buildContext) write(w io.Writer) error {
+	tw := tar.NewWriter(w)
+
+	err := c.walk(func(path, rel string, info os.FileInfo) error {
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			var err error
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = rel
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		return copyFile(tw, path)
+	})
+	if err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// hash digests the names, modes and contents of the files in the context.
+// Unlike the tar it ignores modification times, so touching a file doesn't
+// count as a change.
+func (c buildContext) hash() (string, error) {
+	h := sha256.New()
+
+	err := c.walk(func(path, rel string, info os.FileInfo) error {
+		fmt.Fprintf(h, "%s %o\n", rel, info.Mode())
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "%s\n", link)
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		return copyFile(h, path)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("sha256:%x", h.Sum(nil)), nil
+}
+
+func copyFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
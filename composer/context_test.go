@@ -0,0 +1,92 @@
+package composer
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func tarNames(t *testing.T, ctx buildContext) []string {
+	var buf bytes.Buffer
+	if err := ctx.write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return names
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+}
+
+func TestBuildContext_DockerIgnore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zodiac-context")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeFiles(t, dir, map[string]string{
+		".dockerignore":  "# build output\n*.log\nDockerfile\ntmp\n!tmp/keep\n",
+		"Dockerfile":     "FROM busybox\n",
+		"app.go":         "package main\n",
+		"debug.log":      "noise\n",
+		"tmp/cache":      "stale\n",
+		"tmp/keep":       "kept\n",
+		"node/debug.log": "nested, not matched by *.log\n",
+	})
+
+	ctx, err := loadBuildContext(dir, "")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{".dockerignore", "Dockerfile", "app.go", "node/", "node/debug.log", "tmp/keep"}, tarNames(t, ctx))
+}
+
+func TestBuildContext_Hash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zodiac-context")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeFiles(t, dir, map[string]string{
+		".dockerignore": "*.log\n",
+		"Dockerfile":    "FROM busybox\n",
+	})
+	ctx, _ := loadBuildContext(dir, "")
+	before, err := ctx.hash()
+	assert.NoError(t, err)
+
+	writeFiles(t, dir, map[string]string{"debug.log": "ignored\n"})
+	ignored, _ := ctx.hash()
+	assert.Equal(t, before, ignored)
+
+	writeFiles(t, dir, map[string]string{"Dockerfile": "FROM alpine\n"})
+	changed, _ := ctx.hash()
+	assert.NotEqual(t, before, changed)
+}
@@ -0,0 +1,698 @@
+package composer
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/CenturyLinkLabs/zodiac/endpoint"
+	"github.com/CenturyLinkLabs/zodiac/proxy"
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/pkg/units"
+	"github.com/samalba/dockerclient"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	DefaultProjectName = "zodiac"
+	DefaultComposeFile = "docker-compose.yml"
+)
+
+var (
+	validServiceName   = regexp.MustCompile(`^[a-zA-Z0-9]+$`)
+	invalidProjectChar = regexp.MustCompile(`[^a-z0-9]`)
+
+	// Progress receives the messages printed while building images.
+	Progress io.Writer = os.Stdout
+)
+
+// RequestComposer is implemented by composers that can produce the
+// container create requests themselves, without docker-compose having to be
+// run against the capturing proxy.
+type RequestComposer interface {
+	Composer
+	Requests(flags map[string]string, e endpoint.Endpoint, noBuild bool) ([]proxy.ContainerRequest, error)
+}
+
+// Builder is implemented by composers that can build the project's images
+// on an endpoint separately from producing the requests, so the requests can
+// be used on several endpoints. Build returns the images by container name.
+// Every build is first passed to reuse, and is skipped if that returns the
+// ID of an image to use instead. BuildInfos describes the builds by container
+// name without running them.
+type Builder interface {
+	Build(flags map[string]string, e endpoint.Endpoint, reuse ReuseFunc) (map[string]BuiltImage, error)
+	BuildInfos(flags map[string]string) (map[string]proxy.BuildInfo, error)
+}
+
+type ReuseFunc func(container string, build proxy.BuildInfo) string
+
+type BuiltImage struct {
+	ID    string
+	Build proxy.BuildInfo
+}
+
+// BuildOptions are the build parameters set by zodiac's flags.
+func BuildOptions(flags map[string]string) endpoint.BuildOptions {
+	return endpoint.BuildOptions{
+		NoCache: flags["no-cache"] == "true",
+		Pull:    flags["pull"] == "true",
+	}
+}
+
+type NativeComposer struct{}
+
+func NewNativeComposer() *NativeComposer {
+	return &NativeComposer{}
+}
+
+// Run only validates the compose file; use Requests to get at the result.
+func (c *NativeComposer) Run(flags map[string]string) error {
+	_, err := c.load(flags)
+	return err
+}
+
+func (c *NativeComposer) Requests(flags map[string]string, e endpoint.Endpoint, noBuild bool) ([]proxy.ContainerRequest, error) {
+	p, err := c.load(flags)
+	if err != nil {
+		return nil, err
+	}
+
+	var reqs []proxy.ContainerRequest
+	for _, svc := range p.services {
+		var built *BuiltImage
+		if svc.config.Build.Context != "" && !noBuild {
+			info, ctx, err := p.buildInfo(svc)
+			if err != nil {
+				return nil, err
+			}
+			id, err := p.build(svc, ctx, info, BuildOptions(flags), e)
+			if err != nil {
+				return nil, err
+			}
+			built = &BuiltImage{ID: id, Build: info}
+		}
+
+		cc, err := p.containerConfig(svc)
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := json.Marshal(cc)
+		if err != nil {
+			return nil, err
+		}
+
+		req := proxy.ContainerRequest{
+			Name:          p.containerName(svc.name),
+			CreateOptions: body,
+		}
+		if built != nil {
+			req.ImageID = built.ID
+			req.Build = &built.Build
+		}
+		reqs = append(reqs, req)
+	}
+
+	return reqs, nil
+}
+
+// Build builds the images of the services that have a build option.
+func (c *NativeComposer) Build(flags map[string]string, e endpoint.Endpoint, reuse ReuseFunc) (map[string]BuiltImage, error) {
+	p, err := c.load(flags)
+	if err != nil {
+		return nil, err
+	}
+
+	built := map[string]BuiltImage{}
+	for _, svc := range p.services {
+		if svc.config.Build.Context == "" {
+			continue
+		}
+
+		info, ctx, err := p.buildInfo(svc)
+		if err != nil {
+			return nil, err
+		}
+
+		name := p.containerName(svc.name)
+		id := reuse(name, info)
+		if id != "" {
+			fmt.Fprintf(Progress, "Not building %s, its build context and parameters are unchanged\n", svc.name)
+		} else if id, err = p.build(svc, ctx, info, BuildOptions(flags), e); err != nil {
+			return nil, err
+		}
+		built[name] = BuiltImage{ID: id, Build: info}
+	}
+	return built, nil
+}
+
+func (c *NativeComposer) BuildInfos(flags map[string]string) (map[string]proxy.BuildInfo, error) {
+	p, err := c.load(flags)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := map[string]proxy.BuildInfo{}
+	for _, svc := range p.services {
+		if svc.config.Build.Context == "" {
+			continue
+		}
+
+		info, _, err := p.buildInfo(svc)
+		if err != nil {
+			return nil, err
+		}
+		infos[p.containerName(svc.name)] = info
+	}
+	return infos, nil
+}
+
+type service struct {
+	name   string
+	config serviceConfig
+}
+
+type project struct {
+	name     string
+	dir      string
+	services []service
+}
+
+func (c *NativeComposer) load(flags map[string]string) (*project, error) {
+	file := flags["file"]
+	if file == "" {
+		file = DefaultComposeFile
+	}
+	name := flags["name"]
+	if name == "" {
+		name = DefaultProjectName
+	}
+
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := filepath.Abs(filepath.Dir(file))
+	if err != nil {
+		return nil, err
+	}
+
+	p := &project{name: NormalizeProjectName(name), dir: dir}
+
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(contents, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+
+	for _, item := range doc {
+		svcName := fmt.Sprint(item.Key)
+		if !validServiceName.MatchString(svcName) {
+			return nil, fmt.Errorf("Invalid service name \"%s\" - only [a-zA-Z0-9] are allowed", svcName)
+		}
+
+		svc, err := parseService(svcName, item.Value)
+		if err != nil {
+			return nil, err
+		}
+		p.services = append(p.services, svc)
+	}
+
+	if err := p.sortServices(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func parseService(name string, value interface{}) (service, error) {
+	svc := service{name: name}
+
+	// Round-trip the generic value so it can be checked for unknown options
+	// and then decoded into the typed config.
+	raw, err := yaml.Marshal(value)
+	if err != nil {
+		return svc, err
+	}
+
+	var opts map[string]interface{}
+	if err := yaml.Unmarshal(raw, &opts); err != nil {
+		return svc, fmt.Errorf("Service \"%s\" doesn't have any configuration options", name)
+	}
+
+	ignored, err := checkOptions(name, opts)
+	if err != nil {
+		return svc, err
+	}
+	for _, key := range ignored {
+		log.Warnf("The %s option of the %s service is not supported and will be ignored", key, name)
+	}
+
+	if err := yaml.Unmarshal(raw, &svc.config); err != nil {
+		return svc, fmt.Errorf("Service \"%s\": %s", name, err)
+	}
+
+	if (svc.config.Image == "") == (svc.config.Build.Context == "") {
+		return svc, fmt.Errorf("Service %s has neither an image nor a build path specified. Exactly one must be provided.", name)
+	}
+
+	return svc, nil
+}
+
+// sortServices orders the services so that every service comes after the
+// services it links to, mounts volumes from or shares a network stack with.
+func (p *project) sortServices() error {
+	byName := map[string]service{}
+	for _, svc := range p.services {
+		byName[svc.name] = svc
+	}
+
+	var sorted []service
+	visited := map[string]bool{}
+	visiting := map[string]bool{}
+
+	var visit func(svc service) error
+	visit = func(svc service) error {
+		if visited[svc.name] {
+			return nil
+		}
+		if visiting[svc.name] {
+			return fmt.Errorf("Circular import between %s", svc.name)
+		}
+		visiting[svc.name] = true
+
+		for _, dep := range p.dependencies(svc) {
+			depSvc, ok := byName[dep]
+			if !ok {
+				return fmt.Errorf("Service \"%s\" depends on undefined service \"%s\"", svc.name, dep)
+			}
+			if err := visit(depSvc); err != nil {
+				return err
+			}
+		}
+
+		visiting[svc.name] = false
+		visited[svc.name] = true
+		sorted = append(sorted, svc)
+		return nil
+	}
+
+	for _, svc := range p.services {
+		if err := visit(svc); err != nil {
+			return err
+		}
+	}
+
+	p.services = sorted
+	return nil
+}
+
+func (p *project) dependencies(svc service) []string {
+	var deps []string
+	for _, link := range svc.config.Links {
+		deps = append(deps, strings.SplitN(link, ":", 2)[0])
+	}
+	for _, vf := range svc.config.VolumesFrom {
+		name := strings.SplitN(vf, ":", 2)[0]
+		if p.hasService(name) {
+			deps = append(deps, name)
+		}
+	}
+	if strings.HasPrefix(svc.config.Net, "container:") {
+		name := strings.TrimPrefix(svc.config.Net, "container:")
+		if p.hasService(name) {
+			deps = append(deps, name)
+		}
+	}
+	return deps
+}
+
+func (p *project) hasService(name string) bool {
+	for _, svc := range p.services {
+		if svc.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *project) containerName(svcName string) string {
+	return fmt.Sprintf("%s_%s_1", p.name, svcName)
+}
+
+func (p *project) imageName(svc service) string {
+	if svc.config.Build.Context != "" {
+		return fmt.Sprintf("%s_%s", p.name, svc.name)
+	}
+	return svc.config.Image
+}
+
+func (p *project) containerConfig(svc service) (endpoint.ContainerConfig, error) {
+	sc := svc.config
+	var cc endpoint.ContainerConfig
+
+	cc.Image = p.imageName(svc)
+	cc.Cmd = []string(sc.Command)
+	cc.Entrypoint.Value = []string(sc.Entrypoint)
+	cc.User = sc.User
+	cc.Hostname = sc.Hostname
+	cc.Domainname = sc.Domainname
+	cc.WorkingDir = sc.WorkingDir
+	cc.CpuShares = sc.CPUShares
+	cc.Cpuset = sc.CPUSet
+	cc.MacAddress = sc.MacAddress
+	cc.Tty.Value = bool(sc.Tty)
+	cc.OpenStdin.Value = bool(sc.StdinOpen)
+
+	var err error
+	if cc.Memory, err = parseMemory(sc.MemLimit); err != nil {
+		return cc, err
+	}
+	if cc.MemorySwap, err = parseMemory(sc.MemswapLimit); err != nil {
+		return cc, err
+	}
+
+	env, err := p.environment(sc)
+	if err != nil {
+		return cc, err
+	}
+	cc.Env = env
+
+	cc.Labels = sc.Labels.Map()
+	cc.Labels["com.docker.compose.project"] = p.name
+	cc.Labels["com.docker.compose.service"] = svc.name
+	cc.Labels["com.docker.compose.container-number"] = "1"
+	cc.Labels["com.docker.compose.oneoff"] = "False"
+
+	cc.ExposedPorts = map[string]struct{}{}
+	for _, port := range sc.Expose {
+		cc.ExposedPorts[portKey(port)] = struct{}{}
+	}
+
+	hc := &cc.HostConfig
+	hc.PortBindings = map[string][]dockerclient.PortBinding{}
+	for _, spec := range sc.Ports {
+		bindings, err := parsePortSpec(spec)
+		if err != nil {
+			return cc, err
+		}
+		for port, binding := range bindings {
+			cc.ExposedPorts[port] = struct{}{}
+			hc.PortBindings[port] = append(hc.PortBindings[port], binding)
+		}
+	}
+
+	cc.Volumes = map[string]struct{}{}
+	for _, spec := range sc.Volumes {
+		containerPath, bind, err := p.parseVolume(spec)
+		if err != nil {
+			return cc, err
+		}
+		cc.Volumes[containerPath] = struct{}{}
+		if bind != "" {
+			hc.Binds = append(hc.Binds, bind)
+		}
+	}
+
+	for _, link := range sc.Links {
+		parts := strings.SplitN(link, ":", 2)
+		name := p.containerName(parts[0])
+		alias := parts[0]
+		if len(parts) == 2 {
+			alias = parts[1]
+		}
+		shortName := strings.TrimPrefix(name, p.name+"_")
+		hc.Links = append(hc.Links,
+			fmt.Sprintf("%s:%s", name, alias),
+			fmt.Sprintf("%s:%s", name, name),
+			fmt.Sprintf("%s:%s", name, shortName),
+		)
+	}
+	for _, link := range sc.ExternalLinks {
+		parts := strings.SplitN(link, ":", 2)
+		if len(parts) == 1 {
+			parts = append(parts, parts[0])
+		}
+		hc.Links = append(hc.Links, strings.Join(parts, ":"))
+	}
+
+	for _, vf := range sc.VolumesFrom {
+		parts := strings.SplitN(vf, ":", 2)
+		if p.hasService(parts[0]) {
+			parts[0] = p.containerName(parts[0])
+		}
+		hc.VolumesFrom = append(hc.VolumesFrom, strings.Join(parts, ":"))
+	}
+
+	hc.NetworkMode = sc.Net
+	if strings.HasPrefix(sc.Net, "container:") {
+		name := strings.TrimPrefix(sc.Net, "container:")
+		if p.hasService(name) {
+			hc.NetworkMode = "container:" + p.containerName(name)
+		}
+	}
+
+	hc.Dns = []string(sc.DNS)
+	hc.DnsSearch = []string(sc.DNSSearch)
+	hc.SecurityOpt = []string(sc.SecurityOpt)
+	hc.Ulimits = []dockerclient.Ulimit(sc.Ulimits)
+	hc.Privileged.Value = bool(sc.Privileged)
+	hc.ReadonlyRootfs.Value = bool(sc.ReadOnly)
+
+	if hc.RestartPolicy, err = parseRestartPolicy(sc.Restart); err != nil {
+		return cc, err
+	}
+
+	if sc.LogDriver != "" {
+		hc.LogConfig.Type = sc.LogDriver
+		hc.LogConfig.Config = sc.LogOpt.Map()
+	}
+
+	return cc, nil
+}
+
+func (p *project) environment(sc serviceConfig) ([]string, error) {
+	var env keyValueList
+	for _, file := range sc.EnvFile {
+		vars, err := readEnvFile(p.resolvePath(file))
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, vars...)
+	}
+	env = append(env, sc.Environment...)
+
+	// Later entries win, but keep the position of the first occurrence.
+	var keys []string
+	values := map[string]string{}
+	for _, kv := range env.Env() {
+		parts := strings.SplitN(kv, "=", 2)
+		if _, ok := values[parts[0]]; !ok {
+			keys = append(keys, parts[0])
+		}
+		values[parts[0]] = parts[1]
+	}
+
+	var result []string
+	for _, k := range keys {
+		result = append(result, fmt.Sprintf("%s=%s", k, values[k]))
+	}
+	return result, nil
+}
+
+func readEnvFile(path string) (keyValueList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var vars keyValueList
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		vars = append(vars, line)
+	}
+
+	return vars, scanner.Err()
+}
+
+func (p *project) resolvePath(path string) string {
+	if strings.HasPrefix(path, "~") {
+		if home := os.Getenv("HOME"); home != "" {
+			path = home + path[1:]
+		}
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(p.dir, path)
+	}
+	return path
+}
+
+// parseVolume returns the container path for a volume entry and, for
+// entries mounting a host path, the bind to pass to Docker.
+func (p *project) parseVolume(spec string) (string, string, error) {
+	parts := strings.Split(spec, ":")
+	switch len(parts) {
+	case 1:
+		return parts[0], "", nil
+	case 2, 3:
+		parts[0] = p.resolvePath(parts[0])
+		return parts[1], strings.Join(parts, ":"), nil
+	}
+
+	return "", "", fmt.Errorf("Volume %s has incorrect format, should be external:internal[:mode]", spec)
+}
+
+func portKey(port string) string {
+	if strings.Contains(port, "/") {
+		return port
+	}
+	return port + "/tcp"
+}
+
+// parsePortSpec handles the forms compose accepts for ports: "3000",
+// "8000:8000", "127.0.0.1:8001:8001", "127.0.0.1::5000", with an optional
+// protocol suffix and port ranges.
+func parsePortSpec(spec string) (map[string]dockerclient.PortBinding, error) {
+	proto := "tcp"
+	if i := strings.Index(spec, "/"); i != -1 {
+		proto = spec[i+1:]
+		spec = spec[:i]
+	}
+
+	var hostIP, hostPorts, containerPorts string
+	parts := strings.Split(spec, ":")
+	switch len(parts) {
+	case 1:
+		containerPorts = parts[0]
+	case 2:
+		hostPorts, containerPorts = parts[0], parts[1]
+	case 3:
+		hostIP, hostPorts, containerPorts = parts[0], parts[1], parts[2]
+	default:
+		return nil, fmt.Errorf("Invalid port specification: %s", spec)
+	}
+
+	cStart, cEnd, err := parsePortRange(containerPorts)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid port specification: %s", spec)
+	}
+
+	hStart, hEnd := 0, 0
+	if hostPorts != "" {
+		if hStart, hEnd, err = parsePortRange(hostPorts); err != nil {
+			return nil, fmt.Errorf("Invalid port specification: %s", spec)
+		}
+		if hEnd-hStart != cEnd-cStart {
+			return nil, fmt.Errorf("Port ranges don't match in length: %s", spec)
+		}
+	}
+
+	bindings := map[string]dockerclient.PortBinding{}
+	for i := 0; i <= cEnd-cStart; i++ {
+		binding := dockerclient.PortBinding{HostIp: hostIP}
+		if hStart != 0 {
+			binding.HostPort = strconv.Itoa(hStart + i)
+		}
+		bindings[fmt.Sprintf("%d/%s", cStart+i, proto)] = binding
+	}
+
+	return bindings, nil
+}
+
+func parsePortRange(ports string) (int, int, error) {
+	parts := strings.SplitN(ports, "-", 2)
+	start, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(parts) == 1 {
+		return start, start, nil
+	}
+
+	end, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	if end < start {
+		return 0, 0, fmt.Errorf("invalid port range: %s", ports)
+	}
+	return start, end, nil
+}
+
+func parseMemory(limit memoryLimit) (int64, error) {
+	if limit == "" {
+		return 0, nil
+	}
+	return units.RAMInBytes(string(limit))
+}
+
+func parseRestartPolicy(policy string) (dockerclient.RestartPolicy, error) {
+	var rp dockerclient.RestartPolicy
+	if policy == "" {
+		return rp, nil
+	}
+
+	parts := strings.SplitN(policy, ":", 2)
+	rp.Name = parts[0]
+	if len(parts) == 2 {
+		count, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return rp, fmt.Errorf("Invalid restart policy: %s", policy)
+		}
+		rp.MaximumRetryCount = count
+	}
+
+	return rp, nil
+}
+
+// NormalizeProjectName turns a project name into the form used to prefix
+// container names, the same way docker-compose does.
+func NormalizeProjectName(name string) string {
+	return invalidProjectChar.ReplaceAllString(strings.ToLower(name), "")
+}
+
+// buildInfo reads the build context of the service and describes its build.
+func (p *project) buildInfo(svc service) (proxy.BuildInfo, buildContext, error) {
+	bc := svc.config.Build
+	info := proxy.BuildInfo{Dockerfile: bc.Dockerfile}
+	if info.Dockerfile == "" {
+		info.Dockerfile = svc.config.Dockerfile
+	}
+	if args := keyValueList(bc.Args.Env()).Map(); len(args) > 0 {
+		info.Args = args
+	}
+
+	ctx, err := loadBuildContext(p.resolvePath(bc.Context), info.Dockerfile)
+	if err != nil {
+		return info, ctx, err
+	}
+	info.ContextHash, err = ctx.hash()
+	return info, ctx, err
+}
+
+func (p *project) build(svc service, ctx buildContext, info proxy.BuildInfo, opts endpoint.BuildOptions, e endpoint.Endpoint) (string, error) {
+	fmt.Fprintf(Progress, "Building %s...\n", svc.name)
+
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(ctx.write(w))
+	}()
+	defer r.Close()
+
+	opts.Dockerfile = info.Dockerfile
+	opts.Args = info.Args
+	return e.BuildImage(r, p.imageName(svc), opts, Progress)
+}
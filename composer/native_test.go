@@ -0,0 +1,401 @@
+package composer
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/CenturyLinkLabs/zodiac/endpoint"
+	"github.com/CenturyLinkLabs/zodiac/proxy"
+	"github.com/samalba/dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockBuildEndpoint struct {
+	endpoint.Endpoint
+	builtImages []string
+	builtFiles  []string
+	opts        endpoint.BuildOptions
+}
+
+func (e *mockBuildEndpoint) BuildImage(r io.Reader, name string, opts endpoint.BuildOptions, out io.Writer) (string, error) {
+	e.builtImages = append(e.builtImages, name)
+	e.opts = opts
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return "def456", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		e.builtFiles = append(e.builtFiles, hdr.Name)
+	}
+}
+
+func writeComposeFile(t *testing.T, contents string) (string, func()) {
+	dir, err := ioutil.TempDir("", "zodiac-composer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "docker-compose.yml")
+	if err := ioutil.WriteFile(file, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return file, func() { os.RemoveAll(dir) }
+}
+
+func decodeConfig(t *testing.T, body []byte) endpoint.ContainerConfig {
+	var cc endpoint.ContainerConfig
+	if err := json.Unmarshal(body, &cc); err != nil {
+		t.Fatal(err)
+	}
+	return cc
+}
+
+func TestNativeRequests_FullFixture(t *testing.T) {
+	c := NewNativeComposer()
+	reqs, err := c.Requests(map[string]string{"file": "../fixtures/full.yml", "name": "zodiac"}, nil, true)
+
+	assert.NoError(t, err)
+	assert.Len(t, reqs, 2)
+	assert.Equal(t, "zodiac_foo_1", reqs[0].Name)
+	assert.Equal(t, "zodiac_bar_1", reqs[1].Name)
+
+	foo := decodeConfig(t, reqs[0].CreateOptions)
+	assert.Equal(t, "centurylink/simple-server", foo.Image)
+	assert.Equal(t, []string{"/bin/sh"}, foo.Entrypoint.Value)
+	assert.Equal(t, []string{"-c", "while true; do echo 'hello world - version 2' | nc -l -p 8888; done"}, foo.Cmd)
+	assert.Equal(t, []string{"FOO1=bar", "FOO2=bar"}, foo.Env)
+	assert.Equal(t, "bar", foo.Labels["foo"])
+	assert.Equal(t, "zodiac", foo.Labels["com.docker.compose.project"])
+	assert.Equal(t, "foo", foo.Labels["com.docker.compose.service"])
+	assert.Equal(t, []dockerclient.PortBinding{{HostPort: "8889"}}, foo.HostConfig.PortBindings["8888/tcp"])
+	_, exposed := foo.ExposedPorts["8888/tcp"]
+	assert.True(t, exposed)
+	_, volume := foo.Volumes["/data"]
+	assert.True(t, volume)
+	assert.Equal(t, "bridge", foo.HostConfig.NetworkMode)
+	assert.Equal(t, []string{"8.8.8.8"}, foo.HostConfig.Dns)
+	assert.Equal(t, "always", foo.HostConfig.RestartPolicy.Name)
+	assert.False(t, foo.HostConfig.Privileged.Value)
+	assert.True(t, foo.OpenStdin.Value)
+	assert.Equal(t, int64(73), foo.CpuShares)
+	assert.Equal(t, int64(1000000000), foo.Memory)
+	assert.Equal(t, "foo.com", foo.Domainname)
+
+	bar := decodeConfig(t, reqs[1].CreateOptions)
+	assert.Equal(t, []string{"BAR1=foo", "BAR2=foo"}, bar.Env)
+	assert.Equal(t, "barr", bar.Labels["foo"])
+	assert.Contains(t, bar.HostConfig.Links, "zodiac_foo_1:foo")
+	assert.Equal(t, []string{"label:user:USER", "label:role:ROLE"}, bar.HostConfig.SecurityOpt)
+	assert.Equal(t, "syslog", bar.HostConfig.LogConfig.Type)
+	assert.True(t, bar.HostConfig.ReadonlyRootfs.Value)
+	assert.Equal(t, "0,1", bar.Cpuset)
+}
+
+func TestNativeRequests_NormalizesProjectName(t *testing.T) {
+	c := NewNativeComposer()
+	reqs, err := c.Requests(map[string]string{"file": "../fixtures/webapp.yml", "name": "My-App"}, nil, true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "myapp_web_1", reqs[0].Name)
+	assert.Equal(t, "myapp_db_1", reqs[1].Name)
+}
+
+func TestNativeRequests_OrdersDependenciesFirst(t *testing.T) {
+	file, cleanup := writeComposeFile(t, `
+web:
+  image: web
+  links:
+    - db
+  volumes_from:
+    - data:ro
+db:
+  image: db
+  net: "container:data"
+data:
+  image: busybox
+`)
+	defer cleanup()
+
+	reqs, err := NewNativeComposer().Requests(map[string]string{"file": file, "name": "app"}, nil, true)
+
+	assert.NoError(t, err)
+	assert.Len(t, reqs, 3)
+	assert.Equal(t, "app_data_1", reqs[0].Name)
+	assert.Equal(t, "app_db_1", reqs[1].Name)
+	assert.Equal(t, "app_web_1", reqs[2].Name)
+
+	db := decodeConfig(t, reqs[1].CreateOptions)
+	assert.Equal(t, "container:app_data_1", db.HostConfig.NetworkMode)
+
+	web := decodeConfig(t, reqs[2].CreateOptions)
+	assert.Equal(t, []string{"app_data_1:ro"}, web.HostConfig.VolumesFrom)
+}
+
+func TestNativeRequests_CircularDependency(t *testing.T) {
+	file, cleanup := writeComposeFile(t, `
+web:
+  image: web
+  links:
+    - db
+db:
+  image: db
+  links:
+    - web
+`)
+	defer cleanup()
+
+	_, err := NewNativeComposer().Requests(map[string]string{"file": file}, nil, true)
+
+	assert.EqualError(t, err, "Circular import between web")
+}
+
+func TestNativeRequests_UnsupportedOption(t *testing.T) {
+	file, cleanup := writeComposeFile(t, `
+web:
+  image: web
+  extends:
+    file: common.yml
+`)
+	defer cleanup()
+
+	_, err := NewNativeComposer().Requests(map[string]string{"file": file}, nil, true)
+
+	assert.EqualError(t, err, "Unsupported config option for web service: 'extends'")
+}
+
+func TestNativeRequests_MissingImageAndBuild(t *testing.T) {
+	file, cleanup := writeComposeFile(t, `
+web:
+  command: echo hi
+`)
+	defer cleanup()
+
+	_, err := NewNativeComposer().Requests(map[string]string{"file": file}, nil, true)
+
+	assert.EqualError(t, err, "Service web has neither an image nor a build path specified. Exactly one must be provided.")
+}
+
+func TestNativeRequests_EnvFileAndVolumes(t *testing.T) {
+	file, cleanup := writeComposeFile(t, `
+web:
+  image: web
+  env_file: web.env
+  environment:
+    B: override
+  volumes:
+    - ./data:/data:ro
+`)
+	defer cleanup()
+	dir := filepath.Dir(file)
+	ioutil.WriteFile(filepath.Join(dir, "web.env"), []byte("# comment\nA=1\nB=2\n"), 0644)
+
+	reqs, err := NewNativeComposer().Requests(map[string]string{"file": file}, nil, true)
+
+	assert.NoError(t, err)
+	web := decodeConfig(t, reqs[0].CreateOptions)
+	assert.Equal(t, []string{"A=1", "B=override"}, web.Env)
+	assert.Equal(t, []string{filepath.Join(dir, "data") + ":/data:ro"}, web.HostConfig.Binds)
+	_, volume := web.Volumes["/data"]
+	assert.True(t, volume)
+}
+
+func TestNativeRequests_Ulimits(t *testing.T) {
+	file, cleanup := writeComposeFile(t, `
+web:
+  image: web
+  ulimits:
+    nproc: 65535
+    nofile:
+      soft: 20000
+      hard: 40000
+`)
+	defer cleanup()
+
+	reqs, err := NewNativeComposer().Requests(map[string]string{"file": file}, nil, true)
+
+	assert.NoError(t, err)
+	web := decodeConfig(t, reqs[0].CreateOptions)
+	assert.Equal(t, []dockerclient.Ulimit{
+		{Name: "nofile", Soft: 20000, Hard: 40000},
+		{Name: "nproc", Soft: 65535, Hard: 65535},
+	}, web.HostConfig.Ulimits)
+}
+
+func TestNativeRequests_InvalidUlimit(t *testing.T) {
+	file, cleanup := writeComposeFile(t, `
+web:
+  image: web
+  ulimits:
+    nofile:
+      soft: 20000
+`)
+	defer cleanup()
+
+	_, err := NewNativeComposer().Requests(map[string]string{"file": file}, nil, true)
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "ulimit nofile needs both a soft and a hard limit")
+}
+
+func TestNativeRequests_Build(t *testing.T) {
+	file, cleanup := writeComposeFile(t, `
+web:
+  build: app
+`)
+	defer cleanup()
+	dir := filepath.Join(filepath.Dir(file), "app")
+	os.Mkdir(dir, 0755)
+	ioutil.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM busybox\n"), 0644)
+
+	e := &mockBuildEndpoint{}
+	reqs, err := NewNativeComposer().Requests(map[string]string{"file": file, "name": "app"}, e, false)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"app_web"}, e.builtImages)
+	assert.Equal(t, []string{"Dockerfile"}, e.builtFiles)
+	assert.Equal(t, "app_web", decodeConfig(t, reqs[0].CreateOptions).Image)
+	assert.Equal(t, "def456", reqs[0].ImageID)
+}
+
+func TestNativeRequests_NoBuild(t *testing.T) {
+	file, cleanup := writeComposeFile(t, `
+web:
+  build: app
+`)
+	defer cleanup()
+
+	e := &mockBuildEndpoint{}
+	_, err := NewNativeComposer().Requests(map[string]string{"file": file}, e, true)
+
+	assert.NoError(t, err)
+	assert.Empty(t, e.builtImages)
+}
+
+func TestNativeBuild(t *testing.T) {
+	file, cleanup := writeComposeFile(t, `
+web:
+  build: app
+db:
+  image: postgres
+`)
+	defer cleanup()
+	dir := filepath.Join(filepath.Dir(file), "app")
+	os.Mkdir(dir, 0755)
+	ioutil.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM busybox\n"), 0644)
+
+	e := &mockBuildEndpoint{}
+	built, err := NewNativeComposer().Build(map[string]string{"file": file, "name": "app"}, e, func(string, proxy.BuildInfo) string { return "" })
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"app_web"}, e.builtImages)
+	assert.Equal(t, "def456", built["app_web_1"].ID)
+	assert.NotEmpty(t, built["app_web_1"].Build.ContextHash)
+}
+
+func TestNativeBuildInfos(t *testing.T) {
+	file, cleanup := writeComposeFile(t, `
+web:
+  build: app
+db:
+  image: postgres
+`)
+	defer cleanup()
+	dir := filepath.Join(filepath.Dir(file), "app")
+	os.Mkdir(dir, 0755)
+	ioutil.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM busybox\n"), 0644)
+
+	flags := map[string]string{"file": file, "name": "app"}
+	infos, err := NewNativeComposer().BuildInfos(flags)
+
+	assert.NoError(t, err)
+	assert.Len(t, infos, 1)
+	built, _ := NewNativeComposer().Build(flags, &mockBuildEndpoint{}, func(string, proxy.BuildInfo) string { return "" })
+	assert.Equal(t, built["app_web_1"].Build, infos["app_web_1"])
+}
+
+func TestNativeBuild_Reuse(t *testing.T) {
+	file, cleanup := writeComposeFile(t, `
+web:
+  build: app
+`)
+	defer cleanup()
+	dir := filepath.Join(filepath.Dir(file), "app")
+	os.Mkdir(dir, 0755)
+	ioutil.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM busybox\n"), 0644)
+
+	var reused proxy.BuildInfo
+	reuse := func(name string, b proxy.BuildInfo) string {
+		reused = b
+		return "abc123"
+	}
+
+	e := &mockBuildEndpoint{}
+	built, err := NewNativeComposer().Build(map[string]string{"file": file, "name": "app"}, e, reuse)
+
+	assert.NoError(t, err)
+	assert.Empty(t, e.builtImages)
+	assert.Equal(t, BuiltImage{ID: "abc123", Build: reused}, built["app_web_1"])
+}
+
+func TestNativeRequests_BuildOptions(t *testing.T) {
+	file, cleanup := writeComposeFile(t, `
+web:
+  build:
+    context: app
+    dockerfile: Dockerfile.prod
+    args:
+      VERSION: "1.2"
+`)
+	defer cleanup()
+	dir := filepath.Join(filepath.Dir(file), "app")
+	os.Mkdir(dir, 0755)
+	ioutil.WriteFile(filepath.Join(dir, "Dockerfile.prod"), []byte("FROM busybox\n"), 0644)
+
+	e := &mockBuildEndpoint{}
+	reqs, err := NewNativeComposer().Requests(map[string]string{"file": file, "name": "app", "no-cache": "true"}, e, false)
+
+	assert.NoError(t, err)
+	assert.Equal(t, endpoint.BuildOptions{
+		Dockerfile: "Dockerfile.prod",
+		Args:       map[string]string{"VERSION": "1.2"},
+		NoCache:    true,
+	}, e.opts)
+	assert.Equal(t, "Dockerfile.prod", reqs[0].Build.Dockerfile)
+	assert.Equal(t, map[string]string{"VERSION": "1.2"}, reqs[0].Build.Args)
+}
+
+func TestParsePortSpec(t *testing.T) {
+	b, err := parsePortSpec("127.0.0.1:8001-8002:9001-9002/udp")
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]dockerclient.PortBinding{
+		"9001/udp": {HostIp: "127.0.0.1", HostPort: "8001"},
+		"9002/udp": {HostIp: "127.0.0.1", HostPort: "8002"},
+	}, b)
+
+	b, err = parsePortSpec("3000")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]dockerclient.PortBinding{"3000/tcp": {}}, b)
+
+	_, err = parsePortSpec("8000-8001:9000")
+	assert.EqualError(t, err, "Port ranges don't match in length: 8000-8001:9000")
+}
+
+func TestSplitCommand(t *testing.T) {
+	args, err := splitCommand(`sh -c "echo 'hi there'" a\ b`)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"sh", "-c", "echo 'hi there'", "a b"}, args)
+
+	_, err = splitCommand(`echo "oops`)
+	assert.Error(t, err)
+}
@@ -0,0 +1,130 @@
+package endpoint
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/samalba/dockerclient"
+)
+
+const DefaultRegistry = "index.docker.io"
+
+var defaultAuthFiles = []string{"~/.docker/config.json", "~/.dockercfg"}
+
+// AuthConfigs holds registry credentials keyed by registry hostname.
+type AuthConfigs map[string]dockerclient.AuthConfig
+
+type authEntry struct {
+	Auth     string `json:"auth"`
+	Email    string `json:"email"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// LoadAuthConfigs reads the credentials saved by `docker login`. With no
+// path it looks in ~/.docker/config.json and then the older ~/.dockercfg,
+// and having neither is not an error.
+func LoadAuthConfigs(path string) (AuthConfigs, error) {
+	if path != "" {
+		return readAuthFile(resolveHomeDirectory(path))
+	}
+
+	for _, p := range defaultAuthFiles {
+		auths, err := readAuthFile(resolveHomeDirectory(p))
+		if os.IsNotExist(err) {
+			continue
+		}
+		return auths, err
+	}
+
+	return AuthConfigs{}, nil
+}
+
+func readAuthFile(path string) (AuthConfigs, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// config.json nests the entries under "auths", .dockercfg doesn't.
+	var config struct {
+		Auths map[string]authEntry `json:"auths"`
+	}
+	if err := json.Unmarshal(contents, &config); err != nil {
+		return nil, fmt.Errorf("can't read registry credentials from %s: %s", path, err)
+	}
+
+	entries := config.Auths
+	if entries == nil {
+		if err := json.Unmarshal(contents, &entries); err != nil {
+			return nil, fmt.Errorf("can't read registry credentials from %s: %s", path, err)
+		}
+	}
+
+	auths := AuthConfigs{}
+	for server, entry := range entries {
+		ac := dockerclient.AuthConfig{
+			Username: entry.Username,
+			Password: entry.Password,
+			Email:    entry.Email,
+		}
+
+		if entry.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				return nil, fmt.Errorf("invalid credentials for %s in %s", server, path)
+			}
+			parts := strings.SplitN(string(decoded), ":", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid credentials for %s in %s", server, path)
+			}
+			ac.Username, ac.Password = parts[0], parts[1]
+		}
+
+		auths[normalizeRegistry(server)] = ac
+	}
+
+	return auths, nil
+}
+
+// ForImage returns the credentials for the registry the image would be
+// pulled from, or nil if there are none.
+func (a AuthConfigs) ForImage(image string) *dockerclient.AuthConfig {
+	if ac, ok := a[RegistryHostname(image)]; ok {
+		return &ac
+	}
+	return nil
+}
+
+// RegistryHostname works out which registry an image name refers to, the
+// same way the Docker CLI does: the first path component is a registry if it
+// looks like a hostname, otherwise the image lives on the Docker Hub.
+func RegistryHostname(image string) string {
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) == 1 {
+		return DefaultRegistry
+	}
+
+	host := parts[0]
+	if !strings.ContainsAny(host, ".:") && host != "localhost" {
+		return DefaultRegistry
+	}
+
+	return normalizeRegistry(host)
+}
+
+func normalizeRegistry(server string) string {
+	server = strings.TrimPrefix(server, "https://")
+	server = strings.TrimPrefix(server, "http://")
+	server = strings.SplitN(server, "/", 2)[0]
+
+	switch server {
+	case "docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		return DefaultRegistry
+	}
+	return server
+}
@@ -0,0 +1,90 @@
+package endpoint
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeAuthFile(t *testing.T, contents string) (string, func()) {
+	dir, err := ioutil.TempDir("", "zodiac-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestLoadAuthConfigs_ConfigJSON(t *testing.T) {
+	path, cleanup := writeAuthFile(t, `{
+		"auths": {
+			"https://index.docker.io/v1/": {"auth": "dXNlcjpwYXNz", "email": "user@example.com"},
+			"registry.example.com:5000": {"auth": "b3RoZXI6c2VjcmV0"}
+		}
+	}`)
+	defer cleanup()
+
+	auths, err := LoadAuthConfigs(path)
+
+	assert.NoError(t, err)
+	assert.Len(t, auths, 2)
+	assert.Equal(t, "user", auths["index.docker.io"].Username)
+	assert.Equal(t, "pass", auths["index.docker.io"].Password)
+	assert.Equal(t, "user@example.com", auths["index.docker.io"].Email)
+	assert.Equal(t, "other", auths["registry.example.com:5000"].Username)
+	assert.Equal(t, "secret", auths["registry.example.com:5000"].Password)
+}
+
+func TestLoadAuthConfigs_LegacyDockercfg(t *testing.T) {
+	path, cleanup := writeAuthFile(t, `{
+		"https://index.docker.io/v1/": {"auth": "dXNlcjpwYXNz", "email": "user@example.com"}
+	}`)
+	defer cleanup()
+
+	auths, err := LoadAuthConfigs(path)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "user", auths["index.docker.io"].Username)
+}
+
+func TestLoadAuthConfigs_BadAuth(t *testing.T) {
+	path, cleanup := writeAuthFile(t, `{"auths": {"example.com": {"auth": "bm9jb2xvbg=="}}}`)
+	defer cleanup()
+
+	_, err := LoadAuthConfigs(path)
+
+	assert.EqualError(t, err, "invalid credentials for example.com in "+path)
+}
+
+func TestLoadAuthConfigs_MissingOverride(t *testing.T) {
+	_, err := LoadAuthConfigs("/does/not/exist.json")
+
+	assert.Error(t, err)
+}
+
+func TestAuthConfigsForImage(t *testing.T) {
+	auths := AuthConfigs{
+		"index.docker.io":           {Username: "hub"},
+		"registry.example.com:5000": {Username: "private"},
+	}
+
+	assert.Equal(t, "hub", auths.ForImage("busybox").Username)
+	assert.Equal(t, "hub", auths.ForImage("org/app:latest").Username)
+	assert.Equal(t, "hub", auths.ForImage("docker.io/org/app").Username)
+	assert.Equal(t, "private", auths.ForImage("registry.example.com:5000/app").Username)
+	assert.Nil(t, auths.ForImage("localhost:5000/app"))
+}
+
+func TestRegistryHostname(t *testing.T) {
+	assert.Equal(t, "index.docker.io", RegistryHostname("ubuntu"))
+	assert.Equal(t, "index.docker.io", RegistryHostname("centurylink/zodiac"))
+	assert.Equal(t, "quay.io", RegistryHostname("quay.io/org/app"))
+	assert.Equal(t, "localhost", RegistryHostname("localhost/app"))
+}
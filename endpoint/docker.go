@@ -2,17 +2,23 @@ package endpoint
 
 import (
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/samalba/dockerclient"
 )
 
+// StopTimeout is the number of seconds a container is given to exit after
+// being asked to stop, before it is killed.
+const StopTimeout = 10
+
 func NewEndpoint(endpointOpts EndpointOptions) (Endpoint, error) {
 	tlsConfig, err := getTlsConfig(endpointOpts)
 	if err != nil {
@@ -25,16 +31,18 @@ func NewEndpoint(endpointOpts EndpointOptions) (Endpoint, error) {
 	}
 
 	return &DockerEndpoint{
-		url:       endpointOpts.Host,
-		client:    c,
-		tlsConfig: tlsConfig,
+		url:         endpointOpts.Host,
+		client:      c,
+		tlsConfig:   tlsConfig,
+		retryPolicy: DefaultRetryPolicy,
 	}, nil
 }
 
 type DockerEndpoint struct {
-	url       string
-	client    dockerclient.Client
-	tlsConfig *tls.Config
+	url         string
+	client      dockerclient.Client
+	tlsConfig   *tls.Config
+	retryPolicy RetryPolicy
 }
 
 // TODO: can we ditch this? Should always have it on the client
@@ -58,34 +66,67 @@ func (e *DockerEndpoint) Name() string {
 }
 
 func (e *DockerEndpoint) StartContainer(name string, cc ContainerConfig) error {
-	dcc, _ := translateContainerConfig(cc)
+	dcc, err := translateContainerConfig(cc)
+	if err != nil {
+		return fmt.Errorf("invalid config for %s: %s", name, err)
+	}
+
+	policy := e.retryPolicy
+	if policy.MaxAttempts < 1 {
+		policy = DefaultRetryPolicy
+	}
+
 	var id string
-	for {
-		cid, err := e.client.CreateContainer(&dcc, name)
+	for attempt := 1; ; attempt++ {
+		id, err = e.client.CreateContainer(&dcc, name)
 		if err == nil {
-			id = cid
 			break
-		} else {
-			log.Infof("Problem creating container: ", err)
-			log.Infof("Retrying create...")
-			time.Sleep(5 * time.Second)
 		}
+		if !isTransient(err) || attempt >= policy.MaxAttempts {
+			return CreateError{Name: name, Attempts: attempt, Err: err}
+		}
+
+		delay := policy.delay(attempt)
+		log.Infof("Problem creating %s: %s", name, err)
+		log.Infof("Retrying create in %s...", delay)
+		time.Sleep(delay)
 	}
 
 	log.Infof("%s created as %s", name, id)
 
 	if err := e.client.StartContainer(id, nil); err != nil {
-		log.Fatal("problem starting: ", err)
+		return StartError{Name: name, ID: id, Err: err}
+	}
+	return nil
+}
+
+// CreateContainer creates a container without starting it. Unlike
+// StartContainer it doesn't retry, and a name that is already taken is
+// reported as ErrNameInUse straight away.
+func (e *DockerEndpoint) CreateContainer(name string, cc ContainerConfig) error {
+	dcc, err := translateContainerConfig(cc)
+	if err != nil {
+		return fmt.Errorf("invalid config for %s: %s", name, err)
+	}
+
+	if _, err := e.client.CreateContainer(&dcc, name); err != nil {
+		if de, ok := err.(dockerclient.Error); ok && de.StatusCode == 409 {
+			return ErrNameInUse
+		}
+		return CreateError{Name: name, Attempts: 1, Err: err}
 	}
 	return nil
 }
 
-func (e *DockerEndpoint) ResolveImage(name string) (string, error) {
+func (e *DockerEndpoint) ResolveImage(name string, auth *dockerclient.AuthConfig) (string, error) {
 	imageInfo, err := e.client.InspectImage(name)
 	if err != nil {
 
 		if err == dockerclient.ErrNotFound {
-			if err := e.client.PullImage(name, nil); err != nil {
+			if err := e.client.PullImage(name, auth); err != nil {
+				if auth == nil && isAuthError(err) {
+					return "", fmt.Errorf("%s (no credentials found for %s, try `docker login`)", err, RegistryHostname(name))
+				}
 				return "", err
 			}
 			imageInfo, err = e.client.InspectImage(name)
@@ -100,37 +141,234 @@ func (e *DockerEndpoint) ResolveImage(name string) (string, error) {
 	return imageInfo.Id, nil
 }
 
-func (e *DockerEndpoint) BuildImage(buildContext io.Reader, svcName string) error {
-	scheme := "https"
-	if e.tlsConfig == nil {
-		scheme = "http"
+// LookupImage is the ID of an image that is already on the endpoint. Unlike
+// ResolveImage it never pulls, a missing image is dockerclient.ErrNotFound.
+func (e *DockerEndpoint) LookupImage(name string) (string, error) {
+	imageInfo, err := e.client.InspectImage(name)
+	if err != nil {
+		return "", err
 	}
-	url := fmt.Sprintf("%s://%s/%s/build?pull=False&nocache=False&q=False&t=%s&forcerm=False&rm=True", scheme, e.Host(), dockerclient.APIVersion, svcName)
-	req, err := http.NewRequest("POST", url, buildContext)
+	return imageInfo.Id, nil
+}
+
+// ImageDigests lists the registry digests of an image as repo@sha256:...
+// references. An image that was built rather than pulled has none.
+func (e *DockerEndpoint) ImageDigests(name string) ([]string, error) {
+	resp, err := e.httpClient().Get(e.apiURL(fmt.Sprintf("/images/%s/json", name)))
 	if err != nil {
-		return err
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, dockerclient.ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("can't inspect image %s: %s", name, resp.Status)
+	}
+
+	var info struct {
+		RepoDigests []string
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+	return info.RepoDigests, nil
+}
+
+func (e *DockerEndpoint) ListImages() ([]*dockerclient.Image, error) {
+	return e.client.ListImages()
+}
+
+// RemoveImage removes an image unless a container still uses it.
+func (e *DockerEndpoint) RemoveImage(name string) error {
+	_, err := e.client.RemoveImage(name)
+	return err
+}
+
+// TagImage tags an image, moving the tag if another image has it.
+func (e *DockerEndpoint) TagImage(id, repo, tag string) error {
+	return e.client.TagImage(id, repo, tag, true)
+}
+
+func isAuthError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "authentication required") || strings.Contains(msg, "unauthorized")
+}
+
+// BuildImage builds the context as the named image. The build output is
+// copied to out as it streams in, and the ID of the built image is returned.
+func (e *DockerEndpoint) BuildImage(buildContext io.Reader, name string, opts BuildOptions, out io.Writer) (string, error) {
+	query, err := opts.query(name)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest("POST", e.apiURL("/build?"+query.Encode()), buildContext)
+	if err != nil {
+		return "", err
 	}
 	req.Header.Set("content-type", "application/tar")
-	tr := &http.Transport{
-		TLSClientConfig: e.tlsConfig,
+	resp, err := e.httpClient().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return "", BuildError{Name: name, Message: strings.TrimSpace(string(body))}
 	}
-	c := http.Client{Transport: tr}
-	resp, err := c.Do(req)
 
+	return readBuildStream(resp.Body, name, out)
+}
+
+// BuildError is a build that failed, with the message the engine gave.
+type BuildError struct {
+	Name    string
+	Message string
+}
+
+func (e BuildError) Error() string {
+	return fmt.Sprintf("Building %s failed: %s", e.Name, e.Message)
+}
+
+type buildMessage struct {
+	Stream      string
+	Error       string
+	ErrorDetail struct {
+		Message string
+	}
+	Aux struct {
+		ID string
+	}
+}
+
+// readBuildStream follows the JSON messages of a build, which fails with an
+// error message in the stream even though the response was a success.
+func readBuildStream(r io.Reader, name string, out io.Writer) (string, error) {
+	var id string
+	dec := json.NewDecoder(r)
+	for {
+		var msg buildMessage
+		if err := dec.Decode(&msg); err == io.EOF {
+			break
+		} else if err != nil {
+			return "", err
+		}
+
+		if msg.Error != "" || msg.ErrorDetail.Message != "" {
+			message := msg.ErrorDetail.Message
+			if message == "" {
+				message = msg.Error
+			}
+			return "", BuildError{Name: name, Message: strings.TrimSpace(message)}
+		}
+
+		io.WriteString(out, msg.Stream)
+		if msg.Aux.ID != "" {
+			id = msg.Aux.ID
+		} else if strings.HasPrefix(msg.Stream, "Successfully built ") {
+			id = strings.TrimSpace(strings.TrimPrefix(msg.Stream, "Successfully built "))
+		}
+	}
+
+	if id == "" {
+		return "", BuildError{Name: name, Message: "the build didn't report an image ID"}
+	}
+	return id, nil
+}
+
+// Exec runs cmd in the named container, waits for it to finish and returns
+// its exit code.
+func (e *DockerEndpoint) Exec(name string, cmd []string) (int, error) {
+	id, err := e.client.Exec(&dockerclient.ExecConfig{
+		Container:    name,
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
 	if err != nil {
-		return err
+		return -1, err
 	}
 
-	// NOTE: this is here to make sure it finishes
-	_, err = ioutil.ReadAll(resp.Body)
-	return err
+	// dockerclient can't inspect an exec, so ask the API directly.
+	resp, err := e.httpClient().Get(e.apiURL(fmt.Sprintf("/exec/%s/json", id)))
+	if err != nil {
+		return -1, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return -1, fmt.Errorf("can't inspect exec %s: %s", id, resp.Status)
+	}
+
+	var info struct {
+		Running  bool
+		ExitCode int
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return -1, err
+	}
+	if info.Running {
+		return -1, fmt.Errorf("%s is still running", strings.Join(cmd, " "))
+	}
+
+	return info.ExitCode, nil
+}
+
+func (e *DockerEndpoint) apiURL(path string) string {
+	scheme := "https"
+	if e.tlsConfig == nil {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s/%s%s", scheme, e.Host(), dockerclient.APIVersion, path)
+}
+
+func (e *DockerEndpoint) httpClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{TLSClientConfig: e.tlsConfig},
+	}
 }
 
 func (e *DockerEndpoint) InspectContainer(name string) (*dockerclient.ContainerInfo, error) {
 	return e.client.InspectContainer(name)
 }
 
+func (e *DockerEndpoint) InspectContainerDetails(name string) (*ContainerDetails, error) {
+	resp, err := e.httpClient().Get(e.apiURL(fmt.Sprintf("/containers/%s/json", name)))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, dockerclient.ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("can't inspect %s: %s", name, resp.Status)
+	}
+
+	var details ContainerDetails
+	if err := json.NewDecoder(resp.Body).Decode(&details); err != nil {
+		return nil, err
+	}
+	return &details, nil
+}
+
+// ListContainers lists every container on the endpoint, stopped ones too.
+func (e *DockerEndpoint) ListContainers() ([]dockerclient.Container, error) {
+	return e.client.ListContainers(true, false, "")
+}
+
 func (e *DockerEndpoint) RemoveContainer(name string) error {
 	//TODO: be more graceful
 	return e.client.RemoveContainer(name, true, false)
 }
+
+func (e *DockerEndpoint) StopContainer(name string) error {
+	return e.client.StopContainer(name, StopTimeout)
+}
+
+func (e *DockerEndpoint) RenameContainer(oldName, newName string) error {
+	return e.client.RenameContainer(oldName, newName)
+}
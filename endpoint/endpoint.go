@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"io"
 	"io/ioutil"
+	"net/url"
 	"os/user"
 	"strings"
 
@@ -83,11 +84,72 @@ type Endpoint interface {
 	Version() (string, error)
 	Name() string
 	Host() string
-	BuildImage(io.Reader, string) error
-	ResolveImage(string) (string, error)
+	BuildImage(buildContext io.Reader, name string, opts BuildOptions, out io.Writer) (string, error)
+	ResolveImage(string, *dockerclient.AuthConfig) (string, error)
+	LookupImage(string) (string, error)
+	ImageDigests(string) ([]string, error)
+	ListImages() ([]*dockerclient.Image, error)
+	RemoveImage(name string) error
+	TagImage(id, repo, tag string) error
 	StartContainer(name string, cc ContainerConfig) error
+	CreateContainer(name string, cc ContainerConfig) error
 	InspectContainer(name string) (*dockerclient.ContainerInfo, error)
+	InspectContainerDetails(name string) (*ContainerDetails, error)
+	ListContainers() ([]dockerclient.Container, error)
 	RemoveContainer(name string) error
+	StopContainer(name string) error
+	RenameContainer(oldName, newName string) error
+	Exec(name string, cmd []string) (int, error)
+}
+
+// BuildOptions are the parameters of an image build. Params are any other
+// parameters of the build API, which are passed on as they are.
+type BuildOptions struct {
+	Dockerfile string
+	Args       map[string]string
+	NoCache    bool
+	Pull       bool
+	Params     url.Values
+}
+
+func (o BuildOptions) query(name string) (url.Values, error) {
+	q := url.Values{"rm": {"True"}}
+	for k, v := range o.Params {
+		q[k] = v
+	}
+	q.Set("t", name)
+
+	if o.Dockerfile != "" {
+		q.Set("dockerfile", o.Dockerfile)
+	}
+	if len(o.Args) > 0 {
+		args, err := json.Marshal(o.Args)
+		if err != nil {
+			return nil, err
+		}
+		q.Set("buildargs", string(args))
+	}
+	if o.NoCache {
+		q.Set("nocache", "True")
+	}
+	if o.Pull {
+		q.Set("pull", "True")
+	}
+	return q, nil
+}
+
+// ContainerDetails is a container's inspect output including the fields
+// dockerclient doesn't decode.
+type ContainerDetails struct {
+	dockerclient.ContainerInfo
+	RestartCount int
+	// Node is the Swarm node running the container, nil on a Docker host.
+	Node *SwarmNode
+}
+
+type SwarmNode struct {
+	Name string
+	Addr string
 }
 
 func translateContainerConfig(cc ContainerConfig) (dockerclient.ContainerConfig, error) {
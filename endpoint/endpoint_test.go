@@ -1,9 +1,14 @@
 package endpoint
 
 import (
+	"bytes"
 	"errors"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/CenturyLinkLabs/zodiac/fakeengine"
 	"github.com/samalba/dockerclient"
 	"github.com/samalba/dockerclient/mockclient"
 	"github.com/stretchr/testify/assert"
@@ -35,7 +40,7 @@ func TestResolveImage_WhenImageExisting(t *testing.T) {
 	c := mockclient.NewMockClient()
 	c.On("InspectImage", "Foo").Return(&dockerclient.ImageInfo{Id: "ytu678"}, nil)
 	e := DockerEndpoint{client: c}
-	imageID, err := e.ResolveImage("Foo")
+	imageID, err := e.ResolveImage("Foo", nil)
 
 	assert.NoError(t, err)
 	assert.Equal(t, "ytu678", imageID)
@@ -47,19 +52,48 @@ func TestResolveImage_WhenImageDoesNotExist(t *testing.T) {
 	c.On("PullImage", "Foo", mock.Anything).Return(nil)
 	c.On("InspectImage", "Foo").Return(&dockerclient.ImageInfo{Id: "yui890"}, nil).Once()
 	e := DockerEndpoint{client: c}
-	imageID, err := e.ResolveImage("Foo")
+	imageID, err := e.ResolveImage("Foo", nil)
 
 	assert.NoError(t, err)
 	assert.Equal(t, "yui890", imageID)
 	c.AssertExpectations(t)
 }
 
+func TestLookupImage_WhenImageDoesNotExist(t *testing.T) {
+	c := mockclient.NewMockClient()
+	c.On("InspectImage", "Foo").Return(&dockerclient.ImageInfo{}, dockerclient.ErrNotFound)
+	e := DockerEndpoint{client: c}
+	imageID, err := e.LookupImage("Foo")
+
+	assert.Equal(t, dockerclient.ErrNotFound, err)
+	assert.Equal(t, "", imageID)
+	c.AssertExpectations(t)
+}
+
+func TestRemoveImage_Success(t *testing.T) {
+	c := mockclient.NewMockClient()
+	c.On("RemoveImage", "abc123").Return([]*dockerclient.ImageDelete{}, nil)
+	e := DockerEndpoint{client: c}
+
+	assert.NoError(t, e.RemoveImage("abc123"))
+	c.AssertExpectations(t)
+}
+
+func TestTagImage_Success(t *testing.T) {
+	c := mockclient.NewMockClient()
+	c.On("TagImage", "abc123", "zodiac/app/web", "3", true).Return(nil)
+	e := DockerEndpoint{client: c}
+
+	assert.NoError(t, e.TagImage("abc123", "zodiac/app/web", "3"))
+	c.AssertExpectations(t)
+}
+
 func TestResolveImage_WhenInitialInspectErrors(t *testing.T) {
 	c := mockclient.NewMockClient()
 	c.On("InspectImage", "Foo").Return(&dockerclient.ImageInfo{}, errors.New("oops"))
 
 	e := DockerEndpoint{client: c}
-	imageID, err := e.ResolveImage("Foo")
+	imageID, err := e.ResolveImage("Foo", nil)
 
 	assert.Equal(t, "", imageID)
 	assert.EqualError(t, err, "oops")
@@ -72,7 +106,7 @@ func TestResolveImage_WhenPullErrors(t *testing.T) {
 	c.On("PullImage", "Foo", mock.Anything).Return(errors.New("uh-oh"))
 
 	e := DockerEndpoint{client: c}
-	imageID, err := e.ResolveImage("Foo")
+	imageID, err := e.ResolveImage("Foo", nil)
 
 	assert.Equal(t, "", imageID)
 	assert.EqualError(t, err, "uh-oh")
@@ -86,7 +120,7 @@ func TestResolveImage_WhenSecondInspectErrors(t *testing.T) {
 	c.On("InspectImage", "Foo").Return(&dockerclient.ImageInfo{}, errors.New("whoops")).Once()
 
 	e := DockerEndpoint{client: c}
-	imageID, err := e.ResolveImage("Foo")
+	imageID, err := e.ResolveImage("Foo", nil)
 
 	assert.Equal(t, "", imageID)
 	assert.EqualError(t, err, "whoops")
@@ -106,6 +140,19 @@ func TestInspectContainer_Success(t *testing.T) {
 	c.AssertExpectations(t)
 }
 
+func TestListContainers_Success(t *testing.T) {
+	expected := []dockerclient.Container{{Id: "foo"}}
+	c := mockclient.NewMockClient()
+	c.On("ListContainers", true, false, "").Return(expected, nil)
+
+	e := DockerEndpoint{client: c}
+	containers, err := e.ListContainers()
+
+	assert.NoError(t, err)
+	assert.Equal(t, expected, containers)
+	c.AssertExpectations(t)
+}
+
 func TestRemoveContainer_Success(t *testing.T) {
 	c := mockclient.NewMockClient()
 	c.On("RemoveContainer", "foo", true, false).Return(nil)
@@ -116,3 +163,240 @@ func TestRemoveContainer_Success(t *testing.T) {
 	assert.NoError(t, err)
 	c.AssertExpectations(t)
 }
+
+var testRetryPolicy = RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}
+
+func TestStartContainer_Success(t *testing.T) {
+	c := mockclient.NewMockClient()
+	c.On("CreateContainer", mock.Anything, "foo").Return("abc123", nil).Once()
+	c.On("StartContainer", "abc123", (*dockerclient.HostConfig)(nil)).Return(nil)
+
+	e := DockerEndpoint{client: c, retryPolicy: testRetryPolicy}
+	err := e.StartContainer("foo", ContainerConfig{})
+
+	assert.NoError(t, err)
+	c.AssertExpectations(t)
+}
+
+func TestStartContainer_RetriesConflict(t *testing.T) {
+	c := mockclient.NewMockClient()
+	conflict := dockerclient.Error{StatusCode: 409, Status: "409 Conflict"}
+	c.On("CreateContainer", mock.Anything, "foo").Return("", conflict).Once()
+	c.On("CreateContainer", mock.Anything, "foo").Return("abc123", nil).Once()
+	c.On("StartContainer", "abc123", (*dockerclient.HostConfig)(nil)).Return(nil)
+
+	e := DockerEndpoint{client: c, retryPolicy: testRetryPolicy}
+	err := e.StartContainer("foo", ContainerConfig{})
+
+	assert.NoError(t, err)
+	c.AssertExpectations(t)
+}
+
+func TestStartContainer_GivesUpAfterMaxAttempts(t *testing.T) {
+	c := mockclient.NewMockClient()
+	unavailable := dockerclient.Error{StatusCode: 503, Status: "503 Service Unavailable"}
+	c.On("CreateContainer", mock.Anything, "foo").Return("", unavailable).Times(3)
+
+	e := DockerEndpoint{client: c, retryPolicy: testRetryPolicy}
+	err := e.StartContainer("foo", ContainerConfig{})
+
+	assert.Equal(t, CreateError{Name: "foo", Attempts: 3, Err: unavailable}, err)
+	c.AssertExpectations(t)
+}
+
+func TestStartContainer_DoesNotRetryMissingImage(t *testing.T) {
+	c := mockclient.NewMockClient()
+	c.On("CreateContainer", mock.Anything, "foo").Return("", dockerclient.ErrNotFound).Once()
+
+	e := DockerEndpoint{client: c, retryPolicy: testRetryPolicy}
+	err := e.StartContainer("foo", ContainerConfig{})
+
+	assert.EqualError(t, err, "can't create foo after 1 attempt(s): Not found")
+	c.AssertExpectations(t)
+}
+
+func TestStartContainer_StartFails(t *testing.T) {
+	c := mockclient.NewMockClient()
+	c.On("CreateContainer", mock.Anything, "foo").Return("abc123", nil)
+	c.On("StartContainer", "abc123", (*dockerclient.HostConfig)(nil)).Return(errors.New("port is already allocated"))
+
+	e := DockerEndpoint{client: c, retryPolicy: testRetryPolicy}
+	err := e.StartContainer("foo", ContainerConfig{})
+
+	assert.Equal(t, StartError{Name: "foo", ID: "abc123", Err: errors.New("port is already allocated")}, err)
+	assert.EqualError(t, err, "can't start foo: port is already allocated")
+}
+
+func TestCreateContainer_NameInUse(t *testing.T) {
+	c := mockclient.NewMockClient()
+	conflict := dockerclient.Error{StatusCode: 409, Status: "409 Conflict"}
+	c.On("CreateContainer", mock.Anything, "foo").Return("", conflict).Once()
+
+	e := DockerEndpoint{client: c, retryPolicy: testRetryPolicy}
+	err := e.CreateContainer("foo", ContainerConfig{})
+
+	assert.Equal(t, ErrNameInUse, err)
+	c.AssertExpectations(t)
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+
+	assert.Equal(t, time.Second, p.delay(1))
+	assert.Equal(t, 4*time.Second, p.delay(3))
+	assert.Equal(t, 5*time.Second, p.delay(4))
+}
+
+func TestStopContainer_Success(t *testing.T) {
+	c := mockclient.NewMockClient()
+	c.On("StopContainer", "foo", StopTimeout).Return(nil)
+
+	e := DockerEndpoint{client: c}
+	err := e.StopContainer("foo")
+
+	assert.NoError(t, err)
+	c.AssertExpectations(t)
+}
+
+func TestRenameContainer_Success(t *testing.T) {
+	c := mockclient.NewMockClient()
+	c.On("RenameContainer", "foo_next", "foo").Return(nil)
+
+	e := DockerEndpoint{client: c}
+	err := e.RenameContainer("foo_next", "foo")
+
+	assert.NoError(t, err)
+	c.AssertExpectations(t)
+}
+
+func TestExec(t *testing.T) {
+	s := fakeengine.NewServer()
+	defer s.Close()
+	e, err := NewEndpoint(EndpointOptions{Host: s.URL})
+	assert.NoError(t, err)
+
+	code, err := e.Exec("web", []string{"true"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, code)
+
+	code, err = e.Exec(fakeengine.UnhealthyContainer, []string{"true"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, code)
+}
+
+func TestInspectContainerDetails(t *testing.T) {
+	s := fakeengine.NewServer()
+	defer s.Close()
+	e, err := NewEndpoint(EndpointOptions{Host: s.URL})
+	assert.NoError(t, err)
+
+	details, err := e.InspectContainerDetails("web")
+	assert.NoError(t, err)
+	assert.Equal(t, "abc123", details.Id)
+	assert.Equal(t, 2, details.RestartCount)
+	assert.Equal(t, "node-1", details.Node.Name)
+
+	_, err = e.InspectContainerDetails(fakeengine.MissingContainer)
+	assert.Equal(t, dockerclient.ErrNotFound, err)
+}
+
+func TestBuildImage(t *testing.T) {
+	s := fakeengine.NewServer()
+	defer s.Close()
+	e, err := NewEndpoint(EndpointOptions{Host: s.URL})
+	assert.NoError(t, err)
+
+	var out bytes.Buffer
+	id, err := e.BuildImage(strings.NewReader(""), "app_web", BuildOptions{}, &out)
+
+	assert.NoError(t, err)
+	assert.Equal(t, fakeengine.BuiltImageID, id)
+	assert.Equal(t, "Step 0 : FROM busybox\nSuccessfully built "+fakeengine.BuiltImageID+"\n", out.String())
+}
+
+func TestBuildImage_Failure(t *testing.T) {
+	s := fakeengine.NewServer()
+	defer s.Close()
+	e, err := NewEndpoint(EndpointOptions{Host: s.URL})
+	assert.NoError(t, err)
+
+	var out bytes.Buffer
+	id, err := e.BuildImage(strings.NewReader(""), fakeengine.BrokenBuild, BuildOptions{}, &out)
+
+	assert.Equal(t, "", id)
+	assert.EqualError(t, err, "Building broken failed: The command '/bin/sh -c false' returned a non-zero code: 1")
+	assert.Equal(t, "Step 0 : FROM busybox\nStep 1 : RUN false\n", out.String())
+}
+
+func TestBuildOptionsQuery(t *testing.T) {
+	opts := BuildOptions{
+		Dockerfile: "Dockerfile.prod",
+		Args:       map[string]string{"VERSION": "1.2"},
+		NoCache:    true,
+		Params:     url.Values{"nocache": {"False"}, "forcerm": {"True"}, "t": {"ignored"}},
+	}
+
+	q, err := opts.query("app_web")
+
+	assert.NoError(t, err)
+	assert.Equal(t, url.Values{
+		"rm":         {"True"},
+		"forcerm":    {"True"},
+		"t":          {"app_web"},
+		"dockerfile": {"Dockerfile.prod"},
+		"buildargs":  {`{"VERSION":"1.2"}`},
+		"nocache":    {"True"},
+	}, q)
+}
+
+func TestImageDigests(t *testing.T) {
+	s := fakeengine.NewServer()
+	defer s.Close()
+	e, err := NewEndpoint(EndpointOptions{Host: s.URL})
+	assert.NoError(t, err)
+
+	digests, err := e.ImageDigests("library/redis:3")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"library/redis@" + fakeengine.ImageDigest}, digests)
+
+	_, err = e.ImageDigests(fakeengine.PrivateOrg + "/app")
+	assert.Equal(t, dockerclient.ErrNotFound, err)
+}
+
+func TestResolveImage_PrivateImageWithoutAuth(t *testing.T) {
+	s := fakeengine.NewServer()
+	defer s.Close()
+	e, err := NewEndpoint(EndpointOptions{Host: s.URL})
+	assert.NoError(t, err)
+
+	imageID, err := e.ResolveImage(fakeengine.PrivateOrg+"/app", nil)
+
+	assert.Equal(t, "", imageID)
+	assert.EqualError(t, err, "authentication required (no credentials found for index.docker.io, try `docker login`)")
+}
+
+func TestResolveImage_PrivateImageWithBadAuth(t *testing.T) {
+	s := fakeengine.NewServer()
+	defer s.Close()
+	e, err := NewEndpoint(EndpointOptions{Host: s.URL})
+	assert.NoError(t, err)
+
+	auth := &dockerclient.AuthConfig{Username: fakeengine.PrivateUsername, Password: "wrong"}
+	imageID, err := e.ResolveImage(fakeengine.PrivateOrg+"/app", auth)
+
+	assert.Equal(t, "", imageID)
+	assert.EqualError(t, err, "authentication required")
+}
+
+func TestResolveImage_PrivateImageWithAuth(t *testing.T) {
+	s := fakeengine.NewServer()
+	defer s.Close()
+	e, err := NewEndpoint(EndpointOptions{Host: s.URL})
+	assert.NoError(t, err)
+
+	auth := &dockerclient.AuthConfig{Username: fakeengine.PrivateUsername, Password: fakeengine.PrivatePassword}
+	imageID, err := e.ResolveImage(fakeengine.PrivateOrg+"/app", auth)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "abc123", imageID)
+}
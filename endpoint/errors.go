@@ -0,0 +1,76 @@
+package endpoint
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/samalba/dockerclient"
+)
+
+// A RetryPolicy says how often creating a container is retried after a
+// transient failure, such as the name still being held by a container that
+// is being removed, or the daemon answering with a 5xx.
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	Backoff:     time.Second,
+	MaxBackoff:  10 * time.Second,
+}
+
+// delay is how long to wait before the given retry, doubling each time.
+func (p RetryPolicy) delay(retry int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < retry; i++ {
+		d *= 2
+		if p.MaxBackoff > 0 && d > p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+	return d
+}
+
+// ErrNameInUse is returned by CreateContainer when a container of that name
+// already exists.
+var ErrNameInUse = errors.New("container name already in use")
+
+// A CreateError is returned when a container couldn't be created.
+type CreateError struct {
+	Name     string
+	Attempts int
+	Err      error
+}
+
+func (e CreateError) Error() string {
+	return fmt.Sprintf("can't create %s after %d attempt(s): %s", e.Name, e.Attempts, e.Err)
+}
+
+// A StartError is returned when a container was created but couldn't be
+// started. The container is left in place for the caller to remove.
+type StartError struct {
+	Name string
+	ID   string
+	Err  error
+}
+
+func (e StartError) Error() string {
+	return fmt.Sprintf("can't start %s: %s", e.Name, e.Err)
+}
+
+// isTransient reports whether an error from the Docker API might go away if
+// the request is retried.
+func isTransient(err error) bool {
+	switch err := err.(type) {
+	case dockerclient.Error:
+		return err.StatusCode == 409 || err.StatusCode >= 500
+	case net.Error:
+		return true
+	}
+	return false
+}
@@ -1,29 +1,67 @@
 package fakeengine
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/gorilla/mux"
 	"github.com/samalba/dockerclient"
 )
 
+// Images in the private org can only be pulled with these credentials, and
+// aren't present until they have been.
+const (
+	PrivateOrg      = "privateorg"
+	PrivateUsername = "zodiac"
+	PrivatePassword = "s3cret"
+)
+
+// The registry digest of every image, which is recorded as pulled from the
+// repository it was inspected by.
+const ImageDigest = "sha256:8e2b7c5a9f1d3e6b0a4c7d2f5e8b1a3c6d9f0e2b5a7c4d1e8f3b6a9c2d5e7f0a"
+
+// Images built with this name fail to build, others are built as
+// BuiltImageID.
+const (
+	BrokenBuild  = "broken"
+	BuiltImageID = "def456"
+)
+
+// Commands exec'd in this container fail.
+const UnhealthyContainer = "unhealthy"
+
+// This container doesn't exist.
+const MissingContainer = "missing"
+
 func init() {
 	log.SetLevel(log.DebugLevel)
 }
 
 func NewServer() *httptest.Server {
+	images := &privateImages{pulled: map[string]bool{}}
+
 	r := mux.NewRouter()
 	baseURL := "/" + dockerclient.APIVersion
 	r.HandleFunc(baseURL+"/version", handlerGetVersion).Methods("GET")
+	r.HandleFunc(baseURL+"/images/create", images.handleCreateImage).Methods("POST")
 	r.HandleFunc(baseURL+"/images/{name}/json", handleInspectImage).Methods("GET")
 	r.HandleFunc(baseURL+"/containers/{name}/json", handleInspectContainer).Methods("GET")
+	r.HandleFunc(baseURL+"/images/"+PrivateOrg+"/{name}/json", images.handleInspectImage).Methods("GET")
 	r.HandleFunc(baseURL+"/images/{org}/{name}/json", handleInspectImage).Methods("GET")
+	r.HandleFunc(baseURL+"/images/{name:.*}/tag", handleTagImage).Methods("POST")
+	r.HandleFunc(baseURL+"/build", handleBuild).Methods("POST")
 	r.HandleFunc(baseURL+"/containers/create", handleCreateContainer).Methods("POST")
 	r.HandleFunc(baseURL+"/containers/{id}", handleDeleteContainer).Methods("DELETE")
 	r.HandleFunc(baseURL+"/containers/{id}/start", handleStartContainer).Methods("POST")
+	r.HandleFunc("/containers/{name}/exec", handleCreateExec).Methods("POST")
+	r.HandleFunc("/exec/{id}/start", handleStartExec).Methods("POST")
+	r.HandleFunc(baseURL+"/exec/{id}/json", handleInspectExec).Methods("GET")
 	r.HandleFunc("/{rest:.*}", catchAll)
 	return httptest.NewServer(handlerAccessLog(r))
 }
@@ -57,17 +95,74 @@ func handlerGetVersion(w http.ResponseWriter, r *http.Request) {
 }
 
 func handleInspectImage(w http.ResponseWriter, r *http.Request) {
+	repo := strings.TrimPrefix(r.URL.Path, "/"+dockerclient.APIVersion+"/images/")
+	repo = strings.TrimSuffix(repo, "/json")
+	if i := strings.LastIndex(repo, ":"); i >= 0 {
+		repo = repo[:i]
+	}
+
 	writeHeaders(w, 200)
-	body := `{
-		"Id": "abc123"
-	}`
+	body := fmt.Sprintf(`{
+		"Id": "abc123",
+		"RepoDigests": ["%s@%s"]
+	}`, repo, ImageDigest)
 	w.Write([]byte(body))
 }
 
+type privateImages struct {
+	sync.Mutex
+	pulled map[string]bool
+}
+
+func (p *privateImages) handleInspectImage(w http.ResponseWriter, r *http.Request) {
+	p.Lock()
+	defer p.Unlock()
+
+	if !p.pulled[PrivateOrg+"/"+mux.Vars(r)["name"]] {
+		writeHeaders(w, 404)
+		w.Write([]byte(`{"message": "No such image"}`))
+		return
+	}
+	handleInspectImage(w, r)
+}
+
+func (p *privateImages) handleCreateImage(w http.ResponseWriter, r *http.Request) {
+	image := r.URL.Query().Get("fromImage")
+	if !strings.HasPrefix(image, PrivateOrg+"/") {
+		writeHeaders(w, 200)
+		w.Write([]byte(`{"status": "Download complete"}`))
+		return
+	}
+
+	var auth dockerclient.AuthConfig
+	decoded, _ := base64.URLEncoding.DecodeString(r.Header.Get("X-Registry-Auth"))
+	json.Unmarshal(decoded, &auth)
+	if auth.Username != PrivateUsername || auth.Password != PrivatePassword {
+		writeHeaders(w, 401)
+		w.Write([]byte(`{"error": "authentication required"}`))
+		return
+	}
+
+	p.Lock()
+	p.pulled[image] = true
+	p.Unlock()
+
+	writeHeaders(w, 200)
+	w.Write([]byte(`{"status": "Download complete"}`))
+}
+
 func handleInspectContainer(w http.ResponseWriter, r *http.Request) {
+	if mux.Vars(r)["name"] == MissingContainer {
+		writeHeaders(w, 404)
+		w.Write([]byte(`{"message": "No such container"}`))
+		return
+	}
+
 	writeHeaders(w, 200)
 	body := `{
-		"Id": "abc123"
+		"Id": "abc123",
+		"RestartCount": 2,
+		"Node": {"Name": "node-1", "Addr": "10.0.0.1:2375"}
 	}`
 	w.Write([]byte(body))
 }
@@ -81,6 +176,21 @@ func handleCreateContainer(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(body))
 }
 
+func handleBuild(w http.ResponseWriter, r *http.Request) {
+	writeHeaders(w, 200)
+	w.Write([]byte(`{"stream": "Step 0 : FROM busybox\n"}`))
+	if r.URL.Query().Get("t") == BrokenBuild {
+		w.Write([]byte(`{"stream": "Step 1 : RUN false\n"}
+{"errorDetail": {"code": 1, "message": "The command '/bin/sh -c false' returned a non-zero code: 1"}, "error": "The command '/bin/sh -c false' returned a non-zero code: 1"}`))
+		return
+	}
+	fmt.Fprintf(w, `{"stream": "Successfully built %s\n"}`, BuiltImageID)
+}
+
+func handleTagImage(w http.ResponseWriter, r *http.Request) {
+	writeHeaders(w, 201)
+}
+
 func handleDeleteContainer(w http.ResponseWriter, r *http.Request) {
 	writeHeaders(w, 204)
 }
@@ -89,6 +199,24 @@ func handleStartContainer(w http.ResponseWriter, r *http.Request) {
 	writeHeaders(w, 204)
 }
 
+func handleCreateExec(w http.ResponseWriter, r *http.Request) {
+	writeHeaders(w, 201)
+	w.Write([]byte(fmt.Sprintf(`{"Id": "exec-%s"}`, mux.Vars(r)["name"])))
+}
+
+func handleStartExec(w http.ResponseWriter, r *http.Request) {
+	writeHeaders(w, 200)
+}
+
+func handleInspectExec(w http.ResponseWriter, r *http.Request) {
+	exitCode := 0
+	if mux.Vars(r)["id"] == "exec-"+UnhealthyContainer {
+		exitCode = 1
+	}
+	writeHeaders(w, 200)
+	w.Write([]byte(fmt.Sprintf(`{"Running": false, "ExitCode": %d}`, exitCode)))
+}
+
 func catchAll(w http.ResponseWriter, r *http.Request) {
 	fmt.Printf("caught this URL: %s\n\n", r.URL)
 	writeHeaders(w, 200)
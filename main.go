@@ -7,7 +7,9 @@ import (
 	"strings"
 
 	"github.com/CenturyLinkLabs/zodiac/actions"
+	"github.com/CenturyLinkLabs/zodiac/composer"
 	"github.com/CenturyLinkLabs/zodiac/endpoint"
+	"github.com/CenturyLinkLabs/zodiac/proxy"
 	log "github.com/Sirupsen/logrus"
 	"github.com/codegangsta/cli"
 )
@@ -15,9 +17,27 @@ import (
 const version = "0.3.0"
 
 var (
-	commands []cli.Command
+	commands  []cli.Command
+	endpoints = &endpointList{}
 )
 
+// endpointList collects the --endpoint flags, which can be repeated or hold
+// a comma separated list.
+type endpointList []string
+
+func (l *endpointList) Set(value string) error {
+	for _, host := range strings.Split(value, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			*l = append(*l, host)
+		}
+	}
+	return nil
+}
+
+func (l *endpointList) String() string {
+	return strings.Join(*l, ",")
+}
+
 func init() {
 	log.SetLevel(log.WarnLevel)
 
@@ -27,11 +47,24 @@ func init() {
 			Usage:  "Verify the endpoint",
 			Action: createHandler(actions.Verify),
 			Before: requireCluster,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "name, n",
+					Usage:  "Specify a custom project name",
+					Value:  "zodiac",
+					EnvVar: "ZODIAC_PROJECT_NAME",
+				},
+				cli.StringFlag{
+					Name:  "file, f",
+					Usage: "Specify an alternate compose file",
+					Value: "docker-compose.yml",
+				},
+			},
 		},
 		{
 			Name:   "deploy",
 			Usage:  "Deploy a Docker compose template",
-			Action: createHandler(actions.Deploy),
+			Action: createMultiEndpointHandler(actions.Deploy),
 			Before: requireCluster,
 			Flags: []cli.Flag{
 				cli.StringFlag{
@@ -49,6 +82,73 @@ func init() {
 					Usage: "Specify an alternate compose file",
 					Value: "docker-compose.yml",
 				},
+				cli.StringFlag{
+					Name:  "scale",
+					Usage: "Number of containers to run per service, e.g. web=3,worker=2 (defaults to the previous deployment's counts)",
+				},
+				cli.StringFlag{
+					Name:  "strategy",
+					Usage: "How to replace the running containers: recreate, blue-green to start the new containers before removing the old ones, or rolling to replace them a batch at a time",
+					Value: "recreate",
+				},
+				cli.StringFlag{
+					Name:  "batch-size",
+					Usage: "Number of containers the rolling strategy replaces at a time",
+					Value: "1",
+				},
+				cli.StringFlag{
+					Name:  "pause",
+					Usage: "How long the rolling strategy waits between batches, e.g. 10s",
+				},
+				cli.StringFlag{
+					Name:  "rollout",
+					Usage: "How to deploy to several endpoints: sequential, one after the other, or parallel, all at once",
+					Value: "sequential",
+				},
+				cli.BoolFlag{
+					Name:  "stop-on-failure",
+					Usage: "Don't deploy to the remaining endpoints once one has failed (sequential rollouts only)",
+				},
+				cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "Show which containers would be created, recreated or removed and which images pulled, without changing anything",
+				},
+				cli.BoolFlag{
+					Name:  "gc-images",
+					Usage: "Remove the project's images no retained deployment uses once deployed",
+				},
+				cli.BoolFlag{
+					Name:  "no-cache",
+					Usage: "Build images without using the cache, even if nothing changed since the last build",
+				},
+				cli.BoolFlag{
+					Name:  "pull",
+					Usage: "Always pull newer versions of the base images when building, even if nothing changed since the last build",
+				},
+			},
+		},
+		{
+			Name:        "scale",
+			Usage:       "Change the number of containers running for services",
+			Description: "Specify service=count pairs as arguments, e.g. 'zodiac scale web=3 worker=2'. The scaled services are recorded as a new deployment.",
+			Action:      createHandler(actions.Scale),
+			Before:      requireCluster,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "message, m",
+					Usage: "Give your scaling a comment (defaults to 'Scale [service=count]')",
+				},
+				cli.StringFlag{
+					Name:   "name, n",
+					Usage:  "Specify a custom project name",
+					Value:  "zodiac",
+					EnvVar: "ZODIAC_PROJECT_NAME",
+				},
+				cli.StringFlag{
+					Name:  "file, f",
+					Usage: "Specify an alternate compose file",
+					Value: "docker-compose.yml",
+				},
 			},
 		},
 		{
@@ -62,6 +162,10 @@ func init() {
 					Name:  "message, m",
 					Usage: "Give your rollback a comment (defaults to 'Rollback to: [target deployment comment]')",
 				},
+				cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "Show which containers would be recreated or removed, without changing anything",
+				},
 				cli.StringFlag{
 					Name:   "name, n",
 					Usage:  "Specify a custom project name",
@@ -82,6 +186,239 @@ func init() {
 			Action:  createHandler(actions.List),
 			Before:  requireCluster,
 			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "changes",
+					Usage: "Summarise which services changed in each deployment",
+				},
+				cli.BoolFlag{
+					Name:  "show-secrets",
+					Usage: "Don't mask environment variables that look like passwords, tokens or keys",
+				},
+				cli.StringFlag{
+					Name:   "name, n",
+					Usage:  "Specify a custom project name",
+					Value:  "zodiac",
+					EnvVar: "ZODIAC_PROJECT_NAME",
+				},
+				cli.StringFlag{
+					Name:  "file, f",
+					Usage: "Specify an alternate compose file",
+					Value: "docker-compose.yml",
+				},
+			},
+			Subcommands: []cli.Command{
+				{
+					Name:        "migrate",
+					Usage:       "Move the deployment history to another history store",
+					Description: "Copies the deployment history from one store to another and removes it from the first. Moving into or out of the label store recreates the running containers.",
+					Action:      createHandler(actions.Migrate),
+					Before:      requireCluster,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "from",
+							Usage: "The history store to move from: label, file or container",
+							Value: "label",
+						},
+						cli.StringFlag{
+							Name:  "to",
+							Usage: "The history store to move to: label, file or container",
+						},
+						cli.StringFlag{
+							Name:   "name, n",
+							Usage:  "Specify a custom project name",
+							Value:  "zodiac",
+							EnvVar: "ZODIAC_PROJECT_NAME",
+						},
+						cli.StringFlag{
+							Name:  "file, f",
+							Usage: "Specify an alternate compose file",
+							Value: "docker-compose.yml",
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:        "status",
+			Usage:       "Show the state of the active deployment's containers",
+			Description: "Inspects every container of the active deployment and flags the ones that are missing, stopped or running a different image than was deployed.",
+			Action:      createHandler(actions.Status),
+			Before:      requireCluster,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "name, n",
+					Usage:  "Specify a custom project name",
+					Value:  "zodiac",
+					EnvVar: "ZODIAC_PROJECT_NAME",
+				},
+				cli.StringFlag{
+					Name:  "file, f",
+					Usage: "Specify an alternate compose file",
+					Value: "docker-compose.yml",
+				},
+			},
+		},
+		{
+			Name:        "reconcile",
+			Usage:       "Repair the containers of the active deployment",
+			Description: "Recreates the containers of the active deployment that are missing, stopped or have drifted from the deployed image or config, and removes project containers that aren't part of the deployment. The repair is recorded as a new deployment.",
+			Action:      createHandler(actions.Reconcile),
+			Before:      requireCluster,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "message, m",
+					Usage: "Give your repair a comment (defaults to 'Reconcile: [changes]')",
+				},
+				cli.StringFlag{
+					Name:   "name, n",
+					Usage:  "Specify a custom project name",
+					Value:  "zodiac",
+					EnvVar: "ZODIAC_PROJECT_NAME",
+				},
+				cli.StringFlag{
+					Name:  "file, f",
+					Usage: "Specify an alternate compose file",
+					Value: "docker-compose.yml",
+				},
+			},
+		},
+		{
+			Name:        "show",
+			Usage:       "Show a deployment in full",
+			Description: "Specify the deployment ID as the argument to the show command. If the deployment ID is omitted, the active deployment is shown.",
+			Action:      createHandler(actions.Show),
+			Before:      requireCluster,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "config",
+					Usage: "Print the stored create config of each service as JSON",
+				},
+				cli.BoolFlag{
+					Name:  "show-secrets",
+					Usage: "Don't mask environment variables that look like passwords, tokens or keys",
+				},
+				cli.StringFlag{
+					Name:   "name, n",
+					Usage:  "Specify a custom project name",
+					Value:  "zodiac",
+					EnvVar: "ZODIAC_PROJECT_NAME",
+				},
+				cli.StringFlag{
+					Name:  "file, f",
+					Usage: "Specify an alternate compose file",
+					Value: "docker-compose.yml",
+				},
+			},
+		},
+		{
+			Name:        "diff",
+			Usage:       "Compare two deployments",
+			Description: "Specify the IDs of the two deployments to compare, e.g. 'zodiac diff 12 14'. With one ID that deployment is compared with the current one, with none the previous deployment is.",
+			Action:      createHandler(actions.Diff),
+			Before:      requireCluster,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "show-secrets",
+					Usage: "Don't mask environment variables that look like passwords, tokens or keys",
+				},
+				cli.StringFlag{
+					Name:   "name, n",
+					Usage:  "Specify a custom project name",
+					Value:  "zodiac",
+					EnvVar: "ZODIAC_PROJECT_NAME",
+				},
+				cli.StringFlag{
+					Name:  "file, f",
+					Usage: "Specify an alternate compose file",
+					Value: "docker-compose.yml",
+				},
+			},
+		},
+		{
+			Name:  "images",
+			Usage: "Manage the project's images",
+			Subcommands: []cli.Command{
+				{
+					Name:        "gc",
+					Usage:       "Remove the project's images no retained deployment uses",
+					Description: "Removes the images built for the project and those its pruned deployments ran, unless a retained deployment uses them. Images containers still use are kept.",
+					Action:      createHandler(actions.ImagesGC),
+					Before:      requireCluster,
+					Flags: []cli.Flag{
+						cli.BoolFlag{
+							Name:  "dry-run",
+							Usage: "Show which images would be removed, without changing anything",
+						},
+						cli.StringFlag{
+							Name:   "name, n",
+							Usage:  "Specify a custom project name",
+							Value:  "zodiac",
+							EnvVar: "ZODIAC_PROJECT_NAME",
+						},
+						cli.StringFlag{
+							Name:  "file, f",
+							Usage: "Specify an alternate compose file",
+							Value: "docker-compose.yml",
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:        "prune",
+			Usage:       "Trim old deployments from the history",
+			Description: "Prunes the deployments neither --keep nor --older-than keeps, defaulting to the retention set in .zodiac.yml. The active deployment and protected ones are never pruned, and the other deployments keep their IDs. With the label history store the running containers are recreated, which takes --force.",
+			Action:      createHandler(actions.Prune),
+			Before:      requireCluster,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "keep",
+					Usage: "Number of the most recent deployments to keep",
+				},
+				cli.StringFlag{
+					Name:  "older-than",
+					Usage: "Prune deployments older than this, e.g. 30d or 12h",
+				},
+				cli.StringFlag{
+					Name:  "protect",
+					Usage: "IDs of deployments never to prune, e.g. 3,7",
+				},
+				cli.StringFlag{
+					Name:  "unprotect",
+					Usage: "IDs of protected deployments that may be pruned again",
+				},
+				cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "Show which deployments would be pruned, without changing anything",
+				},
+				cli.BoolFlag{
+					Name:  "force",
+					Usage: "Prune the label history store, recreating the running containers",
+				},
+				cli.StringFlag{
+					Name:   "name, n",
+					Usage:  "Specify a custom project name",
+					Value:  "zodiac",
+					EnvVar: "ZODIAC_PROJECT_NAME",
+				},
+				cli.StringFlag{
+					Name:  "file, f",
+					Usage: "Specify an alternate compose file",
+					Value: "docker-compose.yml",
+				},
+			},
+		},
+		{
+			Name:        "unlock",
+			Usage:       "Remove the lock a deploy holds on the project",
+			Description: "Deploy, rollback and teardown lock the project on the endpoint while they run. Shows who holds the lock, and removes it with --force, e.g. when a deploy was killed before it could release it.",
+			Action:      createHandler(actions.Unlock),
+			Before:      requireCluster,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "force",
+					Usage: "Remove the lock even though another deploy may still hold it",
+				},
 				cli.StringFlag{
 					Name:   "name, n",
 					Usage:  "Specify a custom project name",
@@ -117,6 +454,10 @@ func init() {
 					Usage: "specify confirmation up front instead of waiting for prompt",
 					Value: "y/N",
 				},
+				cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "Show which containers would be removed, without changing anything",
+				},
 			},
 		},
 	}
@@ -135,10 +476,15 @@ func main() {
 			Name:  "debug",
 			Usage: "Enable verbose logging",
 		},
-		cli.StringFlag{
-			Name:   "endpoint",
-			Usage:  "Docker endpoint",
-			EnvVar: "DOCKER_HOST",
+		cli.BoolFlag{
+			Name:   "docker-compose",
+			Usage:  "Run docker-compose to read the compose file instead of parsing it directly",
+			EnvVar: "ZODIAC_DOCKER_COMPOSE",
+		},
+		cli.GenericFlag{
+			Name:  "endpoint",
+			Usage: "Docker endpoint, defaults to $DOCKER_HOST. Deploy takes several, by repeating the flag or naming an endpoint group from .zodiac.yml",
+			Value: endpoints,
 		},
 		cli.BoolTFlag{
 			Name:   "tls",
@@ -165,6 +511,26 @@ func main() {
 			Usage: "Path to TLS key file",
 			Value: fmt.Sprintf("%s/key.pem", rootCertPath()),
 		},
+		cli.StringFlag{
+			Name:  "docker-config",
+			Usage: "Path to the Docker config file holding registry credentials (defaults to ~/.docker/config.json or ~/.dockercfg)",
+		},
+		cli.StringFlag{
+			Name:   "history",
+			Usage:  "Where to keep the deployment history: label, file or container",
+			Value:  "label",
+			EnvVar: "ZODIAC_HISTORY",
+		},
+		cli.StringFlag{
+			Name:   "target, t",
+			Usage:  "Use the settings of a target defined in .zodiac.yml or ~/.zodiac/config, e.g. staging",
+			EnvVar: "ZODIAC_TARGET",
+		},
+		cli.StringFlag{
+			Name:   "output, o",
+			Usage:  "Print results as json, yaml or go-template=<template> instead of text",
+			EnvVar: "ZODIAC_OUTPUT",
+		},
 	}
 
 	app.Run(os.Args)
@@ -186,8 +552,8 @@ func initializeCLI(c *cli.Context) error {
 }
 
 func requireCluster(c *cli.Context) error {
-	arg := c.GlobalString("endpoint")
-	if arg == "" {
+	// A target can name the endpoint, the handler checks it does.
+	if len(endpointHosts()) == 0 && c.GlobalString("target") == "" {
 		err := errors.New("you must specify a Docker endpoint to connect to")
 		log.Error(err)
 		return err
@@ -200,8 +566,9 @@ func createHandlerWithConfirm(z actions.Zodiaction, msg string) func(c *cli.Cont
 	return func(c *cli.Context) {
 		cfrm := strings.ToLower(c.String("confirm"))
 
-		if cfrm == "y" || cfrm == "yes" {
-			handler(z, c)
+		// A dry run changes nothing, so there's nothing to confirm.
+		if cfrm == "y" || cfrm == "yes" || c.Bool("dry-run") {
+			handler(z, c, false)
 		} else {
 			fmt.Println(fmt.Sprintf("%s (y/N)", msg))
 			var response string
@@ -215,7 +582,7 @@ func createHandlerWithConfirm(z actions.Zodiaction, msg string) func(c *cli.Cont
 			if response != "y" && response != "yes" {
 				fmt.Println("Cancelled")
 			} else {
-				handler(z, c)
+				handler(z, c, false)
 			}
 		}
 	}
@@ -223,20 +590,50 @@ func createHandlerWithConfirm(z actions.Zodiaction, msg string) func(c *cli.Cont
 
 func createHandler(z actions.Zodiaction) func(c *cli.Context) {
 	return func(c *cli.Context) {
-		handler(z, c)
+		handler(z, c, false)
 	}
 }
 
-func handler(z actions.Zodiaction, c *cli.Context) {
+// createMultiEndpointHandler is for the actions that can run against several
+// endpoints at once.
+func createMultiEndpointHandler(z actions.Zodiaction) func(c *cli.Context) {
+	return func(c *cli.Context) {
+		handler(z, c, true)
+	}
+}
+
+// endpointHosts lists the --endpoint flags, or DOCKER_HOST without them.
+func endpointHosts() []string {
+	if len(*endpoints) > 0 {
+		return *endpoints
+	}
+
+	var fromEnv endpointList
+	fromEnv.Set(os.Getenv("DOCKER_HOST"))
+	return fromEnv
+}
+
+func handler(z actions.Zodiaction, c *cli.Context, multiEndpoint bool) {
 	flags := map[string]string{}
 
+	// Keep stdout for the formatted result so it can be piped.
+	format := c.GlobalString("output")
+	if format != "" {
+		actions.Progress = os.Stderr
+		composer.Progress = os.Stderr
+		proxy.Progress = os.Stderr
+	}
+
+	if c.GlobalBool("docker-compose") {
+		actions.DefaultComposer = composer.NewExecComposer(actions.ProxyAddress)
+	}
+
 	//TODO: is this a codegangsta bug, GlobalFlagNames?
 	for _, flagName := range c.GlobalFlagNames() {
 		flags[flagName] = c.String(flagName)
 	}
 
 	eOpts := endpoint.EndpointOptions{
-		Host:      c.GlobalString("endpoint"),
 		TLS:       c.GlobalBool("tls"),
 		TLSVerify: c.GlobalBool("tlsverify"),
 		TLSCaCert: c.GlobalString("tlscacert"),
@@ -248,13 +645,92 @@ func handler(z actions.Zodiaction, c *cli.Context) {
 		Args:            c.Args(),
 		Flags:           flags,
 		EndpointOptions: eOpts,
+		DockerConfig:    c.GlobalString("docker-config"),
+		History:         c.GlobalString("history"),
 	}
 
+	requested := endpointHosts()
+	if name := c.GlobalString("target"); name != "" {
+		target, err := actions.LoadTarget(flags, name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+		applyTarget(c, target, &actionOpts)
+		actionOpts.Target = name
+
+		if len(*endpoints) == 0 && target.Endpoint != "" {
+			var fromTarget endpointList
+			fromTarget.Set(target.Endpoint)
+			requested = fromTarget
+		}
+	}
+
+	hosts, err := actions.EndpointHosts(flags, requested)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+	if len(hosts) == 0 {
+		fmt.Fprintln(os.Stderr, "Error: you must specify a Docker endpoint to connect to")
+		os.Exit(1)
+	}
+	if len(hosts) > 1 && !multiEndpoint {
+		fmt.Fprintln(os.Stderr, "Error: only deploy can run against more than one endpoint")
+		os.Exit(1)
+	}
+	actionOpts.EndpointOptions.Host = hosts[0]
+	actionOpts.Endpoints = hosts
+
 	o, err := z(actionOpts)
 	if err != nil {
-		fmt.Printf("Error: %s\n", err)
+		// Some actions still have results to show, like the endpoints a
+		// deploy did succeed on.
+		if o != nil {
+			if out, err := actions.FormatOutput(o, format); err == nil {
+				fmt.Println(out)
+			}
+		}
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	out, err := actions.FormatOutput(o, format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
 		os.Exit(1)
 	}
 
-	fmt.Println(o.ToPrettyOutput())
+	fmt.Println(out)
+}
+
+// applyTarget overrides the settings with the target's. Only flags given on
+// the command line take precedence over a target, environment variables
+// don't.
+func applyTarget(c *cli.Context, t actions.Target, opts *actions.Options) {
+	for k, v := range t.Flags() {
+		if _, ok := opts.Flags[k]; ok && !c.IsSet(k) {
+			opts.Flags[k] = v
+		}
+	}
+
+	eo := &opts.EndpointOptions
+	if t.TLS != nil && !c.GlobalIsSet("tls") {
+		eo.TLS = *t.TLS
+	}
+	if t.TLSVerify != nil && !c.GlobalIsSet("tlsverify") {
+		eo.TLSVerify = *t.TLSVerify
+	}
+	if t.TLSCaCert != "" && !c.GlobalIsSet("tlscacert") {
+		eo.TLSCaCert = t.TLSCaCert
+	}
+	if t.TLSCert != "" && !c.GlobalIsSet("tlscert") {
+		eo.TLSCert = t.TLSCert
+	}
+	if t.TLSKey != "" && !c.GlobalIsSet("tlskey") {
+		eo.TLSKey = t.TLSKey
+	}
+	if t.History != "" && !c.GlobalIsSet("history") {
+		opts.History = t.History
+	}
 }
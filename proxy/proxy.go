@@ -1,12 +1,16 @@
 package proxy
 
 import (
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"os"
+	"strconv"
 	"strings"
 
 	"github.com/CenturyLinkLabs/zodiac/endpoint"
@@ -18,9 +22,20 @@ import (
 type ContainerRequest struct {
 	Name          string
 	CreateOptions []byte
+	// ImageID is the image built for the container, if it was built.
+	ImageID string
+	Build   *BuildInfo
 }
 
-type ProxyFactory func(string, endpoint.Endpoint, bool) Proxy
+// BuildInfo records what an image was built from, so an unchanged build can
+// be skipped.
+type BuildInfo struct {
+	ContextHash string
+	Dockerfile  string            `json:",omitempty"`
+	Args        map[string]string `json:",omitempty"`
+}
+
+type ProxyFactory func(string, endpoint.Endpoint, bool, endpoint.BuildOptions) Proxy
 
 type Proxy interface {
 	Serve() error
@@ -28,21 +43,36 @@ type Proxy interface {
 	GetRequests() ([]ContainerRequest, error)
 }
 
+// Progress receives the output of the images built for compose.
+var Progress io.Writer = os.Stdout
+
 type HTTPProxy struct {
 	address            string
 	containerRequests  []ContainerRequest
 	listener           *net.TCPListener
 	errors             []error
+	buildErr           error
+	builtImages        map[string]builtImage
 	imageInspectsCount map[string]int
 	endpoint           endpoint.Endpoint
 	noBuild            bool
+	buildOptions       endpoint.BuildOptions
+}
+
+type builtImage struct {
+	id    string
+	build BuildInfo
 }
 
-func NewHTTPProxy(listenAt string, endpoint endpoint.Endpoint, noBuild bool) Proxy {
+// NewHTTPProxy captures the requests of compose. Its builds are run on the
+// endpoint with the parameters compose sends, and buildOptions' NoCache and
+// Pull on top.
+func NewHTTPProxy(listenAt string, endpoint endpoint.Endpoint, noBuild bool, buildOptions endpoint.BuildOptions) Proxy {
 	return &HTTPProxy{
-		address:  listenAt,
-		endpoint: endpoint,
-		noBuild:  noBuild,
+		address:      listenAt,
+		endpoint:     endpoint,
+		noBuild:      noBuild,
+		buildOptions: buildOptions,
 	}
 }
 
@@ -71,6 +101,9 @@ func (p *HTTPProxy) Stop() error {
 }
 
 func (p *HTTPProxy) GetRequests() ([]ContainerRequest, error) {
+	if p.buildErr != nil {
+		return nil, p.buildErr
+	}
 	if len(p.errors) > 0 {
 		// TODO: collect errors?
 		return nil, errors.New("Error parsing compose template")
@@ -95,6 +128,13 @@ func (p *HTTPProxy) create(w http.ResponseWriter, r *http.Request) {
 		Name:          name,
 		CreateOptions: body,
 	}
+	var cc struct{ Image string }
+	if json.Unmarshal(body, &cc) == nil {
+		if built, ok := p.builtImages[cc.Image]; ok {
+			req.ImageID = built.id
+			req.Build = &built.build
+		}
+	}
 
 	p.containerRequests = append(p.containerRequests, req)
 
@@ -108,7 +148,7 @@ func (p *HTTPProxy) inspect(w http.ResponseWriter, r *http.Request) {
 		Name: "name_doesnt_matter",
 		Config: &dockerclient.ContainerConfig{
 			Labels: map[string]string{
-				"com.docker.compose.container-number": "1",
+				"com.docker.compose.container-number": containerNumber(mux.Vars(r)["id"]),
 			},
 		},
 	}
@@ -137,12 +177,14 @@ func (p *HTTPProxy) listAll(w http.ResponseWriter, r *http.Request) {
 
 	for _, req := range p.containerRequests {
 		if (filters == nil) || (extractReqName(req.Name) == name) {
+			// compose resolves volumes_from services to the IDs listed here, so
+			// hand back the container name for the deploy to map back to a service.
 			container := dockerclient.Container{
-				Id:    "abc123",
+				Id:    req.Name,
 				Image: "doesntmatter",
 				Names: []string{req.Name},
 				Labels: map[string]string{
-					"com.docker.compose.container-number": "1",
+					"com.docker.compose.container-number": containerNumber(req.Name),
 				},
 			}
 			containers = append(containers, container)
@@ -191,16 +233,42 @@ func (p *HTTPProxy) createImage(w http.ResponseWriter, r *http.Request) {
 
 func (p *HTTPProxy) build(w http.ResponseWriter, r *http.Request) {
 	log.Infof("BUILD REQUEST to %s", r.URL)
-	svcName := r.URL.Query()["t"][0]
+	query := r.URL.Query()
+	name := query.Get("t")
 
-	if !p.noBuild {
-		err := p.endpoint.BuildImage(r.Body, svcName)
-		if err != nil {
-			log.Fatal(err)
+	if p.noBuild {
+		fmt.Fprintf(w, `{"stream":"Successfully built abc123\n"}`)
+		return
+	}
+
+	opts := p.buildOptions
+	opts.Params = query
+	info := BuildInfo{Dockerfile: query.Get("dockerfile")}
+	if args := query.Get("buildargs"); args != "" {
+		if err := json.Unmarshal([]byte(args), &info.Args); err != nil {
+			p.errors = append(p.errors, err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
 		}
 	}
 
-	fmt.Fprintf(w, `{"stream":"Successfully built abc123\n"}`)
+	// The context compose sends is hashed on the way through.
+	hash := sha256.New()
+	id, err := p.endpoint.BuildImage(io.TeeReader(r.Body, hash), name, opts, Progress)
+	if err != nil {
+		// Compose gives up on the error, and the deploy reports it.
+		p.buildErr = err
+		msg, _ := json.Marshal(err.Error())
+		fmt.Fprintf(w, `{"errorDetail":{"message":%s},"error":%s}`, msg, msg)
+		return
+	}
+
+	info.ContextHash = fmt.Sprintf("sha256:%x", hash.Sum(nil))
+	if p.builtImages == nil {
+		p.builtImages = map[string]builtImage{}
+	}
+	p.builtImages[name] = builtImage{id: id, build: info}
+	fmt.Fprintf(w, `{"stream":"Successfully built %s\n"}`, id)
 }
 
 func extractReqName(reqName string) string {
@@ -209,6 +277,16 @@ func extractReqName(reqName string) string {
 	return strings.Join(newParts, "_")
 }
 
+// containerNumber reads the instance number off the end of a compose
+// container name, falling back to 1 for anything else.
+func containerNumber(name string) string {
+	parts := strings.Split(name, "_")
+	if _, err := strconv.Atoi(parts[len(parts)-1]); err != nil || len(parts) < 3 {
+		return "1"
+	}
+	return parts[len(parts)-1]
+}
+
 func filteredServiceName(filters []string) string {
 	if filters != nil {
 		filter := filters[0]
@@ -4,11 +4,14 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/CenturyLinkLabs/zodiac/endpoint"
 	"github.com/samalba/dockerclient"
@@ -23,6 +26,19 @@ func init() {
 	fakeEndpoint = &endpoint.DockerEndpoint{}
 }
 
+// startProxy serves in the background and waits until the proxy accepts
+// connections so requests don't race the listener.
+func startProxy(p *HTTPProxy) {
+	go p.Serve()
+	for i := 0; i < 100; i++ {
+		if conn, err := net.Dial("tcp", p.address); err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
 func TestGetRequests_WithNoErrors(t *testing.T) {
 	proxy := HTTPProxy{
 		containerRequests: []ContainerRequest{
@@ -57,7 +73,7 @@ func TestCreate(t *testing.T) {
 		address: "localhost:61900",
 	}
 
-	go proxy.Serve()
+	startProxy(&proxy)
 	defer proxy.Stop()
 
 	resp, err := http.Post("http://localhost:61900/v1.19/containers/create?name=foo", "", strings.NewReader("bar"))
@@ -78,7 +94,7 @@ func TestInspect(t *testing.T) {
 		address: "localhost:61901",
 	}
 
-	go proxy.Serve()
+	startProxy(&proxy)
 	defer proxy.Stop()
 
 	resp, err := http.Get("http://localhost:61901/v1.19/containers/foo/json")
@@ -106,7 +122,7 @@ func TestStart(t *testing.T) {
 		address: "localhost:61902",
 	}
 
-	go proxy.Serve()
+	startProxy(&proxy)
 	defer proxy.Stop()
 
 	resp, err := http.Post("http://localhost:61902/v1.19/containers/foo/start", "", nil)
@@ -120,7 +136,7 @@ func TestListAll_NoContainersCreated(t *testing.T) {
 		address: "localhost:61903",
 	}
 
-	go proxy.Serve()
+	startProxy(&proxy)
 	defer proxy.Stop()
 
 	resp, err := http.Get("http://localhost:61903/v1.19/containers/json")
@@ -141,7 +157,7 @@ func TestListAll_WithContainersCreated(t *testing.T) {
 		},
 	}
 
-	go proxy.Serve()
+	startProxy(&proxy)
 	defer proxy.Stop()
 
 	resp, err := http.Get("http://localhost:61904/v1.15/containers/json")
@@ -156,9 +172,34 @@ func TestListAll_WithContainersCreated(t *testing.T) {
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 	assert.Len(t, cs, 2)
 	assert.Equal(t, []string{"zodiac_foo_1"}, cs[0].Names)
+	assert.Equal(t, "zodiac_foo_1", cs[0].Id)
 	assert.Equal(t, []string{"zodiac_bar_1"}, cs[1].Names)
 }
 
+func TestListAll_ReportsContainerNumbers(t *testing.T) {
+	proxy := HTTPProxy{
+		address: "localhost:61907",
+		containerRequests: []ContainerRequest{
+			{Name: "zodiac_web_1"},
+			{Name: "zodiac_web_2"},
+		},
+	}
+
+	startProxy(&proxy)
+	defer proxy.Stop()
+
+	resp, err := http.Get("http://localhost:61907/v1.19/containers/json")
+	assert.NoError(t, err)
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	var cs []dockerclient.Container
+	json.Unmarshal(body, &cs)
+
+	assert.Len(t, cs, 2)
+	assert.Equal(t, "1", cs[0].Labels["com.docker.compose.container-number"])
+	assert.Equal(t, "2", cs[1].Labels["com.docker.compose.container-number"])
+}
+
 func TestListAll_WithMatchingFilteredRequest(t *testing.T) {
 	proxy := HTTPProxy{
 		address: "localhost:61905",
@@ -168,7 +209,7 @@ func TestListAll_WithMatchingFilteredRequest(t *testing.T) {
 		},
 	}
 
-	go proxy.Serve()
+	startProxy(&proxy)
 	defer proxy.Stop()
 
 	query := url.QueryEscape(`{"label": ["com.docker.compose.project=zodiac", "com.docker.compose.service=fiz_biz", "com.docker.compose.oneoff=False"]}`)
@@ -196,7 +237,7 @@ func TestListAll_WithNonMatchingFilteredRequest(t *testing.T) {
 		},
 	}
 
-	go proxy.Serve()
+	startProxy(&proxy)
 	defer proxy.Stop()
 
 	query := url.QueryEscape(`{"label": ["com.docker.compose.project=zodiac", "com.docker.compose.service=DOES_NOT_MATCH", "com.docker.compose.oneoff=False"]}`)
@@ -213,3 +254,77 @@ func TestListAll_WithNonMatchingFilteredRequest(t *testing.T) {
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 	assert.Len(t, cs, 0)
 }
+
+type mockBuildEndpoint struct {
+	endpoint.Endpoint
+	err  error
+	opts *endpoint.BuildOptions
+}
+
+func (e mockBuildEndpoint) BuildImage(r io.Reader, name string, opts endpoint.BuildOptions, out io.Writer) (string, error) {
+	ioutil.ReadAll(r)
+	if e.opts != nil {
+		*e.opts = opts
+	}
+	if e.err != nil {
+		return "", e.err
+	}
+	fmt.Fprintf(out, "Successfully built def456\n")
+	return "def456", nil
+}
+
+func TestBuild_RecordsImageID(t *testing.T) {
+	Progress = ioutil.Discard
+	var opts endpoint.BuildOptions
+	proxy := HTTPProxy{
+		address:      "localhost:61909",
+		endpoint:     mockBuildEndpoint{opts: &opts},
+		buildOptions: endpoint.BuildOptions{NoCache: true},
+	}
+
+	startProxy(&proxy)
+	defer proxy.Stop()
+
+	query := url.Values{"t": {"app_web"}, "dockerfile": {"Dockerfile.prod"}, "buildargs": {`{"VERSION":"1.2"}`}}
+	resp, err := http.Post("http://localhost:61909/v1.19/build?"+query.Encode(), "application/tar", strings.NewReader("context"))
+	assert.NoError(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, `{"stream":"Successfully built def456\n"}`, string(body))
+
+	_, err = http.Post("http://localhost:61909/v1.19/containers/create?name=app_web_1", "", strings.NewReader(`{"Image": "app_web"}`))
+	assert.NoError(t, err)
+
+	reqs, err := proxy.GetRequests()
+	assert.NoError(t, err)
+	assert.Equal(t, "def456", reqs[0].ImageID)
+	assert.Equal(t, &BuildInfo{
+		ContextHash: "sha256:ea7792a26f405e2ae9c6f49ca93bbe6076ceac0a1fc53d83426c7d7f2d9377e4",
+		Dockerfile:  "Dockerfile.prod",
+		Args:        map[string]string{"VERSION": "1.2"},
+	}, reqs[0].Build)
+	assert.True(t, opts.NoCache)
+	assert.Equal(t, "Dockerfile.prod", opts.Params.Get("dockerfile"))
+}
+
+func TestBuild_Failure(t *testing.T) {
+	buildErr := endpoint.BuildError{Name: "app_web", Message: "The command '/bin/sh -c false' returned a non-zero code: 1"}
+	proxy := HTTPProxy{
+		address:  "localhost:61910",
+		endpoint: mockBuildEndpoint{err: buildErr},
+	}
+
+	startProxy(&proxy)
+	defer proxy.Stop()
+
+	resp, err := http.Post("http://localhost:61910/v1.19/build?t=app_web", "application/tar", strings.NewReader(""))
+	assert.NoError(t, err)
+
+	var msg struct {
+		Error string
+	}
+	json.NewDecoder(resp.Body).Decode(&msg)
+	assert.Equal(t, buildErr.Error(), msg.Error)
+
+	_, err = proxy.GetRequests()
+	assert.Equal(t, buildErr, err)
+}